@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"order/config"
+	"order/models"
+	"order/problem"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// APIKeyHeader carries an API key when it isn't sent as a bearer token
+const APIKeyHeader = "X-API-Key"
+
+// leeway absorbs clock skew between the token issuer and this service
+const leeway = 30 * time.Second
+
+var errMissingCredentials = errors.New("missing credentials")
+
+// Claims are the bearer token claims the service understands. Scopes are
+// read from the space separated scope claim or the scp array.
+type Claims struct {
+	jwt.RegisteredClaims
+	Role       string   `json:"role,omitempty"`
+	Scope      string   `json:"scope,omitempty"`
+	Scp        []string `json:"scp,omitempty"`
+	CustomerID string   `json:"customer_id,omitempty"`
+}
+
+// Authenticator verifies the API keys and bearer tokens of requests
+type Authenticator struct {
+	db *sql.DB
+	// keyfunc and parser are nil when bearer tokens are disabled
+	keyfunc jwt.Keyfunc
+	parser  *jwt.Parser
+	jwks    *keyfunc.JWKS
+}
+
+// NewAuthenticator returns an authenticator checking API keys against db
+// and bearer tokens with the configured key. A JWKS is fetched before it
+// returns and refreshed in the background until Close.
+func NewAuthenticator(db *sql.DB, cfg config.JWTConfig) (*Authenticator, error) {
+	a := &Authenticator{db: db}
+	if !cfg.Enabled() {
+		return a, nil
+	}
+
+	var methods []string
+	switch {
+	case cfg.JWKSURL != "":
+		jwks, err := keyfunc.Get(cfg.JWKSURL, keyfunc.Options{
+			RefreshInterval:   cfg.JWKSRefreshInterval,
+			RefreshRateLimit:  time.Minute,
+			RefreshTimeout:    10 * time.Second,
+			RefreshUnknownKID: true,
+			RefreshErrorHandler: func(err error) {
+				slog.Error("Unable to refresh JWKS", "url", cfg.JWKSURL, "error", err)
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch JWKS: %v", err)
+		}
+		a.jwks = jwks
+		a.keyfunc = jwks.Keyfunc
+		methods = asymmetricMethods
+	case cfg.PublicKeyFile != "":
+		key, err := readPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keyfunc = func(*jwt.Token) (interface{}, error) { return key, nil }
+		methods = methodsFor(key)
+	default:
+		secret := []byte(cfg.HMACSecret)
+		a.keyfunc = func(*jwt.Token) (interface{}, error) { return secret, nil }
+		methods = []string{"HS256", "HS384", "HS512"}
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired(), jwt.WithLeeway(leeway)}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	a.parser = jwt.NewParser(opts...)
+	return a, nil
+}
+
+// Close stops refreshing the JWKS
+func (a *Authenticator) Close() {
+	if a.jwks != nil {
+		a.jwks.EndBackground()
+	}
+}
+
+var asymmetricMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// methodsFor limits the signing methods to those of the key's type, so a
+// token can't pick an algorithm the key wasn't meant for.
+func methodsFor(key interface{}) []string {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		return []string{"ES256", "ES384", "ES512"}
+	default:
+		return []string{"EdDSA"}
+	}
+}
+
+// readPublicKey reads a PEM encoded PKIX or PKCS #1 public key
+func readPublicKey(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JWT public key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in JWT public key file %s", path)
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse JWT public key: %v", err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported JWT public key type %T", key)
+}
+
+// Authenticate returns the principal of the request's credentials. An
+// *AuthError reports credentials that are missing or invalid; any other
+// error is a failure to check them.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	return a.AuthenticateCredential(r.Context(), Credential(r.Header.Get(APIKeyHeader), r.Header.Get("Authorization")))
+}
+
+// Credential returns the credential sent as an API key header or, failing
+// that, as a bearer token in an Authorization header
+func Credential(apiKey, authorization string) string {
+	if apiKey != "" {
+		return apiKey
+	}
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// AuthenticateCredential returns the principal of an API key or bearer
+// token, failing as Authenticate does
+func (a *Authenticator) AuthenticateCredential(ctx context.Context, credential string) (*Principal, error) {
+	if credential == "" {
+		return nil, &AuthError{errMissingCredentials}
+	}
+
+	if IsAPIKey(credential) {
+		principal, err := authenticateAPIKey(ctx, a.db, credential)
+		if err == errUnknownAPIKey {
+			return nil, &AuthError{err}
+		}
+		return principal, err
+	}
+
+	principal, err := a.verifyToken(credential)
+	if err != nil {
+		return nil, &AuthError{err}
+	}
+	return principal, nil
+}
+
+func (a *Authenticator) verifyToken(token string) (*Principal, error) {
+	if a.parser == nil {
+		return nil, errors.New("bearer tokens are not accepted")
+	}
+	var claims Claims
+	if _, err := a.parser.ParseWithClaims(token, &claims, a.keyfunc); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	if _, ok := models.RolePermissions[claims.Role]; !ok {
+		return nil, fmt.Errorf("unknown role %q", claims.Role)
+	}
+
+	principal := &Principal{Subject: claims.Subject, Method: MethodJWT, Role: claims.Role, Scopes: claims.Scp}
+	if claims.Scope != "" {
+		principal.Scopes = strings.Fields(claims.Scope)
+	}
+	if claims.CustomerID != "" {
+		customerID, err := uuid.Parse(claims.CustomerID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid customer_id claim: %v", err)
+		}
+		principal.CustomerID = &customerID
+	}
+	if principal.Role == models.RoleCustomer && principal.CustomerID == nil {
+		return nil, errors.New("customer token has no customer_id")
+	}
+	return principal, nil
+}
+
+// AuthError is returned for credentials that are missing or invalid
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// Middleware rejects requests without valid credentials and puts the
+// principal of the others in their context.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.Authenticate(r)
+		var authErr *AuthError
+		if errors.As(err, &authErr) {
+			slog.InfoContext(r.Context(), "Request not authenticated", "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="order-service"`)
+			problem.Write(w, r, http.StatusUnauthorized, models.CodeUnauthenticated, "Authentication required")
+			return
+		}
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to authenticate request")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// Authenticated only lets authenticated principals through to h, for
+// routes whose handler checks the permissions of what is asked of it
+func Authenticated(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if FromContext(r.Context()) == nil {
+			problem.Write(w, r, http.StatusUnauthorized, models.CodeUnauthenticated, "Authentication required")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Require only lets principals granted the permission through to h. On
+// routes with a {customer_id}, customer principals must also be that
+// customer.
+func Require(permission string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := FromContext(r.Context())
+		if principal == nil {
+			problem.Write(w, r, http.StatusUnauthorized, models.CodeUnauthenticated, "Authentication required")
+			return
+		}
+		if !principal.Can(permission) {
+			problem.Write(w, r, http.StatusForbidden, models.CodeForbidden, "Missing permission "+permission)
+			return
+		}
+		if raw, ok := mux.Vars(r)["customer_id"]; ok {
+			if customerID, err := uuid.Parse(raw); err == nil && !principal.MayAccessCustomer(customerID) {
+				problem.Write(w, r, http.StatusForbidden, models.CodeForbidden, "Access to other customers is not allowed")
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
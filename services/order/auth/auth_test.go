@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"order/config"
+	"order/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const hmacSecret = "0123456789abcdef0123456789abcdef"
+
+var apiKeyRows = []string{"id", "name", "key_prefix", "role", "scopes", "customer_id", "created_at", "revoked_at"}
+
+// serve runs the request through the middleware and returns the response
+// and the principal the handler saw
+func serve(a *Authenticator, req *http.Request, scope string) (*httptest.ResponseRecorder, *Principal) {
+	var principal *Principal
+	handler := a.Middleware(Require(scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = FromContext(r.Context())
+	})))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, principal
+}
+
+func signedToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func claims(extra jwt.MapClaims) jwt.MapClaims {
+	c := jwt.MapClaims{"sub": "user-1", "role": models.RoleService, "exp": time.Now().Add(time.Hour).Unix()}
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
+
+func TestAPIKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	a, err := NewAuthenticator(db, config.JWTConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := uuid.New()
+	customerID := uuid.New()
+
+	t.Run("bearer API key", func(t *testing.T) {
+		mock.ExpectQuery("SELECT (.+) FROM api_keys WHERE key_hash = \\$1 AND revoked_at IS NULL").
+			WithArgs(HashAPIKey(key)).
+			WillReturnRows(sqlmock.NewRows(apiKeyRows).
+				AddRow(keyID, "storefront", key[:12], "customer", []byte(`[]`), customerID, time.Now(), nil))
+
+		req := httptest.NewRequest("GET", "/order/1", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rr, principal := serve(a, req, models.ScopeOrdersRead)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		if !assert.NotNil(t, principal) {
+			return
+		}
+		assert.Equal(t, keyID.String(), principal.Subject)
+		assert.Equal(t, MethodAPIKey, principal.Method)
+		assert.Equal(t, models.RoleCustomer, principal.Role)
+		assert.Equal(t, &customerID, principal.CustomerID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("scopes narrow the role", func(t *testing.T) {
+		mock.ExpectQuery("SELECT (.+) FROM api_keys").
+			WithArgs(HashAPIKey(key)).
+			WillReturnRows(sqlmock.NewRows(apiKeyRows).
+				AddRow(keyID, "reporting", key[:12], "admin", []byte(`["orders:read"]`), nil, time.Now(), nil))
+
+		req := httptest.NewRequest("POST", "/product", nil)
+		req.Header.Set(APIKeyHeader, key)
+		rr, _ := serve(a, req, models.ScopeProductsWrite)
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, models.ProblemContentType, rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), `"code":"forbidden"`)
+		assert.Contains(t, rr.Body.String(), "Missing permission products:write")
+	})
+
+	t.Run("unknown or revoked key", func(t *testing.T) {
+		mock.ExpectQuery("SELECT (.+) FROM api_keys").
+			WithArgs(HashAPIKey(key)).
+			WillReturnRows(sqlmock.NewRows(apiKeyRows))
+
+		req := httptest.NewRequest("GET", "/order/1", nil)
+		req.Header.Set(APIKeyHeader, key)
+		rr, principal := serve(a, req, models.ScopeOrdersRead)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.NotEmpty(t, rr.Header().Get("WWW-Authenticate"))
+		assert.Contains(t, rr.Body.String(), `"code":"unauthenticated"`)
+		assert.Nil(t, principal)
+	})
+
+	t.Run("database unavailable", func(t *testing.T) {
+		mock.ExpectQuery("SELECT (.+) FROM api_keys").
+			WithArgs(HashAPIKey(key)).
+			WillReturnError(sqlmock.ErrCancelled)
+
+		req := httptest.NewRequest("GET", "/order/1", nil)
+		req.Header.Set(APIKeyHeader, key)
+		rr, _ := serve(a, req, models.ScopeOrdersRead)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		rr, _ := serve(a, httptest.NewRequest("GET", "/order/1", nil), models.ScopeOrdersRead)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("bearer token when only API keys are accepted", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/order/1", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(t, jwt.SigningMethodHS256, []byte(hmacSecret), "", claims(nil)))
+		rr, _ := serve(a, req, models.ScopeOrdersRead)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+}
+
+func TestHMACTokens(t *testing.T) {
+	a, err := NewAuthenticator(nil, config.JWTConfig{HMACSecret: hmacSecret, Issuer: "https://auth.example.com", Audience: "order-service"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customerID := uuid.New()
+	valid := claims(jwt.MapClaims{"iss": "https://auth.example.com", "aud": "order-service", "role": models.RoleCustomer, "scope": "orders:read orders:write", "customer_id": customerID.String()})
+
+	t.Run("valid token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/order/1", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(t, jwt.SigningMethodHS256, []byte(hmacSecret), "", valid))
+		rr, principal := serve(a, req, models.ScopeOrdersWrite)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		if !assert.NotNil(t, principal) {
+			return
+		}
+		assert.Equal(t, "user-1", principal.Subject)
+		assert.Equal(t, MethodJWT, principal.Method)
+		assert.Equal(t, []string{"orders:read", "orders:write"}, principal.Scopes)
+		assert.Equal(t, &customerID, principal.CustomerID)
+	})
+
+	rejected := map[string]string{
+		"wrong secret":                 signedToken(t, jwt.SigningMethodHS256, []byte("another secret that is long enough"), "", valid),
+		"expired":                      signedToken(t, jwt.SigningMethodHS256, []byte(hmacSecret), "", claims(jwt.MapClaims{"iss": "https://auth.example.com", "aud": "order-service", "exp": time.Now().Add(-time.Hour).Unix()})),
+		"no expiry":                    signedToken(t, jwt.SigningMethodHS256, []byte(hmacSecret), "", jwt.MapClaims{"sub": "user-1", "iss": "https://auth.example.com", "aud": "order-service"}),
+		"wrong issuer":                 signedToken(t, jwt.SigningMethodHS256, []byte(hmacSecret), "", claims(jwt.MapClaims{"iss": "https://evil.example.com", "aud": "order-service"})),
+		"wrong audience":               signedToken(t, jwt.SigningMethodHS256, []byte(hmacSecret), "", claims(jwt.MapClaims{"iss": "https://auth.example.com", "aud": "billing"})),
+		"malformed":                    "not.a.token",
+		"unknown role":                 signedToken(t, jwt.SigningMethodHS256, []byte(hmacSecret), "", claims(jwt.MapClaims{"iss": "https://auth.example.com", "aud": "order-service", "role": "root"})),
+		"customer without customer_id": signedToken(t, jwt.SigningMethodHS256, []byte(hmacSecret), "", claims(jwt.MapClaims{"iss": "https://auth.example.com", "aud": "order-service", "role": models.RoleCustomer})),
+	}
+	for name, token := range rejected {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/order/1", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr, principal := serve(a, req, models.ScopeOrdersRead)
+
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+			assert.Nil(t, principal)
+		})
+	}
+}
+
+func TestPublicKeyTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewAuthenticator(nil, config.JWTConfig{PublicKeyFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("signed with the key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/product/1", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(t, jwt.SigningMethodRS256, key, "", claims(jwt.MapClaims{"scp": []string{"products:read"}})))
+		rr, principal := serve(a, req, models.ScopeProductsRead)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		if !assert.NotNil(t, principal) {
+			return
+		}
+		assert.Equal(t, []string{"products:read"}, principal.Scopes)
+	})
+
+	t.Run("HMAC signed with the public key", func(t *testing.T) {
+		// The classic algorithm confusion attack
+		req := httptest.NewRequest("GET", "/product/1", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(t, jwt.SigningMethodHS256, der, "", claims(jwt.MapClaims{"scp": []string{"products:read"}})))
+		rr, _ := serve(a, req, models.ScopeProductsRead)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+}
+
+func TestJWKSTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	a, err := NewAuthenticator(nil, config.JWTConfig{JWKSURL: server.URL, JWKSRefreshInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	t.Run("known key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/order/1", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(t, jwt.SigningMethodRS256, key, "key-1", claims(jwt.MapClaims{"scope": "orders:read"})))
+		rr, principal := serve(a, req, models.ScopeOrdersRead)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		if !assert.NotNil(t, principal) {
+			return
+		}
+		assert.Nil(t, principal.CustomerID)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/order/1", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(t, jwt.SigningMethodRS256, key, "key-2", claims(jwt.MapClaims{"scope": "orders:read"})))
+		rr, _ := serve(a, req, models.ScopeOrdersRead)
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+}
+
+func TestRoles(t *testing.T) {
+	a, err := NewAuthenticator(nil, config.JWTConfig{HMACSecret: hmacSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customerID := uuid.New()
+
+	tests := []struct {
+		name       string
+		claims     jwt.MapClaims
+		permission string
+		path       string
+		want       int
+	}{
+		{"admin creates products", jwt.MapClaims{"role": models.RoleAdmin}, models.ScopeProductsWrite, "/product", http.StatusOK},
+		{"support can't create products", jwt.MapClaims{"role": models.RoleSupport}, models.ScopeProductsWrite, "/product", http.StatusForbidden},
+		{"service can't manage webhooks", jwt.MapClaims{"role": models.RoleService}, models.ScopeWebhooks, "/webhooks", http.StatusForbidden},
+		{"customer reads own addresses", jwt.MapClaims{"role": models.RoleCustomer, "customer_id": customerID.String()}, models.ScopeCustomersRead, "/customer/" + customerID.String() + "/address", http.StatusOK},
+		{"customer can't read other addresses", jwt.MapClaims{"role": models.RoleCustomer, "customer_id": customerID.String()}, models.ScopeCustomersRead, "/customer/" + uuid.NewString() + "/address", http.StatusForbidden},
+		{"support reads any addresses", jwt.MapClaims{"role": models.RoleSupport}, models.ScopeCustomersRead, "/customer/" + uuid.NewString() + "/address", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := mux.NewRouter()
+			router.Use(a.Middleware)
+			router.Handle("/product", Require(tt.permission, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+			router.Handle("/webhooks", Require(tt.permission, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+			router.Handle("/customer/{customer_id}/address", Require(tt.permission, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+signedToken(t, jwt.SigningMethodHS256, []byte(hmacSecret), "", claims(tt.claims)))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.want, rr.Code)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+
+	"order/models"
+
+	"github.com/google/uuid"
+)
+
+// Methods a principal can authenticate with
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Subject identifies the caller: the API key ID or the token subject
+	Subject string
+	Method  string
+	Role    string
+	// Scopes narrow the permissions of the role; when empty the principal
+	// has all of them.
+	Scopes []string
+	// CustomerID is set when the caller acts on behalf of a customer
+	CustomerID *uuid.UUID
+}
+
+// HasScope reports whether the principal's credentials list the scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the principal's role grants the permission and its
+// credentials don't exclude it.
+func (p *Principal) Can(permission string) bool {
+	return models.RoleGrants(p.Role, permission) && (len(p.Scopes) == 0 || p.HasScope(permission))
+}
+
+// CustomerScope returns the only customer whose data the principal may see,
+// or nil if it isn't limited to one.
+func (p *Principal) CustomerScope() *uuid.UUID {
+	if p == nil || p.Role != models.RoleCustomer {
+		return nil
+	}
+	if p.CustomerID == nil {
+		// Authentication rejects these, but never fail open
+		return &uuid.Nil
+	}
+	return p.CustomerID
+}
+
+// MayAccessCustomer reports whether the principal may see the customer's
+// data. Requests that weren't authenticated, as in handler tests, aren't
+// limited.
+func (p *Principal) MayAccessCustomer(customerID uuid.UUID) bool {
+	scope := p.CustomerScope()
+	return scope == nil || *scope == customerID
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of the request, or nil if it wasn't
+// authenticated.
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
@@ -0,0 +1,168 @@
+// Package cache keeps products and orders read by ID in Redis, in front of
+// their repositories, so that every instance of the service shares one
+// cache. Entries are dropped when their record changes.
+//
+// A miss is loaded once per instance, and while one instance loads a key
+// the others wait briefly for it rather than all querying Postgres at once.
+// Each key has a version, bumped when it is invalidated, and a load only
+// fills the cache if the version hasn't changed since it began, so a read
+// racing an update can't cache what the update replaced.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"order/metrics"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// lockTTL bounds how long an instance may hold the right to load a key
+	lockTTL = 5 * time.Second
+	// lockWait is how long other instances wait for the key to be loaded
+	// before querying Postgres themselves, checking every pollInterval
+	lockWait     = 250 * time.Millisecond
+	pollInterval = 25 * time.Millisecond
+	// versionTTL is how long an invalidation is remembered, which must
+	// outlast any load that started before it
+	versionTTL = time.Minute
+)
+
+// fill caches KEYS[1] as ARGV[1] for ARGV[2] milliseconds, unless its
+// version, KEYS[2], is no longer ARGV[3]
+var fill = redis.NewScript(`
+local version = redis.call('GET', KEYS[2]) or ''
+if version ~= ARGV[3] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// store caches one kind of record as JSON
+type store[V any] struct {
+	rdb   *redis.Client
+	name  string
+	ttl   time.Duration
+	group singleflight.Group
+}
+
+func newStore[V any](rdb *redis.Client, name string, ttl time.Duration) *store[V] {
+	return &store[V]{rdb: rdb, name: name, ttl: ttl}
+}
+
+func (s *store[V]) key(id uuid.UUID, part string) string {
+	return "cache:" + s.name + ":" + id.String() + part
+}
+
+// Get returns the record from the cache or, on a miss, from load, caching
+// it. Errors of load, such as the record not existing, are returned as they
+// are and not cached. If Redis fails the record is loaded directly.
+func (s *store[V]) Get(ctx context.Context, id uuid.UUID, load func(ctx context.Context) (V, error)) (V, error) {
+	value, found, err := s.cached(ctx, id)
+	if err != nil {
+		s.failed(ctx, "read", id, err)
+		return load(ctx)
+	}
+	if found {
+		metrics.CacheRequests.WithLabelValues(s.name, "hit").Inc()
+		return value, nil
+	}
+	metrics.CacheRequests.WithLabelValues(s.name, "miss").Inc()
+
+	// Concurrent misses of this instance share one load, which isn't cut
+	// short by the request that happened to start it going away
+	ch := s.group.DoChan(id.String(), func() (interface{}, error) {
+		return s.load(context.WithoutCancel(ctx), id, load)
+	})
+	var zero V
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return zero, res.Err
+		}
+		return res.Val.(V), nil
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+func (s *store[V]) cached(ctx context.Context, id uuid.UUID) (V, bool, error) {
+	var value V
+	data, err := s.rdb.Get(ctx, s.key(id, "")).Bytes()
+	if err == redis.Nil {
+		return value, false, nil
+	}
+	if err != nil {
+		return value, false, err
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+// load loads the record and caches it if this instance gets to load the
+// key. Otherwise it waits for the instance that does, for up to lockWait.
+func (s *store[V]) load(ctx context.Context, id uuid.UUID, load func(ctx context.Context) (V, error)) (V, error) {
+	version, err := s.rdb.Get(ctx, s.key(id, ":version")).Result()
+	if err != nil && err != redis.Nil {
+		s.failed(ctx, "read", id, err)
+		return load(ctx)
+	}
+
+	locked, err := s.rdb.SetNX(ctx, s.key(id, ":lock"), 1, lockTTL).Result()
+	if err != nil {
+		s.failed(ctx, "lock", id, err)
+		return load(ctx)
+	}
+	if !locked {
+		for waited := time.Duration(0); waited < lockWait; waited += pollInterval {
+			time.Sleep(pollInterval)
+			if value, found, err := s.cached(ctx, id); err == nil && found {
+				return value, nil
+			}
+		}
+		return load(ctx)
+	}
+	defer s.rdb.Del(ctx, s.key(id, ":lock"))
+
+	value, err := load(ctx)
+	if err != nil {
+		return value, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value, nil
+	}
+	// Spread expiries so entries cached together aren't all reloaded at once
+	ttl := s.ttl + time.Duration(rand.Int63n(int64(s.ttl)/10+1))
+	if err := fill.Run(ctx, s.rdb, []string{s.key(id, ""), s.key(id, ":version")}, data, ttl.Milliseconds(), version).Err(); err != nil {
+		s.failed(ctx, "fill", id, err)
+	}
+	return value, nil
+}
+
+// Invalidate drops the record from the cache, and stops loads that began
+// before from caching it
+func (s *store[V]) Invalidate(ctx context.Context, id uuid.UUID) {
+	pipe := s.rdb.TxPipeline()
+	pipe.Incr(ctx, s.key(id, ":version"))
+	pipe.Expire(ctx, s.key(id, ":version"), versionTTL)
+	pipe.Del(ctx, s.key(id, ""))
+	if _, err := pipe.Exec(ctx); err != nil {
+		s.failed(ctx, "invalidate", id, err)
+	}
+}
+
+func (s *store[V]) failed(ctx context.Context, operation string, id uuid.UUID, err error) {
+	metrics.CacheRequests.WithLabelValues(s.name, "error").Inc()
+	slog.WarnContext(ctx, "Cache unavailable", "cache", s.name, "operation", operation, "id", id.String(), "error", err)
+}
@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"order/metrics"
+	"order/models"
+	"order/repository"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when starting miniredis", err)
+	}
+	t.Cleanup(mr.Close)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
+// countingProducts counts the reads of the repository it wraps, which can
+// be held up by setting gate
+type countingProducts struct {
+	repository.ProductRepository
+	reads atomic.Int32
+	gate  chan struct{}
+}
+
+func (c *countingProducts) Get(ctx context.Context, id uuid.UUID) (models.ProductRead, error) {
+	c.reads.Add(1)
+	if c.gate != nil {
+		<-c.gate
+	}
+	return c.ProductRepository.Get(ctx, id)
+}
+
+func requests(name, result string) float64 {
+	return testutil.ToFloat64(metrics.CacheRequests.WithLabelValues(name, result))
+}
+
+func TestProducts(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	productID := uuid.New()
+	store.AddProduct(models.ProductRead{ID: productID, Name: "Widget", Price: 9.99})
+
+	t.Run("misses are loaded once and then hit", func(t *testing.T) {
+		_, rdb := newRedis(t)
+		repo := &countingProducts{ProductRepository: store.Repositories().Products}
+		products := NewProducts(repo, rdb, time.Minute)
+		hits, misses := requests("products", "hit"), requests("products", "miss")
+
+		for i := 0; i < 3; i++ {
+			product, err := products.Get(ctx, productID)
+			assert.NoError(t, err)
+			assert.Equal(t, "Widget", product.Name)
+		}
+		assert.Equal(t, int32(1), repo.reads.Load())
+		assert.Equal(t, hits+2, requests("products", "hit"))
+		assert.Equal(t, misses+1, requests("products", "miss"))
+	})
+
+	t.Run("entries expire", func(t *testing.T) {
+		mr, rdb := newRedis(t)
+		repo := &countingProducts{ProductRepository: store.Repositories().Products}
+		products := NewProducts(repo, rdb, time.Minute)
+
+		products.Get(ctx, productID)
+		mr.FastForward(2 * time.Minute)
+		products.Get(ctx, productID)
+		assert.Equal(t, int32(2), repo.reads.Load())
+	})
+
+	t.Run("missing products aren't cached", func(t *testing.T) {
+		mr, rdb := newRedis(t)
+		products := NewProducts(store.Repositories().Products, rdb, time.Minute)
+
+		_, err := products.Get(ctx, uuid.New())
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		assert.Empty(t, mr.Keys())
+	})
+
+	t.Run("updates drop the product", func(t *testing.T) {
+		_, rdb := newRedis(t)
+		products := NewProducts(store.Repositories().Products, rdb, time.Minute)
+
+		products.Get(ctx, productID)
+		_, err := products.Update(ctx, productID, models.ProductWrite{Name: "Gadget", Price: 19.99})
+		assert.NoError(t, err)
+		product, err := products.Get(ctx, productID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Gadget", product.Name)
+		assert.Equal(t, 19.99, product.Price)
+	})
+
+	t.Run("concurrent misses load once", func(t *testing.T) {
+		_, rdb := newRedis(t)
+		repo := &countingProducts{ProductRepository: store.Repositories().Products, gate: make(chan struct{})}
+		// Two instances sharing Redis
+		instances := []*Products{NewProducts(repo, rdb, time.Minute), NewProducts(repo, rdb, time.Minute)}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(products *Products) {
+				defer wg.Done()
+				_, err := products.Get(ctx, productID)
+				assert.NoError(t, err)
+			}(instances[i%2])
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(repo.gate)
+		wg.Wait()
+		assert.Equal(t, int32(1), repo.reads.Load())
+	})
+
+	t.Run("loads racing an update aren't cached", func(t *testing.T) {
+		mr, rdb := newRedis(t)
+		repo := &countingProducts{ProductRepository: store.Repositories().Products, gate: make(chan struct{})}
+		products := NewProducts(repo, rdb, time.Minute)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			products.Get(ctx, productID)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		products.store.Invalidate(ctx, productID)
+		close(repo.gate)
+		<-done
+		assert.False(t, mr.Exists("cache:products:"+productID.String()))
+	})
+
+	t.Run("products are read from the repository while Redis is down", func(t *testing.T) {
+		mr, rdb := newRedis(t)
+		products := NewProducts(store.Repositories().Products, rdb, time.Minute)
+		errors := requests("products", "error")
+		mr.Close()
+
+		product, err := products.Get(ctx, productID)
+		assert.NoError(t, err)
+		assert.Equal(t, productID, product.ID)
+		assert.Equal(t, errors+1, requests("products", "error"))
+	})
+}
+
+func TestOrders(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	orderID := uuid.New()
+	store.AddOrder(models.OrderRead{ID: orderID, CustomerID: uuid.New(), ProductID: uuid.New(), Status: models.Pending, Amount: 10})
+	_, rdb := newRedis(t)
+	orders := NewOrders(store.Repositories().Orders, rdb, time.Minute)
+
+	orders.Get(ctx, orderID)
+	store.AddOrder(models.OrderRead{ID: orderID, Status: models.Completed})
+
+	t.Run("cached orders are returned until invalidated", func(t *testing.T) {
+		order, err := orders.Get(ctx, orderID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Pending, order.Status)
+
+		orders.Invalidate(ctx, orderID)
+		order, err = orders.Get(ctx, orderID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.Completed, order.Status)
+	})
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"order/models"
+	"order/repository"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Orders caches orders read by ID. Everything else is passed to the
+// repository it wraps. Orders only change status through
+// events.UpdateOrderStatus, whose changes are all published on the broker,
+// so Invalidate is registered as a listener of it.
+type Orders struct {
+	repository.OrderRepository
+	store *store[models.OrderRead]
+}
+
+// NewOrders caches the orders of repo in rdb for ttl
+func NewOrders(repo repository.OrderRepository, rdb *redis.Client, ttl time.Duration) *Orders {
+	return &Orders{OrderRepository: repo, store: newStore[models.OrderRead](rdb, "orders", ttl)}
+}
+
+// Get returns the order from the cache, or from the repository on a miss
+func (o *Orders) Get(ctx context.Context, id uuid.UUID) (models.OrderRead, error) {
+	return o.store.Get(ctx, id, func(ctx context.Context) (models.OrderRead, error) {
+		return o.OrderRepository.Get(ctx, id)
+	})
+}
+
+// Invalidate drops the order from the cache
+func (o *Orders) Invalidate(ctx context.Context, id uuid.UUID) {
+	o.store.Invalidate(ctx, id)
+}
+
+// Products caches products read by ID, and drops them when they are
+// updated. Everything else is passed to the repository it wraps.
+type Products struct {
+	repository.ProductRepository
+	store *store[models.ProductRead]
+}
+
+// NewProducts caches the products of repo in rdb for ttl
+func NewProducts(repo repository.ProductRepository, rdb *redis.Client, ttl time.Duration) *Products {
+	return &Products{ProductRepository: repo, store: newStore[models.ProductRead](rdb, "products", ttl)}
+}
+
+// Get returns the product from the cache, or from the repository on a miss
+func (p *Products) Get(ctx context.Context, id uuid.UUID) (models.ProductRead, error) {
+	return p.store.Get(ctx, id, func(ctx context.Context) (models.ProductRead, error) {
+		return p.ProductRepository.Get(ctx, id)
+	})
+}
+
+// Update updates the product and drops it from the cache
+func (p *Products) Update(ctx context.Context, id uuid.UUID, product models.ProductWrite) (models.ProductRead, error) {
+	updated, err := p.ProductRepository.Update(ctx, id, product)
+	if err == nil {
+		p.store.Invalidate(context.WithoutCancel(ctx), id)
+	}
+	return updated, err
+}
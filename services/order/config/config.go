@@ -0,0 +1,580 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the order service configuration. Values are taken from, in
+// increasing order of precedence: the defaults, an optional YAML file,
+// environment variables and command line flags.
+type Config struct {
+	Port int `yaml:"port"`
+	// GRPCPort is the port of the gRPC API, 0 to serve HTTP only
+	GRPCPort int `yaml:"grpc_port"`
+	// ShutdownTimeout bounds how long shutdown waits for in-flight requests
+	// and background work to finish.
+	ShutdownTimeout time.Duration   `yaml:"shutdown_timeout"`
+	HTTP            HTTPConfig      `yaml:"http"`
+	DB              DBConfig        `yaml:"db"`
+	Redis           RedisConfig     `yaml:"redis"`
+	Health          HealthConfig    `yaml:"health"`
+	Tracing         TracingConfig   `yaml:"tracing"`
+	Log             LogConfig       `yaml:"log"`
+	Auth            AuthConfig      `yaml:"auth"`
+	RateLimit       RateLimitConfig `yaml:"rate_limit"`
+	Cache           CacheConfig     `yaml:"cache"`
+
+	// Args are the command line arguments left after the flags, naming a
+	// command to run instead of serving.
+	Args []string `yaml:"-"`
+}
+
+type HTTPConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	// WriteTimeout is disabled by default since it would cut off the
+	// long-lived order event streams.
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// HandlerTimeout is the deadline given to each API request's context,
+	// past which it fails with 504. Event streams are exempt.
+	HandlerTimeout time.Duration `yaml:"handler_timeout"`
+}
+
+type DBConfig struct {
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	User            string        `yaml:"user"`
+	Password        string        `yaml:"password"`
+	Name            string        `yaml:"name"`
+	SSLMode         string        `yaml:"ssl_mode"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	// ConnectRetryTimeout is how long startup keeps retrying to connect
+	// while Postgres isn't up yet, 0 to try once
+	ConnectRetryTimeout time.Duration `yaml:"connect_retry_timeout"`
+	// QueryTimeout is the Postgres statement_timeout of every connection
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+	// MigrateOnStart applies pending migrations before serving
+	MigrateOnStart bool `yaml:"migrate_on_start"`
+	// ReplicaDSN lists the postgres:// URLs of read replicas, separated by
+	// commas. Reads of orders, products and customers go to a healthy
+	// replica when there is one.
+	ReplicaDSN string `yaml:"replica_dsn"`
+	// ReplicaMaxLag is the replication lag past which a replica stops
+	// serving reads, and how long records written stay read from the
+	// primary
+	ReplicaMaxLag time.Duration `yaml:"replica_max_lag"`
+	// ReplicaCheckInterval is how often replicas' health and lag are checked
+	ReplicaCheckInterval time.Duration `yaml:"replica_check_interval"`
+}
+
+// ReplicaDSNs returns the URLs of the read replicas
+func (c DBConfig) ReplicaDSNs() []string {
+	var dsns []string
+	for _, dsn := range strings.Split(c.ReplicaDSN, ",") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			dsns = append(dsns, dsn)
+		}
+	}
+	return dsns
+}
+
+type RedisConfig struct {
+	Host         string        `yaml:"host"`
+	Port         int           `yaml:"port"`
+	Password     string        `yaml:"password"`
+	DB           int           `yaml:"db"`
+	TLS          bool          `yaml:"tls"`
+	DialTimeout  time.Duration `yaml:"dial_timeout"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// PoolSize is the most connections kept open, 0 for 10 per CPU
+	PoolSize     int           `yaml:"pool_size"`
+	MinIdleConns int           `yaml:"min_idle_conns"`
+	MaxConnAge   time.Duration `yaml:"max_conn_age"`
+	// ConnectRetryTimeout is how long startup keeps retrying to connect
+	// while Redis isn't up yet, 0 to try once
+	ConnectRetryTimeout time.Duration `yaml:"connect_retry_timeout"`
+}
+
+type HealthConfig struct {
+	// CheckTimeout bounds each dependency check made by /readyz
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+	// MaxOutboxLag is how old the oldest order event not yet queued for
+	// webhook delivery may get before the service reports itself not ready.
+	MaxOutboxLag time.Duration `yaml:"max_outbox_lag"`
+}
+
+type TracingConfig struct {
+	// Exporter is where spans are sent: "none", "stdout" or "otlp"
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the URL of the OTLP/HTTP collector; plain http disables TLS
+	Endpoint string `yaml:"endpoint"`
+}
+
+type LogConfig struct {
+	// Level is the minimum level logged: "debug", "info", "warn" or "error"
+	Level string `yaml:"level"`
+	// Format is "json", or "text" for reading logs locally
+	Format string `yaml:"format"`
+}
+
+type AuthConfig struct {
+	JWT JWTConfig `yaml:"jwt"`
+}
+
+// JWTConfig configures bearer token verification. At most one of JWKSURL,
+// PublicKeyFile and HMACSecret may be set; with none, only API keys are
+// accepted.
+type JWTConfig struct {
+	JWKSURL string `yaml:"jwks_url"`
+	// JWKSRefreshInterval is how often the key set is refetched; unknown
+	// key IDs also trigger a refetch.
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval"`
+	// PublicKeyFile is a PEM encoded RSA, ECDSA or Ed25519 public key
+	PublicKeyFile string `yaml:"public_key_file"`
+	HMACSecret    string `yaml:"hmac_secret"`
+	// Issuer and Audience, if set, must match the token's claims
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+}
+
+// Enabled reports whether bearer tokens are accepted
+func (c JWTConfig) Enabled() bool {
+	return c.JWKSURL != "" || c.PublicKeyFile != "" || c.HMACSecret != ""
+}
+
+// RateLimitConfig configures the request limits of each client and the
+// daily order quota of each customer.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Default applies to routes without a limit of their own
+	Default RateLimit `yaml:"default"`
+	// Routes maps a method and route template, e.g. "POST /order", to its
+	// limit. Entries from the config file are added to the defaults.
+	Routes map[string]RateLimit `yaml:"routes"`
+	// FailedAuth limits the failed authentications of each IP address. Once
+	// it is used up, requests from the address are refused before their
+	// credentials are checked.
+	FailedAuth RateLimit `yaml:"failed_auth"`
+	// DailyOrderQuota caps the orders a customer can place per UTC day, 0
+	// for no cap
+	DailyOrderQuota int `yaml:"daily_order_quota"`
+}
+
+// RateLimit allows Requests per Per on average, and up to Burst at once
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	Burst    int           `yaml:"burst"`
+}
+
+// CacheConfig configures the Redis cache of products and orders read by ID.
+// Entries are dropped when their record changes; the TTLs bound how long
+// one can outlive a change made behind the service's back.
+type CacheConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	ProductTTL time.Duration `yaml:"product_ttl"`
+	OrderTTL   time.Duration `yaml:"order_ttl"`
+}
+
+// Addr returns the host:port of the Redis server
+func (c RedisConfig) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+func Default() *Config {
+	return &Config{
+		Port:            8080,
+		GRPCPort:        50051,
+		ShutdownTimeout: 30 * time.Second,
+		HTTP: HTTPConfig{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			HandlerTimeout:    15 * time.Second,
+		},
+		DB: DBConfig{
+			Host:                 "localhost",
+			Port:                 5432,
+			SSLMode:              "disable",
+			ConnectTimeout:       5 * time.Second,
+			MaxOpenConns:         25,
+			MaxIdleConns:         25,
+			ConnMaxLifetime:      30 * time.Minute,
+			ConnMaxIdleTime:      5 * time.Minute,
+			ConnectRetryTimeout:  time.Minute,
+			QueryTimeout:         5 * time.Second,
+			MigrateOnStart:       true,
+			ReplicaMaxLag:        5 * time.Second,
+			ReplicaCheckInterval: 2 * time.Second,
+		},
+		Redis: RedisConfig{
+			Host:                "localhost",
+			Port:                6379,
+			DialTimeout:         5 * time.Second,
+			ReadTimeout:         3 * time.Second,
+			WriteTimeout:        3 * time.Second,
+			PoolSize:            20,
+			MinIdleConns:        2,
+			MaxConnAge:          30 * time.Minute,
+			ConnectRetryTimeout: time.Minute,
+		},
+		Health: HealthConfig{
+			CheckTimeout: 2 * time.Second,
+			MaxOutboxLag: time.Minute,
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+			Endpoint: "http://localhost:4318",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Auth: AuthConfig{
+			JWT: JWTConfig{
+				JWKSRefreshInterval: time.Hour,
+			},
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Default: RateLimit{Requests: 600, Per: time.Minute, Burst: 100},
+			Routes: map[string]RateLimit{
+				"POST /order":       {Requests: 60, Per: time.Minute, Burst: 10},
+				"POST /orders/bulk": {Requests: 10, Per: time.Minute, Burst: 2},
+			},
+			FailedAuth:      RateLimit{Requests: 10, Per: time.Minute, Burst: 20},
+			DailyOrderQuota: 1000,
+		},
+		Cache: CacheConfig{
+			Enabled:    true,
+			ProductTTL: 10 * time.Minute,
+			OrderTTL:   time.Minute,
+		},
+	}
+}
+
+// setting binds a configuration field to its flag and environment variable
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value interface{}
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"port", "ORDER_SERVICE_PORT", "HTTP port", &c.Port},
+		{"grpc-port", "GRPC_PORT", "gRPC port, 0 to disable the gRPC API", &c.GRPCPort},
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long to wait for in-flight work on shutdown", &c.ShutdownTimeout},
+		{"http-read-header-timeout", "HTTP_READ_HEADER_TIMEOUT", "time allowed to read request headers", &c.HTTP.ReadHeaderTimeout},
+		{"http-read-timeout", "HTTP_READ_TIMEOUT", "time allowed to read a request", &c.HTTP.ReadTimeout},
+		{"http-write-timeout", "HTTP_WRITE_TIMEOUT", "time allowed to write a response, 0 for none", &c.HTTP.WriteTimeout},
+		{"http-idle-timeout", "HTTP_IDLE_TIMEOUT", "how long idle keep-alive connections are kept", &c.HTTP.IdleTimeout},
+		{"http-handler-timeout", "HTTP_HANDLER_TIMEOUT", "time allowed to handle an API request, 0 for none", &c.HTTP.HandlerTimeout},
+		{"db-host", "DB_HOST", "Postgres host", &c.DB.Host},
+		{"db-port", "DB_PORT", "Postgres port", &c.DB.Port},
+		{"db-user", "DB_USER", "Postgres user", &c.DB.User},
+		{"db-password", "DB_PASS", "Postgres password", &c.DB.Password},
+		{"db-name", "DB_NAME", "Postgres database", &c.DB.Name},
+		{"db-ssl-mode", "DB_SSLMODE", "Postgres sslmode", &c.DB.SSLMode},
+		{"db-connect-timeout", "DB_CONNECT_TIMEOUT", "Postgres connect timeout", &c.DB.ConnectTimeout},
+		{"db-max-open-conns", "DB_MAX_OPEN_CONNS", "maximum open Postgres connections, 0 for unlimited", &c.DB.MaxOpenConns},
+		{"db-max-idle-conns", "DB_MAX_IDLE_CONNS", "maximum idle Postgres connections", &c.DB.MaxIdleConns},
+		{"db-conn-max-lifetime", "DB_CONN_MAX_LIFETIME", "maximum lifetime of a Postgres connection, 0 for unlimited", &c.DB.ConnMaxLifetime},
+		{"db-conn-max-idle-time", "DB_CONN_MAX_IDLE_TIME", "how long a Postgres connection may sit idle, 0 for unlimited", &c.DB.ConnMaxIdleTime},
+		{"db-connect-retry-timeout", "DB_CONNECT_RETRY_TIMEOUT", "how long to retry connecting to Postgres on startup, 0 to try once", &c.DB.ConnectRetryTimeout},
+		{"db-query-timeout", "DB_QUERY_TIMEOUT", "time allowed for each Postgres statement, 0 for none", &c.DB.QueryTimeout},
+		{"db-migrate-on-start", "DB_MIGRATE_ON_START", "apply pending migrations before serving", &c.DB.MigrateOnStart},
+		{"db-replica-dsn", "DB_REPLICA_DSN", "postgres:// URLs of read replicas, separated by commas", &c.DB.ReplicaDSN},
+		{"db-replica-max-lag", "DB_REPLICA_MAX_LAG", "replication lag past which a replica stops serving reads", &c.DB.ReplicaMaxLag},
+		{"db-replica-check-interval", "DB_REPLICA_CHECK_INTERVAL", "how often read replicas are checked", &c.DB.ReplicaCheckInterval},
+		{"redis-host", "REDIS_HOST", "Redis host", &c.Redis.Host},
+		{"redis-port", "REDIS_PORT", "Redis port", &c.Redis.Port},
+		{"redis-password", "REDIS_PASSWORD", "Redis password", &c.Redis.Password},
+		{"redis-db", "REDIS_DB", "Redis database number", &c.Redis.DB},
+		{"redis-tls", "REDIS_TLS", "connect to Redis over TLS", &c.Redis.TLS},
+		{"redis-dial-timeout", "REDIS_DIAL_TIMEOUT", "Redis dial timeout", &c.Redis.DialTimeout},
+		{"redis-read-timeout", "REDIS_READ_TIMEOUT", "Redis read timeout", &c.Redis.ReadTimeout},
+		{"redis-write-timeout", "REDIS_WRITE_TIMEOUT", "Redis write timeout", &c.Redis.WriteTimeout},
+		{"redis-pool-size", "REDIS_POOL_SIZE", "maximum open Redis connections, 0 for 10 per CPU", &c.Redis.PoolSize},
+		{"redis-min-idle-conns", "REDIS_MIN_IDLE_CONNS", "Redis connections kept open while idle", &c.Redis.MinIdleConns},
+		{"redis-max-conn-age", "REDIS_MAX_CONN_AGE", "maximum lifetime of a Redis connection, 0 for unlimited", &c.Redis.MaxConnAge},
+		{"redis-connect-retry-timeout", "REDIS_CONNECT_RETRY_TIMEOUT", "how long to retry connecting to Redis on startup, 0 to try once", &c.Redis.ConnectRetryTimeout},
+		{"health-check-timeout", "HEALTH_CHECK_TIMEOUT", "timeout for each readiness check", &c.Health.CheckTimeout},
+		{"health-max-outbox-lag", "HEALTH_MAX_OUTBOX_LAG", "webhook outbox lag above which the service is not ready", &c.Health.MaxOutboxLag},
+		{"tracing-exporter", "TRACING_EXPORTER", "trace exporter: none, stdout or otlp", &c.Tracing.Exporter},
+		{"tracing-endpoint", "TRACING_ENDPOINT", "OTLP/HTTP collector URL", &c.Tracing.Endpoint},
+		{"log-level", "LOG_LEVEL", "minimum log level: debug, info, warn or error", &c.Log.Level},
+		{"log-format", "LOG_FORMAT", "log format: json or text", &c.Log.Format},
+		{"auth-jwt-jwks-url", "AUTH_JWT_JWKS_URL", "URL of the JWKS used to verify bearer tokens", &c.Auth.JWT.JWKSURL},
+		{"auth-jwt-jwks-refresh-interval", "AUTH_JWT_JWKS_REFRESH_INTERVAL", "how often the JWKS is refetched", &c.Auth.JWT.JWKSRefreshInterval},
+		{"auth-jwt-public-key-file", "AUTH_JWT_PUBLIC_KEY_FILE", "PEM public key used to verify bearer tokens", &c.Auth.JWT.PublicKeyFile},
+		{"auth-jwt-hmac-secret", "AUTH_JWT_HMAC_SECRET", "shared secret used to verify HMAC signed bearer tokens", &c.Auth.JWT.HMACSecret},
+		{"auth-jwt-issuer", "AUTH_JWT_ISSUER", "required bearer token issuer", &c.Auth.JWT.Issuer},
+		{"auth-jwt-audience", "AUTH_JWT_AUDIENCE", "required bearer token audience", &c.Auth.JWT.Audience},
+		{"rate-limit-enabled", "RATE_LIMIT_ENABLED", "limit the request rate of each client", &c.RateLimit.Enabled},
+		{"rate-limit-requests", "RATE_LIMIT_REQUESTS", "requests allowed per period on routes without their own limit", &c.RateLimit.Default.Requests},
+		{"rate-limit-per", "RATE_LIMIT_PER", "period of the default rate limit", &c.RateLimit.Default.Per},
+		{"rate-limit-burst", "RATE_LIMIT_BURST", "requests allowed at once on routes without their own limit", &c.RateLimit.Default.Burst},
+		{"rate-limit-failed-auth-requests", "RATE_LIMIT_FAILED_AUTH_REQUESTS", "failed authentications allowed per period from each IP address", &c.RateLimit.FailedAuth.Requests},
+		{"rate-limit-failed-auth-per", "RATE_LIMIT_FAILED_AUTH_PER", "period of the failed authentication limit", &c.RateLimit.FailedAuth.Per},
+		{"rate-limit-failed-auth-burst", "RATE_LIMIT_FAILED_AUTH_BURST", "failed authentications allowed at once from each IP address", &c.RateLimit.FailedAuth.Burst},
+		{"rate-limit-daily-order-quota", "RATE_LIMIT_DAILY_ORDER_QUOTA", "orders a customer can place per UTC day, 0 for no cap", &c.RateLimit.DailyOrderQuota},
+		{"cache-enabled", "CACHE_ENABLED", "cache products and orders read by ID in Redis", &c.Cache.Enabled},
+		{"cache-product-ttl", "CACHE_PRODUCT_TTL", "how long a product stays cached", &c.Cache.ProductTTL},
+		{"cache-order-ttl", "CACHE_ORDER_TTL", "how long an order stays cached", &c.Cache.OrderTTL},
+	}
+}
+
+// ValidationError lists every problem found in the configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load builds the configuration from the command line arguments (without the
+// program name) and the environment. The YAML file is read from the -config
+// flag or the CONFIG_FILE environment variable, if either is set.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("order", flag.ContinueOnError)
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "path to a YAML config file (env CONFIG_FILE)")
+	for _, s := range settings {
+		fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.Args = fs.Args()
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var problems []string
+	for _, s := range settings {
+		if raw := getenv(s.env); raw != "" {
+			if err := set(s.value, raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+			}
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				if err := set(s.value, f.Value.String()); err != nil {
+					problems = append(problems, fmt.Sprintf("-%s: %v", s.flag, err))
+				}
+			}
+		}
+	})
+
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %v", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+func set(value interface{}, raw string) error {
+	switch v := value.(type) {
+	case *string:
+		*v = raw
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		*v = b
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", raw)
+		}
+		*v = d
+	default:
+		return fmt.Errorf("unsupported setting type %T", value)
+	}
+	return nil
+}
+
+var (
+	sslModes       = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+	traceExporters = []string{"none", "stdout", "otlp"}
+	logLevels      = []string{"debug", "info", "warn", "error"}
+	logFormats     = []string{"json", "text"}
+)
+
+func (c *Config) validate() []string {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(validPort(c.Port), "port must be between 1 and 65535, got %d", c.Port)
+	check(c.GRPCPort == 0 || validPort(c.GRPCPort), "grpc_port must be between 1 and 65535, or 0 to disable gRPC, got %d", c.GRPCPort)
+	check(c.GRPCPort != c.Port, "grpc_port must differ from port")
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	check(c.HTTP.ReadHeaderTimeout >= 0, "http.read_header_timeout must not be negative")
+	check(c.HTTP.ReadTimeout >= 0, "http.read_timeout must not be negative")
+	check(c.HTTP.WriteTimeout >= 0, "http.write_timeout must not be negative")
+	check(c.HTTP.IdleTimeout >= 0, "http.idle_timeout must not be negative")
+	check(c.HTTP.HandlerTimeout >= 0, "http.handler_timeout must not be negative")
+
+	check(c.DB.Host != "", "db.host is required")
+	check(validPort(c.DB.Port), "db.port must be between 1 and 65535, got %d", c.DB.Port)
+	check(c.DB.User != "", "db.user is required")
+	check(c.DB.Name != "", "db.name is required")
+	check(contains(sslModes, c.DB.SSLMode), "db.ssl_mode must be one of %s, got %q", strings.Join(sslModes, ", "), c.DB.SSLMode)
+	check(c.DB.ConnectTimeout >= 0, "db.connect_timeout must not be negative")
+	check(c.DB.MaxOpenConns >= 0, "db.max_open_conns must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "db.max_idle_conns must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "db.max_idle_conns (%d) must not exceed db.max_open_conns (%d)", c.DB.MaxIdleConns, c.DB.MaxOpenConns)
+	check(c.DB.ConnMaxLifetime >= 0, "db.conn_max_lifetime must not be negative")
+	check(c.DB.ConnMaxIdleTime >= 0, "db.conn_max_idle_time must not be negative")
+	check(c.DB.ConnectRetryTimeout >= 0, "db.connect_retry_timeout must not be negative")
+	check(c.DB.QueryTimeout >= 0, "db.query_timeout must not be negative")
+	if dsns := c.DB.ReplicaDSNs(); len(dsns) > 0 {
+		for i, dsn := range dsns {
+			// The URLs hold passwords, so they aren't repeated
+			check(validDSN(dsn), "db.replica_dsn entry %d must be a postgres:// URL", i+1)
+		}
+		check(c.DB.ReplicaMaxLag > 0, "db.replica_max_lag must be positive")
+		check(c.DB.ReplicaCheckInterval > 0, "db.replica_check_interval must be positive")
+	}
+
+	check(c.Redis.Host != "", "redis.host is required")
+	check(validPort(c.Redis.Port), "redis.port must be between 1 and 65535, got %d", c.Redis.Port)
+	check(c.Redis.DB >= 0, "redis.db must not be negative")
+	check(c.Redis.DialTimeout >= 0, "redis.dial_timeout must not be negative")
+	check(c.Redis.ReadTimeout >= 0, "redis.read_timeout must not be negative")
+	check(c.Redis.WriteTimeout >= 0, "redis.write_timeout must not be negative")
+	check(c.Redis.PoolSize >= 0, "redis.pool_size must not be negative")
+	check(c.Redis.MinIdleConns >= 0, "redis.min_idle_conns must not be negative")
+	check(c.Redis.PoolSize == 0 || c.Redis.MinIdleConns <= c.Redis.PoolSize, "redis.min_idle_conns (%d) must not exceed redis.pool_size (%d)", c.Redis.MinIdleConns, c.Redis.PoolSize)
+	check(c.Redis.MaxConnAge >= 0, "redis.max_conn_age must not be negative")
+	check(c.Redis.ConnectRetryTimeout >= 0, "redis.connect_retry_timeout must not be negative")
+
+	check(c.Health.CheckTimeout > 0, "health.check_timeout must be positive")
+	check(c.Health.MaxOutboxLag > 0, "health.max_outbox_lag must be positive")
+
+	check(contains(traceExporters, c.Tracing.Exporter), "tracing.exporter must be one of %s, got %q", strings.Join(traceExporters, ", "), c.Tracing.Exporter)
+	if c.Tracing.Exporter == "otlp" {
+		check(validURL(c.Tracing.Endpoint), "tracing.endpoint must be an http or https URL, got %q", c.Tracing.Endpoint)
+	}
+
+	check(contains(logLevels, c.Log.Level), "log.level must be one of %s, got %q", strings.Join(logLevels, ", "), c.Log.Level)
+	check(contains(logFormats, c.Log.Format), "log.format must be one of %s, got %q", strings.Join(logFormats, ", "), c.Log.Format)
+
+	keySources := 0
+	for _, source := range []string{c.Auth.JWT.JWKSURL, c.Auth.JWT.PublicKeyFile, c.Auth.JWT.HMACSecret} {
+		if source != "" {
+			keySources++
+		}
+	}
+	check(keySources <= 1, "only one of auth.jwt.jwks_url, auth.jwt.public_key_file and auth.jwt.hmac_secret may be set")
+	if c.Auth.JWT.JWKSURL != "" {
+		check(validURL(c.Auth.JWT.JWKSURL), "auth.jwt.jwks_url must be an http or https URL, got %q", c.Auth.JWT.JWKSURL)
+		check(c.Auth.JWT.JWKSRefreshInterval > 0, "auth.jwt.jwks_refresh_interval must be positive")
+	}
+	if c.Auth.JWT.HMACSecret != "" {
+		check(len(c.Auth.JWT.HMACSecret) >= 32, "auth.jwt.hmac_secret must be at least 32 characters")
+	}
+
+	if c.RateLimit.Enabled {
+		checkLimit := func(name string, limit RateLimit) {
+			check(limit.Requests > 0 && limit.Per > 0, "%s must allow a positive number of requests per positive period", name)
+			check(limit.Burst > 0, "%s burst must be positive", name)
+		}
+		checkLimit("rate_limit.default", c.RateLimit.Default)
+		checkLimit("rate_limit.failed_auth", c.RateLimit.FailedAuth)
+		routes := make([]string, 0, len(c.RateLimit.Routes))
+		for route := range c.RateLimit.Routes {
+			routes = append(routes, route)
+		}
+		sort.Strings(routes)
+		for _, route := range routes {
+			method, path, ok := strings.Cut(route, " ")
+			check(ok && method == strings.ToUpper(method) && strings.HasPrefix(path, "/"), "rate_limit.routes key %q must be a method and route template, e.g. \"POST /order\"", route)
+			checkLimit(fmt.Sprintf("rate_limit.routes[%q]", route), c.RateLimit.Routes[route])
+		}
+	}
+	check(c.RateLimit.DailyOrderQuota >= 0, "rate_limit.daily_order_quota must not be negative")
+
+	if c.Cache.Enabled {
+		check(c.Cache.ProductTTL > 0, "cache.product_ttl must be positive")
+		check(c.Cache.OrderTTL > 0, "cache.order_ttl must be positive")
+	}
+
+	return problems
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+func validURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func validDSN(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") && u.Host != ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"order/config"
+	"order/metrics"
+)
+
+// lagQuery returns how many seconds a replica is behind the primary: none
+// once it has replayed everything it received, or else the age of the last
+// transaction it replayed
+const lagQuery = `SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp()), 0) END`
+
+// RecentWrites remembers the records written for as long as replicas may
+// take to receive the writes
+type RecentWrites interface {
+	Add(ctx context.Context, keys ...string) error
+	// Any reports whether any of the keys was written recently
+	Any(ctx context.Context, keys ...string) (bool, error)
+}
+
+// Replica is a read replica, named for logs and metrics by its host
+type Replica struct {
+	Name string
+	DB   *sql.DB
+
+	healthy atomic.Bool
+}
+
+// OpenReplicas opens the connection pools of the read replicas of the
+// configuration. They aren't pinged: replicas that are down are left out
+// of reads until their first check passes.
+func OpenReplicas(cfg config.DBConfig) ([]*Replica, error) {
+	var replicas []*Replica
+	for _, dsn := range cfg.ReplicaDSNs() {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			closeReplicas(replicas)
+			return nil, fmt.Errorf("invalid replica URL: %v", err)
+		}
+		query := u.Query()
+		setTimeouts(query, cfg)
+		u.RawQuery = query.Encode()
+
+		db, err := open(u.String(), cfg)
+		if err != nil {
+			closeReplicas(replicas)
+			return nil, fmt.Errorf("unable to open replica %s: %v", u.Host, err)
+		}
+		replicas = append(replicas, &Replica{Name: u.Host, DB: db})
+	}
+	return replicas, nil
+}
+
+func closeReplicas(replicas []*Replica) {
+	for _, replica := range replicas {
+		replica.DB.Close()
+	}
+}
+
+// Cluster is the primary database and its read replicas. Writes go to the
+// primary; reads go to a healthy replica, unless a record they read was
+// written recently, since the replica may not have the write yet. Without
+// healthy replicas, reads fall back to the primary.
+type Cluster struct {
+	primary  *sql.DB
+	replicas []*Replica
+	writes   RecentWrites
+	maxLag   time.Duration
+	next     atomic.Uint64
+}
+
+// NewCluster routes reads to the replicas while their lag is within
+// maxLag. Writes are remembered in writes; without replicas they needn't
+// be, and writes may be nil.
+func NewCluster(primary *sql.DB, replicas []*Replica, writes RecentWrites, maxLag time.Duration) *Cluster {
+	for _, replica := range replicas {
+		metrics.DBReplicaHealthy.WithLabelValues(replica.Name).Set(0)
+	}
+	return &Cluster{primary: primary, replicas: replicas, writes: writes, maxLag: maxLag}
+}
+
+// Primary returns the primary database
+func (c *Cluster) Primary() *sql.DB {
+	return c.primary
+}
+
+// Reader returns the database to read the records named by keys from: a
+// healthy replica, in turn, or the primary if there is none or one of the
+// records was written recently
+func (c *Cluster) Reader(ctx context.Context, keys ...string) *sql.DB {
+	if len(c.replicas) == 0 {
+		return c.primary
+	}
+	if len(keys) > 0 {
+		written, err := c.writes.Any(ctx, keys...)
+		if err != nil {
+			slog.WarnContext(ctx, "Unable to check recent writes, reading from the primary", "error", err)
+		}
+		if written || err != nil {
+			metrics.DBReads.WithLabelValues("primary").Inc()
+			return c.primary
+		}
+	}
+
+	start := c.next.Add(1)
+	for i := range c.replicas {
+		replica := c.replicas[(start+uint64(i))%uint64(len(c.replicas))]
+		if replica.healthy.Load() {
+			metrics.DBReads.WithLabelValues("replica").Inc()
+			return replica.DB
+		}
+	}
+	metrics.DBReads.WithLabelValues("primary").Inc()
+	return c.primary
+}
+
+// Written records that the records named by keys were just written, so they
+// are read from the primary until the replicas have them
+func (c *Cluster) Written(ctx context.Context, keys ...string) {
+	if len(c.replicas) == 0 || len(keys) == 0 {
+		return
+	}
+	if err := c.writes.Add(ctx, keys...); err != nil {
+		slog.WarnContext(ctx, "Unable to record writes, replicas may serve them stale", "error", err)
+	}
+}
+
+// Failed takes db, if it is a replica, out of reads until it passes a check
+// again, after it couldn't serve a query
+func (c *Cluster) Failed(db *sql.DB, err error) {
+	for _, replica := range c.replicas {
+		if replica.DB == db {
+			c.setHealthy(replica, false, err)
+		}
+	}
+}
+
+// Monitor checks the replicas every interval until ctx is done
+func (c *Cluster) Monitor(ctx context.Context, interval time.Duration) {
+	if len(c.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.Check(ctx, interval)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check checks every replica, each within timeout. Replicas that can't be
+// reached or lag more than the maximum stop serving reads.
+func (c *Cluster) Check(ctx context.Context, timeout time.Duration) {
+	for _, replica := range c.replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		var lag float64
+		err := replica.DB.QueryRowContext(checkCtx, lagQuery).Scan(&lag)
+		cancel()
+		if err == nil {
+			metrics.DBReplicaLag.WithLabelValues(replica.Name).Set(lag)
+			if behind := time.Duration(lag * float64(time.Second)); behind > c.maxLag {
+				err = fmt.Errorf("replication lag of %s is more than %s", behind.Round(time.Millisecond), c.maxLag)
+			}
+		}
+		c.setHealthy(replica, err == nil, err)
+	}
+}
+
+func (c *Cluster) setHealthy(replica *Replica, healthy bool, err error) {
+	if replica.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		metrics.DBReplicaHealthy.WithLabelValues(replica.Name).Set(1)
+		slog.Info("Read replica is serving reads", "replica", replica.Name)
+	} else {
+		metrics.DBReplicaHealthy.WithLabelValues(replica.Name).Set(0)
+		slog.Warn("Read replica is unhealthy, reading from the primary", "replica", replica.Name, "error", err)
+	}
+}
+
+// CloseReplicas closes the replicas' connection pools
+func (c *Cluster) CloseReplicas() error {
+	var firstErr error
+	for _, replica := range c.replicas {
+		if err := replica.DB.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
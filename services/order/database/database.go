@@ -1,28 +1,80 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"os"
+	"net"
+	"net/url"
+	"order/config"
+	"order/retry"
+	"strconv"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
-func Connect() (*sql.DB, error) {
-	dbUser := os.Getenv("DB_USER")
-	dbPass := os.Getenv("DB_PASS")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
+// DSN builds the Postgres connection URL for the configuration
+func DSN(cfg config.DBConfig) string {
+	query := url.Values{}
+	query.Set("sslmode", cfg.SSLMode)
+	setTimeouts(query, cfg)
 
-	dsn := fmt.Sprintf("postgres://%s:%s@db:%s/%s", dbUser, dbPass, dbPort, dbName)
-	db, err := sql.Open("pgx", dsn)
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Path:     "/" + cfg.Name,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
+// setTimeouts adds the connect and statement timeouts of the configuration
+// to the parameters of a connection URL, unless they are set already
+func setTimeouts(query url.Values, cfg config.DBConfig) {
+	if cfg.ConnectTimeout > 0 && !query.Has("connect_timeout") {
+		query.Set("connect_timeout", strconv.Itoa(int(cfg.ConnectTimeout.Seconds())))
+	}
+	if cfg.QueryTimeout > 0 && !query.Has("statement_timeout") {
+		// Sent as a runtime parameter, so it bounds every statement on
+		// every connection, including those outside a request.
+		query.Set("statement_timeout", strconv.FormatInt(cfg.QueryTimeout.Milliseconds(), 10))
+	}
+}
+
+// Connect opens the connection pool and waits for Postgres to answer, for up
+// to cfg.ConnectRetryTimeout, so the service can start before the database
+// is up.
+func Connect(ctx context.Context, cfg config.DBConfig) (*sql.DB, error) {
+	db, err := open(DSN(cfg), cfg)
 	if err != nil {
 		return nil, fmt.Errorf("unable to connect to database: %v", err)
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := retry.Do(ctx, "postgres", cfg.ConnectRetryTimeout, retry.Backoff{}, db.PingContext); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("unable to ping the database: %v", err)
 	}
 
 	return db, nil
 }
+
+// open opens a connection pool, with the pool limits of the configuration
+func open(dsn string, cfg config.DBConfig) (*sql.DB, error) {
+	// Every query gets a span; row iteration and connection housekeeping
+	// are left out to keep traces readable.
+	db, err := otelsql.Open("pgx", dsn,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true, OmitConnPrepare: true}))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	return db, nil
+}
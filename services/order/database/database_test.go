@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"order/config"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDSN(t *testing.T) {
+	dsn, err := url.Parse(DSN(config.DBConfig{
+		Host: "db", Port: 5432, User: "user", Password: "p@ss", Name: "orderdb", SSLMode: "disable",
+		ConnectTimeout: 5 * time.Second, QueryTimeout: 1500 * time.Millisecond,
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "db:5432", dsn.Host)
+	assert.Equal(t, "/orderdb", dsn.Path)
+	assert.Equal(t, "5", dsn.Query().Get("connect_timeout"))
+	assert.Equal(t, "1500", dsn.Query().Get("statement_timeout"))
+}
+
+func TestErrors(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	for _, tc := range []struct {
+		name        string
+		err         error
+		timeout     bool
+		unavailable bool
+	}{
+		{"deadline exceeded", fmt.Errorf("query: %w", context.DeadlineExceeded), true, false},
+		{"statement timeout", &pgconn.PgError{Code: "57014", Message: "canceling statement due to statement timeout"}, true, false},
+		{"connection refused", dialErr, false, true},
+		{"bad connection", driver.ErrBadConn, false, true},
+		{"connection done", sql.ErrConnDone, false, true},
+		{"too many connections", &pgconn.PgError{Code: "53300"}, false, true},
+		{"shutting down", &pgconn.PgError{Code: "57P01"}, false, true},
+		{"constraint violation", &pgconn.PgError{Code: "23503"}, false, false},
+		{"no rows", sql.ErrNoRows, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.timeout, IsTimeout(tc.err))
+			assert.Equal(t, tc.unavailable, IsUnavailable(tc.err))
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/jackc/pgconn"
+)
+
+// IsTimeout reports whether err is a query that ran out of time: its
+// context's deadline, a network timeout or the statement_timeout.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "57014" // query_canceled
+}
+
+// IsUnavailable reports whether err means Postgres couldn't be reached or
+// refused to serve the query, so retrying later may succeed.
+func IsUnavailable(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code[:2] {
+		case "08", // connection_exception
+			"53": // insufficient_resources, e.g. too_many_connections
+			return true
+		}
+		// admin_shutdown, crash_shutdown and cannot_connect_now
+		return pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
+	// Failed dials and broken connections
+	var netErr net.Error
+	return errors.As(err, &netErr) && !netErr.Timeout()
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisWrites keeps recent writes in Redis, so that every instance of the
+// service reads records written by any of them from the primary
+type redisWrites struct {
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+// NewRedisWrites remembers writes in rdb for ttl
+func NewRedisWrites(rdb *redis.Client, ttl time.Duration) RecentWrites {
+	return &redisWrites{rdb: rdb, ttl: ttl}
+}
+
+func (w *redisWrites) Add(ctx context.Context, keys ...string) error {
+	pipe := w.rdb.Pipeline()
+	for _, key := range keys {
+		pipe.Set(ctx, "written:"+key, 1, w.ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (w *redisWrites) Any(ctx context.Context, keys ...string) (bool, error) {
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		redisKeys[i] = "written:" + key
+	}
+	n, err := w.rdb.Exists(ctx, redisKeys...).Result()
+	return n > 0, err
+}
@@ -0,0 +1,115 @@
+package events
+
+import (
+	"order/metrics"
+	"order/models"
+	"sync"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind by before
+// it is dropped.
+const subscriberBuffer = 32
+
+type subscription struct {
+	match func(models.OrderEvent) bool
+	ch    chan models.OrderEvent
+}
+
+// Broker fans order status events out to in-process subscribers such as
+// open SSE streams.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[*subscription]struct{}
+	listeners   []func(models.OrderEvent)
+	closed      bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[*subscription]struct{})}
+}
+
+// Subscribe returns a channel receiving every published event for which
+// match returns true, and a function to cancel the subscription. The channel
+// is closed if the subscriber falls too far behind; it is expected to
+// resubscribe and catch up from the status history.
+func (b *Broker) Subscribe(match func(models.OrderEvent) bool) (<-chan models.OrderEvent, func()) {
+	sub := &subscription{match: match, ch: make(chan models.OrderEvent, subscriberBuffer)}
+
+	b.mu.Lock()
+	if b.closed {
+		close(sub.ch)
+	} else {
+		b.subscribers[sub] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	return sub.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(sub)
+	}
+}
+
+// OnPublish has f called with every event as it is published, before it is
+// delivered. Unlike subscribers, listeners are never dropped, so they can
+// keep state such as caches in line with every status change.
+func (b *Broker) OnPublish(f func(models.OrderEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, f)
+}
+
+// Publish delivers the event to all matching subscribers without blocking.
+// Every status change is published once it has been committed, so this is
+// also where they are counted.
+func (b *Broker) Publish(event models.OrderEvent) {
+	metrics.OrderStatusChanges.WithLabelValues(string(event.Status)).Inc()
+
+	b.mu.Lock()
+	listeners := b.listeners
+	b.mu.Unlock()
+	for _, f := range listeners {
+		f(event)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if !sub.match(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			b.remove(sub)
+		}
+	}
+}
+
+// Close ends every subscription, which finishes open event streams, and
+// makes later subscriptions end immediately. It is called on shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subscribers {
+		b.remove(sub)
+	}
+}
+
+// Closed reports whether the broker has been closed, after which
+// subscribing again is pointless
+func (b *Broker) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *Broker) remove(sub *subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
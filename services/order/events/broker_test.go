@@ -0,0 +1,79 @@
+package events
+
+import (
+	"order/models"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker()
+	orderID := uuid.New()
+
+	t.Run("delivers matching events", func(t *testing.T) {
+		ch, cancel := broker.Subscribe(func(e models.OrderEvent) bool { return e.OrderID == orderID })
+		defer cancel()
+
+		broker.Publish(models.OrderEvent{ID: 1, OrderID: uuid.New(), Status: models.Completed})
+		broker.Publish(models.OrderEvent{ID: 2, OrderID: orderID, Status: models.Completed})
+
+		event := <-ch
+		assert.Equal(t, int64(2), event.ID)
+		assert.Len(t, ch, 0)
+	})
+
+	t.Run("listeners get every event", func(t *testing.T) {
+		broker := NewBroker()
+		var heard []int64
+		broker.OnPublish(func(e models.OrderEvent) { heard = append(heard, e.ID) })
+
+		for i := int64(1); i <= subscriberBuffer+1; i++ {
+			broker.Publish(models.OrderEvent{ID: i, OrderID: orderID, Status: models.Completed})
+		}
+		assert.Len(t, heard, subscriberBuffer+1)
+	})
+
+	t.Run("cancel closes the channel", func(t *testing.T) {
+		ch, cancel := broker.Subscribe(func(models.OrderEvent) bool { return true })
+		cancel()
+		cancel()
+
+		_, ok := <-ch
+		assert.False(t, ok)
+	})
+
+	t.Run("drops slow subscribers", func(t *testing.T) {
+		ch, cancel := broker.Subscribe(func(models.OrderEvent) bool { return true })
+		defer cancel()
+
+		for i := 0; i <= subscriberBuffer; i++ {
+			broker.Publish(models.OrderEvent{ID: int64(i), OrderID: orderID})
+		}
+
+		received := 0
+		for range ch {
+			received++
+		}
+		assert.Equal(t, subscriberBuffer, received)
+	})
+
+	t.Run("close ends all subscriptions", func(t *testing.T) {
+		broker := NewBroker()
+		before, cancel := broker.Subscribe(func(models.OrderEvent) bool { return true })
+		defer cancel()
+
+		assert.False(t, broker.Closed())
+		broker.Close()
+		assert.True(t, broker.Closed())
+		after, cancelAfter := broker.Subscribe(func(models.OrderEvent) bool { return true })
+		defer cancelAfter()
+		broker.Publish(models.OrderEvent{ID: 1, OrderID: orderID})
+
+		_, ok := <-before
+		assert.False(t, ok)
+		_, ok = <-after
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,112 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"order/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Querier is satisfied by both *sql.DB and *sql.Tx
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const eventColumns = "id, order_id, customer_id, status, created_at"
+
+func scanEvent(row interface{ Scan(...interface{}) error }, e *models.OrderEvent) error {
+	return row.Scan(&e.ID, &e.OrderID, &e.CustomerID, &e.Status, &e.CreatedAt)
+}
+
+// UpdateOrderStatus moves an order to status and records the transition in
+// its status history. If from is given the order must currently be in one of
+// those statuses. It returns nil if the order was left unchanged, either
+// because it was already in status or wasn't in any of from.
+func UpdateOrderStatus(ctx context.Context, q Querier, orderID uuid.UUID, status models.OrderStatus, from ...models.OrderStatus) (*models.OrderEvent, error) {
+	args := []interface{}{orderID, status}
+	condition := ""
+	if len(from) > 0 {
+		placeholders := make([]string, len(from))
+		for i, f := range from {
+			args = append(args, f)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		condition = " AND status IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	var event models.OrderEvent
+	err := scanEvent(q.QueryRowContext(ctx,
+		`WITH updated AS (
+			UPDATE orders SET status = $2, updated_at = NOW() WHERE id = $1 AND status <> $2`+condition+` RETURNING id, customer_id, status
+		)
+		INSERT INTO order_status_history (order_id, customer_id, status) SELECT id, customer_id, status FROM updated RETURNING `+eventColumns,
+		args...), &event)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// CommitWindow bounds how long a transaction recording a status change
+// runs. Event IDs are taken when a change is made but become visible when it
+// commits, so an event may show up after others with higher IDs, though not
+// after any recorded more than CommitWindow later.
+const CommitWindow = time.Minute
+
+// OrderHistory returns the status history of an order after the given event
+// ID, along with events before it that may have committed since; see
+// CommitWindow.
+func OrderHistory(ctx context.Context, q Querier, orderID uuid.UUID, afterID int64) ([]models.OrderEvent, error) {
+	return history(ctx, q, "order_id", orderID, afterID)
+}
+
+// CustomerHistory returns the status history of all of a customer's orders
+// after the given event ID, as OrderHistory does
+func CustomerHistory(ctx context.Context, q Querier, customerID uuid.UUID, afterID int64) ([]models.OrderEvent, error) {
+	return history(ctx, q, "customer_id", customerID, afterID)
+}
+
+func history(ctx context.Context, q Querier, column string, id uuid.UUID, afterID int64) ([]models.OrderEvent, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT "+eventColumns+" FROM order_status_history WHERE "+column+` = $1 AND (id > $2
+			OR created_at >= (SELECT created_at FROM order_status_history WHERE id = $2) - make_interval(secs => $3))
+		ORDER BY id`,
+		id, afterID, CommitWindow.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return collectEvents(rows)
+}
+
+// PendingWebhooks returns up to limit of the oldest events whose webhook
+// deliveries haven't been enqueued, locked until the transaction of q ends.
+// Events locked by another transaction are skipped.
+func PendingWebhooks(ctx context.Context, q Querier, limit int) ([]models.OrderEvent, error) {
+	rows, err := q.QueryContext(ctx, "SELECT "+eventColumns+" FROM order_status_history WHERE NOT webhooks_enqueued ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED", limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectEvents(rows)
+}
+
+func collectEvents(rows *sql.Rows) ([]models.OrderEvent, error) {
+	defer rows.Close()
+
+	var history []models.OrderEvent
+	for rows.Next() {
+		var event models.OrderEvent
+		if err := scanEvent(rows, &event); err != nil {
+			return nil, err
+		}
+		history = append(history, event)
+	}
+	return history, rows.Err()
+}
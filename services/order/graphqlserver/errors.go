@@ -0,0 +1,69 @@
+package graphqlserver
+
+import (
+	"context"
+	"errors"
+
+	"order/logging"
+	"order/models"
+	"order/problem"
+	"order/service"
+)
+
+// queryError is an error of a field. Like a problem, it carries the error
+// code, the request ID and the invalid fields of validation_failed errors,
+// in its extensions.
+type queryError struct {
+	code      models.ErrorCode
+	detail    string
+	fields    []models.FieldError
+	requestID string
+}
+
+func (e *queryError) Error() string { return e.detail }
+
+func (e *queryError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": e.code}
+	if e.requestID != "" {
+		extensions["request_id"] = e.requestID
+	}
+	if len(e.fields) > 0 {
+		extensions["errors"] = e.fields
+	}
+	return extensions
+}
+
+func newQueryError(ctx context.Context, code models.ErrorCode, detail string) *queryError {
+	return &queryError{code: code, detail: detail, requestID: logging.RequestID(ctx)}
+}
+
+// queryErrorOf returns the field error of an error returned by the service
+// package. Storage failures are classified, and logged, as for the HTTP API
+// by problem.StorageFailure.
+func queryErrorOf(ctx context.Context, err error) error {
+	var queryErr *queryError
+	var serviceErr *service.Error
+	var storageErr *service.StorageError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &queryErr):
+		return queryErr
+	case errors.As(err, &serviceErr):
+		e := newQueryError(ctx, serviceErr.Code, serviceErr.Detail)
+		e.fields = serviceErr.Fields
+		return e
+	case errors.As(err, &storageErr):
+		return storageError(ctx, storageErr.Err, storageErr.Detail)
+	default:
+		return storageError(ctx, err, "The request could not be completed")
+	}
+}
+
+func storageError(ctx context.Context, err error, detail string) error {
+	code, detail := problem.StorageFailure(ctx, err, detail)
+	if code == "" {
+		return context.Canceled
+	}
+	return newQueryError(ctx, code, detail)
+}
@@ -0,0 +1,188 @@
+package graphqlserver
+
+import (
+	"context"
+	"sync"
+
+	"order/models"
+	"order/repository"
+	"order/service"
+
+	"github.com/google/uuid"
+)
+
+// loader batches the loads of one kind of record during a request, as a
+// DataLoader does. Keys queued ahead, like the products of a page of
+// orders, are fetched together with the first key loaded, so the relations
+// of a list take one query rather than one per item. Results are kept for
+// the rest of the request.
+type loader[K comparable, V any] struct {
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	queued  []K
+	results map[K]*result[V]
+}
+
+type result[V any] struct {
+	dispatched bool
+	done       chan struct{}
+	value      V
+	found      bool
+	err        error
+}
+
+func newLoader[K comparable, V any](fetch func(ctx context.Context, keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{fetch: fetch, results: make(map[K]*result[V])}
+}
+
+// Queue adds the keys not loaded yet to the next batch
+func (l *loader[K, V]) Queue(keys ...K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		l.result(key)
+	}
+}
+
+// Load returns the value of the key, and whether it was found. If the key
+// isn't in a batch already it fetches every queued key.
+func (l *loader[K, V]) Load(ctx context.Context, key K) (V, bool, error) {
+	l.mu.Lock()
+	r := l.result(key)
+	var batch []K
+	if !r.dispatched {
+		batch, l.queued = l.queued, nil
+		for _, k := range batch {
+			l.results[k].dispatched = true
+		}
+	}
+	l.mu.Unlock()
+
+	if batch != nil {
+		l.run(ctx, batch)
+	}
+	select {
+	case <-r.done:
+		return r.value, r.found, r.err
+	case <-ctx.Done():
+		var zero V
+		return zero, false, ctx.Err()
+	}
+}
+
+// result returns the result of the key, queueing it if it is new. l.mu
+// must be held.
+func (l *loader[K, V]) result(key K) *result[V] {
+	r, ok := l.results[key]
+	if !ok {
+		r = &result[V]{done: make(chan struct{})}
+		l.results[key] = r
+		l.queued = append(l.queued, key)
+	}
+	return r
+}
+
+func (l *loader[K, V]) run(ctx context.Context, keys []K) {
+	values, err := l.fetch(ctx, keys)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		r := l.results[key]
+		r.value, r.found = values[key]
+		r.err = err
+		close(r.done)
+	}
+}
+
+// orderPage is a filter and page of Customer.orders
+type orderPage struct {
+	status        models.OrderStatus
+	limit, offset int
+}
+
+// loaders are the loaders of a request. The orders of every customer seen
+// so far are fetched together, one batch per page asked for.
+type loaders struct {
+	orders    *service.Orders
+	products  *loader[uuid.UUID, models.ProductRead]
+	customers *loader[uuid.UUID, models.Customer]
+
+	mu             sync.Mutex
+	customerIDs    []uuid.UUID
+	customerOrders map[orderPage]*loader[uuid.UUID, []models.OrderRead]
+}
+
+func newLoaders(orders *service.Orders, products *service.Products, customers *service.Customers) *loaders {
+	return &loaders{
+		orders: orders,
+		products: newLoader(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]models.ProductRead, error) {
+			productsByID, err := products.GetMany(ctx, ids)
+			return productsByID, queryErrorOf(ctx, err)
+		}),
+		customers: newLoader(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]models.Customer, error) {
+			customersByID, err := customers.GetMany(ctx, ids)
+			return customersByID, queryErrorOf(ctx, err)
+		}),
+		customerOrders: make(map[orderPage]*loader[uuid.UUID, []models.OrderRead]),
+	}
+}
+
+type loadersKey struct{}
+
+func withLoaders(ctx context.Context, l *loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// queueOrders queues the products and customers of the orders
+func (l *loaders) queueOrders(orders []models.OrderRead) {
+	productIDs := make([]uuid.UUID, len(orders))
+	customerIDs := make([]uuid.UUID, len(orders))
+	for i, orderRead := range orders {
+		productIDs[i] = orderRead.ProductID
+		customerIDs[i] = orderRead.CustomerID
+	}
+	l.products.Queue(productIDs...)
+	l.queueCustomers(customerIDs...)
+}
+
+// queueCustomers queues the customers, and their orders for every page
+// asked for so far
+func (l *loaders) queueCustomers(ids ...uuid.UUID) {
+	l.mu.Lock()
+	l.customerIDs = append(l.customerIDs, ids...)
+	pages := make([]*loader[uuid.UUID, []models.OrderRead], 0, len(l.customerOrders))
+	for _, page := range l.customerOrders {
+		pages = append(pages, page)
+	}
+	l.mu.Unlock()
+
+	l.customers.Queue(ids...)
+	for _, page := range pages {
+		page.Queue(ids...)
+	}
+}
+
+// ordersOf returns the loader of the page of customers' orders, with every
+// customer seen so far queued
+func (l *loaders) ordersOf(page orderPage) *loader[uuid.UUID, []models.OrderRead] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pageLoader, ok := l.customerOrders[page]; ok {
+		return pageLoader
+	}
+
+	filter := repository.OrderFilter{Status: page.status, Limit: page.limit, Offset: page.offset}
+	pageLoader := newLoader(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID][]models.OrderRead, error) {
+		ordersByCustomer, err := l.orders.ListByCustomers(ctx, ids, filter)
+		return ordersByCustomer, queryErrorOf(ctx, err)
+	})
+	pageLoader.Queue(l.customerIDs...)
+	l.customerOrders[page] = pageLoader
+	return pageLoader
+}
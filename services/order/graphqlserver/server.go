@@ -0,0 +1,302 @@
+// Package graphqlserver serves reads of orders, customers and products over
+// GraphQL, through the same service package as the HTTP and gRPC APIs.
+// Relations are loaded in batches per request, so nested fields of a list
+// cost one query per level rather than one per item.
+package graphqlserver
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"order/auth"
+	"order/models"
+	"order/problem"
+	"order/repository"
+	"order/service"
+
+	"github.com/google/uuid"
+	"github.com/graph-gophers/graphql-go"
+	graphqlotel "github.com/graph-gophers/graphql-go/trace/otel"
+)
+
+//go:embed schema.graphql
+var schema string
+
+// maxDepth bounds how deeply queries may nest relations
+const maxDepth = 8
+
+// request is a GraphQL query sent as JSON
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Handler executes GraphQL queries sent as JSON in POST requests, on behalf
+// of the principal of the request
+func Handler(orders *service.Orders, products *service.Products, customers *service.Customers) http.Handler {
+	s := graphql.MustParseSchema(schema, &query{orders: orders, products: products},
+		graphql.MaxDepth(maxDepth),
+		graphql.Tracer(graphqlotel.DefaultTracer()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
+			return
+		}
+
+		ctx := withLoaders(r.Context(), newLoaders(orders, products, customers))
+		response := s.Exec(ctx, req.Query, req.OperationName, req.Variables)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
+
+// require fails unless the principal has the permission
+func require(ctx context.Context, permission string) error {
+	if !auth.FromContext(ctx).Can(permission) {
+		return newQueryError(ctx, models.CodeForbidden, "Missing permission "+permission)
+	}
+	return nil
+}
+
+// parseID parses the ID of the named kind of record
+func parseID(ctx context.Context, id graphql.ID, kind string) (uuid.UUID, error) {
+	parsed, err := service.ParseID(string(id), kind)
+	return parsed, queryErrorOf(ctx, err)
+}
+
+// filterOf returns the filter of the status and page arguments. A limit
+// given must be positive, as with GET /orders; the service checks the rest.
+func filterOf(ctx context.Context, status *string, limit, offset *int32) (repository.OrderFilter, error) {
+	var filter repository.OrderFilter
+	if status != nil {
+		filter.Status = models.OrderStatus(strings.ToLower(*status))
+	}
+	if limit != nil {
+		if *limit < 1 {
+			return filter, newQueryError(ctx, models.CodeInvalidParameter, fmt.Sprintf("limit must be between 1 and %d", service.MaxOrderListLimit))
+		}
+		filter.Limit = int(*limit)
+	}
+	if offset != nil {
+		filter.Offset = int(*offset)
+	}
+	return filter, nil
+}
+
+// query resolves the fields of Query
+type query struct {
+	orders   *service.Orders
+	products *service.Products
+}
+
+func (q *query) Order(ctx context.Context, args struct{ ID graphql.ID }) (*orderResolver, error) {
+	if err := require(ctx, models.ScopeOrdersRead); err != nil {
+		return nil, err
+	}
+	id, err := parseID(ctx, args.ID, "order")
+	if err != nil {
+		return nil, err
+	}
+	orderRead, err := q.orders.Get(ctx, id)
+	if err != nil {
+		return nil, queryErrorOf(ctx, err)
+	}
+	return newOrderResolvers(ctx, []models.OrderRead{orderRead})[0], nil
+}
+
+func (q *query) Orders(ctx context.Context, args struct {
+	CustomerID *graphql.ID
+	Status     *string
+	Limit      *int32
+	Offset     *int32
+}) ([]*orderResolver, error) {
+	if err := require(ctx, models.ScopeOrdersRead); err != nil {
+		return nil, err
+	}
+	filter, err := filterOf(ctx, args.Status, args.Limit, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	if args.CustomerID != nil {
+		customerID, err := parseID(ctx, *args.CustomerID, "customer")
+		if err != nil {
+			return nil, err
+		}
+		filter.CustomerID = &customerID
+	}
+
+	orderList, err := q.orders.List(ctx, filter)
+	if err != nil {
+		return nil, queryErrorOf(ctx, err)
+	}
+	return newOrderResolvers(ctx, orderList), nil
+}
+
+func (q *query) Product(ctx context.Context, args struct{ ID graphql.ID }) (*productResolver, error) {
+	if err := require(ctx, models.ScopeProductsRead); err != nil {
+		return nil, err
+	}
+	id, err := parseID(ctx, args.ID, "product")
+	if err != nil {
+		return nil, err
+	}
+	productRead, found, err := loadersFrom(ctx).products.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, newQueryError(ctx, models.CodeNotFound, "Product not found")
+	}
+	return &productResolver{productRead}, nil
+}
+
+func (q *query) Customer(ctx context.Context, args struct{ ID graphql.ID }) (*customerResolver, error) {
+	if err := require(ctx, models.ScopeCustomersRead); err != nil {
+		return nil, err
+	}
+	id, err := parseID(ctx, args.ID, "customer")
+	if err != nil {
+		return nil, err
+	}
+	if !auth.FromContext(ctx).MayAccessCustomer(id) {
+		return nil, newQueryError(ctx, models.CodeForbidden, "Access to other customers is not allowed")
+	}
+
+	l := loadersFrom(ctx)
+	l.queueCustomers(id)
+	customer, found, err := l.customers.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, newQueryError(ctx, models.CodeNotFound, "Customer not found")
+	}
+	return &customerResolver{customer: customer, loaders: l}, nil
+}
+
+// orderResolver resolves the fields of Order
+type orderResolver struct {
+	order   models.OrderRead
+	loaders *loaders
+}
+
+// newOrderResolvers returns the resolvers of the orders, with their
+// products and customers queued to be loaded together
+func newOrderResolvers(ctx context.Context, orders []models.OrderRead) []*orderResolver {
+	l := loadersFrom(ctx)
+	l.queueOrders(orders)
+	resolvers := make([]*orderResolver, len(orders))
+	for i, orderRead := range orders {
+		resolvers[i] = &orderResolver{order: orderRead, loaders: l}
+	}
+	return resolvers
+}
+
+func (o *orderResolver) ID() graphql.ID { return graphql.ID(o.order.ID.String()) }
+
+func (o *orderResolver) Status() string { return strings.ToUpper(string(o.order.Status)) }
+
+func (o *orderResolver) Amount() float64 { return o.order.Amount }
+
+func (o *orderResolver) ShippingAddress() *addressResolver {
+	if o.order.ShippingAddress == nil {
+		return nil
+	}
+	return &addressResolver{*o.order.ShippingAddress}
+}
+
+func (o *orderResolver) CreatedAt() graphql.Time { return graphql.Time{Time: o.order.CreatedAt} }
+
+func (o *orderResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: o.order.UpdatedAt} }
+
+func (o *orderResolver) Customer(ctx context.Context) (*customerResolver, error) {
+	if err := require(ctx, models.ScopeCustomersRead); err != nil {
+		return nil, err
+	}
+	customer, found, err := o.loaders.customers.Load(ctx, o.order.CustomerID)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &customerResolver{customer: customer, loaders: o.loaders}, nil
+}
+
+func (o *orderResolver) Product(ctx context.Context) (*productResolver, error) {
+	if err := require(ctx, models.ScopeProductsRead); err != nil {
+		return nil, err
+	}
+	productRead, found, err := o.loaders.products.Load(ctx, o.order.ProductID)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &productResolver{productRead}, nil
+}
+
+// addressResolver resolves the fields of AddressSnapshot
+type addressResolver struct {
+	address models.AddressSnapshot
+}
+
+func (a *addressResolver) Line1() string      { return a.address.Line1 }
+func (a *addressResolver) Line2() string      { return a.address.Line2 }
+func (a *addressResolver) City() string       { return a.address.City }
+func (a *addressResolver) Region() string     { return a.address.Region }
+func (a *addressResolver) PostalCode() string { return a.address.PostalCode }
+func (a *addressResolver) Country() string    { return a.address.Country }
+
+// productResolver resolves the fields of Product
+type productResolver struct {
+	product models.ProductRead
+}
+
+func (p *productResolver) ID() graphql.ID { return graphql.ID(p.product.ID.String()) }
+
+func (p *productResolver) Name() string { return p.product.Name }
+
+func (p *productResolver) Price() float64 { return p.product.Price }
+
+func (p *productResolver) CreatedAt() graphql.Time { return graphql.Time{Time: p.product.CreatedAt} }
+
+func (p *productResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: p.product.UpdatedAt} }
+
+// customerResolver resolves the fields of Customer
+type customerResolver struct {
+	customer models.Customer
+	loaders  *loaders
+}
+
+func (c *customerResolver) ID() graphql.ID { return graphql.ID(c.customer.ID.String()) }
+
+func (c *customerResolver) Name() string { return c.customer.Name }
+
+func (c *customerResolver) CreatedAt() graphql.Time { return graphql.Time{Time: c.customer.CreatedAt} }
+
+func (c *customerResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: c.customer.UpdatedAt} }
+
+// Orders loads the page of the customer's orders together with the same
+// page of every other customer of the request
+func (c *customerResolver) Orders(ctx context.Context, args struct {
+	Status *string
+	Limit  *int32
+	Offset *int32
+}) ([]*orderResolver, error) {
+	if err := require(ctx, models.ScopeOrdersRead); err != nil {
+		return nil, err
+	}
+	filter, err := filterOf(ctx, args.Status, args.Limit, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	page := orderPage{status: filter.Status, limit: filter.Limit, offset: filter.Offset}
+	orderList, _, err := c.loaders.ordersOf(page).Load(ctx, c.customer.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newOrderResolvers(ctx, orderList), nil
+}
@@ -0,0 +1,213 @@
+package graphqlserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"order/auth"
+	"order/models"
+	"order/repository"
+	"order/service"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type response struct {
+	Data   json.RawMessage
+	Errors []struct {
+		Message    string
+		Path       []interface{}
+		Extensions map[string]interface{}
+	}
+}
+
+// run sends the query on behalf of the principal
+func run(t *testing.T, h http.Handler, principal *auth.Principal, query string) response {
+	body, _ := json.Marshal(map[string]string{"query": query})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+	req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var resp response
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unable to decode %s: %v", rr.Body.String(), err)
+	}
+	return resp
+}
+
+func handlerOf(repos repository.Repositories) http.Handler {
+	return Handler(
+		service.NewOrders(repos.Orders, repos.Customers, nil, nil, nil),
+		service.NewProducts(repos.Products),
+		service.NewCustomers(repos.Customers))
+}
+
+var admin = &auth.Principal{Subject: "admin", Role: models.RoleAdmin}
+
+func TestLoader(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+	l := newLoader(func(ctx context.Context, keys []int) (map[int]string, error) {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+		values := make(map[int]string)
+		for _, key := range keys {
+			if key > 0 {
+				values[key] = strings.Repeat("x", key)
+			}
+		}
+		return values, nil
+	})
+	ctx := context.Background()
+
+	t.Run("queued keys are fetched with the first load", func(t *testing.T) {
+		l.Queue(1, 2, 3, -1)
+
+		var wg sync.WaitGroup
+		for _, key := range []int{3, 2, 1} {
+			wg.Add(1)
+			go func(key int) {
+				defer wg.Done()
+				value, found, err := l.Load(ctx, key)
+				assert.NoError(t, err)
+				assert.True(t, found)
+				assert.Equal(t, strings.Repeat("x", key), value)
+			}(key)
+		}
+		wg.Wait()
+
+		_, found, err := l.Load(ctx, -1)
+		assert.NoError(t, err)
+		assert.False(t, found)
+		assert.Equal(t, [][]int{{1, 2, 3, -1}}, batches)
+	})
+
+	t.Run("loaded keys are kept", func(t *testing.T) {
+		l.Queue(2, 4)
+		_, _, err := l.Load(ctx, 4)
+		assert.NoError(t, err)
+		assert.Equal(t, [][]int{{1, 2, 3, -1}, {4}}, batches)
+	})
+}
+
+// TestNestedQueries checks relations of lists are loaded a level at a time:
+// the orders, then their products and customers, then the customers' orders
+func TestNestedQueries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	orderColumns := []string{"id", "customer_id", "product_id", "status", "amount", "shipping_address_id", "shipping_address", "created_at", "updated_at"}
+	first, second, productID := uuid.New(), uuid.New(), uuid.New()
+	firstOrder, secondOrder, olderOrder := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT (.+) FROM orders ORDER BY created_at DESC, id LIMIT 50$").
+		WillReturnRows(sqlmock.NewRows(orderColumns).
+			AddRow(firstOrder, first, productID, models.Completed, 10.0, nil, nil, now, now).
+			AddRow(secondOrder, second, productID, models.Pending, 20.0, nil, nil, now, now))
+	mock.ExpectQuery("SELECT (.+) FROM products WHERE id IN \\(\\$1\\)").
+		WithArgs(productID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price", "created_at", "updated_at"}).
+			AddRow(productID, "Widget", 9.99, now, now))
+	mock.ExpectQuery("SELECT (.+) FROM customers WHERE id IN \\(\\$1, \\$2\\)").
+		WithArgs(first, second).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(first, "Ada", now, now).
+			AddRow(second, "Grace", now, now))
+	mock.ExpectQuery("SELECT (.+) FROM \\(SELECT (.+) FROM orders WHERE customer_id IN \\(\\$1, \\$2\\)\\) numbered WHERE n > 0 AND n <= 2").
+		WithArgs(first, second).
+		WillReturnRows(sqlmock.NewRows(orderColumns).
+			AddRow(firstOrder, first, productID, models.Completed, 10.0, nil, nil, now, now).
+			AddRow(olderOrder, first, productID, models.Failed, 5.0, nil, nil, now, now).
+			AddRow(secondOrder, second, productID, models.Pending, 20.0, nil, nil, now, now))
+
+	resp := run(t, handlerOf(repository.NewPostgres(db)), admin,
+		`{ orders { id product { name } customer { name orders(limit: 2) { id status product { price } } } } }`)
+	assert.Empty(t, resp.Errors)
+	assert.JSONEq(t, `{"orders": [
+		{"id": "`+firstOrder.String()+`", "product": {"name": "Widget"}, "customer": {"name": "Ada", "orders": [
+			{"id": "`+firstOrder.String()+`", "status": "COMPLETED", "product": {"price": 9.99}},
+			{"id": "`+olderOrder.String()+`", "status": "FAILED", "product": {"price": 9.99}}]}},
+		{"id": "`+secondOrder.String()+`", "product": {"name": "Widget"}, "customer": {"name": "Grace", "orders": [
+			{"id": "`+secondOrder.String()+`", "status": "PENDING", "product": {"price": 9.99}}]}}
+	]}`, string(resp.Data))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueries(t *testing.T) {
+	store := repository.NewMemory()
+	h := handlerOf(store.Repositories())
+
+	customerID, otherID, productID, orderID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	store.AddCustomer(customerID)
+	store.AddCustomer(otherID)
+	store.AddProduct(models.ProductRead{ID: productID, Name: "Widget", Price: 9.99})
+	store.AddOrder(models.OrderRead{ID: orderID, CustomerID: customerID, ProductID: productID, Status: models.Shipped, Amount: 10,
+		ShippingAddress: &models.AddressSnapshot{Line1: "96 Mowat Ave", City: "Toronto", Country: "CA"}})
+	store.AddOrder(models.OrderRead{ID: uuid.New(), CustomerID: otherID, ProductID: productID, Status: models.Pending, Amount: 20})
+
+	customer := &auth.Principal{Subject: "customer", Role: models.RoleCustomer, CustomerID: &customerID}
+
+	t.Run("order with its relations", func(t *testing.T) {
+		resp := run(t, h, customer, `{ order(id: "`+orderID.String()+`") { status shippingAddress { line1 } customer { id } product { name } } }`)
+		assert.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"order": {"status": "SHIPPED", "shippingAddress": {"line1": "96 Mowat Ave"}, "customer": {"id": "`+customerID.String()+`"}, "product": {"name": "Widget"}}}`, string(resp.Data))
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		resp := run(t, h, admin, `{ orders(status: PENDING) { customer { id } } }`)
+		assert.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"orders": [{"customer": {"id": "`+otherID.String()+`"}}]}`, string(resp.Data))
+	})
+
+	t.Run("customers only see their own orders", func(t *testing.T) {
+		resp := run(t, h, customer, `{ orders { id } }`)
+		assert.Empty(t, resp.Errors)
+		assert.JSONEq(t, `{"orders": [{"id": "`+orderID.String()+`"}]}`, string(resp.Data))
+	})
+
+	for _, tc := range []struct {
+		name      string
+		principal *auth.Principal
+		query     string
+		code      models.ErrorCode
+		data      string
+	}{
+		{"order of another customer", &auth.Principal{Role: models.RoleCustomer, CustomerID: &otherID}, `{ order(id: "` + orderID.String() + `") { id } }`, models.CodeNotFound, `{"order": null}`},
+		{"another customer", customer, `{ customer(id: "` + otherID.String() + `") { id } }`, models.CodeForbidden, `{"customer": null}`},
+		{"orders of another customer", customer, `{ orders(customerId: "` + otherID.String() + `") { id } }`, models.CodeForbidden, `null`},
+		{"invalid ID", admin, `{ product(id: "1") { id } }`, models.CodeInvalidParameter, `{"product": null}`},
+		{"missing product", admin, `{ product(id: "` + uuid.NewString() + `") { id } }`, models.CodeNotFound, `{"product": null}`},
+		{"limit out of range", admin, `{ orders(limit: 0) { id } }`, models.CodeInvalidParameter, `null`},
+		{"relation without permission", &auth.Principal{Role: models.RoleAdmin, Scopes: []string{models.ScopeOrdersRead}}, `{ order(id: "` + orderID.String() + `") { status customer { id } } }`, models.CodeForbidden, `{"order": {"status": "SHIPPED", "customer": null}}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := run(t, h, tc.principal, tc.query)
+			if assert.Len(t, resp.Errors, 1) {
+				assert.Equal(t, string(tc.code), resp.Errors[0].Extensions["code"])
+			}
+			assert.JSONEq(t, tc.data, string(resp.Data))
+		})
+	}
+
+	t.Run("invalid payload", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader("{")))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
@@ -0,0 +1,101 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+
+	"order/logging"
+	"order/models"
+	"order/problem"
+	"order/service"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// errorDomain is the domain of the ErrorInfo of every error
+const errorDomain = "order-service"
+
+// errorCodes are the gRPC codes of the service's error codes
+var errorCodes = map[models.ErrorCode]codes.Code{
+	models.CodeInvalidPayload:         codes.InvalidArgument,
+	models.CodeValidationFailed:       codes.InvalidArgument,
+	models.CodeInvalidParameter:       codes.InvalidArgument,
+	models.CodeInvalidShippingAddress: codes.InvalidArgument,
+	models.CodeUnauthenticated:        codes.Unauthenticated,
+	models.CodeForbidden:              codes.PermissionDenied,
+	models.CodeNotFound:               codes.NotFound,
+	models.CodeOrderNotPaid:           codes.FailedPrecondition,
+	models.CodeInvalidTransition:      codes.FailedPrecondition,
+	models.CodeRateLimited:            codes.ResourceExhausted,
+	models.CodeQuotaExceeded:          codes.ResourceExhausted,
+	models.CodeTimeout:                codes.DeadlineExceeded,
+	models.CodeUnavailable:            codes.Unavailable,
+	models.CodeInternal:               codes.Internal,
+}
+
+// statusOf returns the gRPC status of an error returned by the service
+// package. Like a problem, it carries the error code, as the reason of an
+// ErrorInfo, and the request ID; invalid fields are listed in a BadRequest.
+func statusOf(ctx context.Context, err error) error {
+	var serviceErr *service.Error
+	var storageErr *service.StorageError
+	switch {
+	case errors.As(err, &serviceErr):
+		return newStatus(ctx, serviceErr)
+	case errors.As(err, &storageErr):
+		return storageStatus(ctx, storageErr.Err, storageErr.Detail)
+	default:
+		return storageStatus(ctx, err, "The request could not be completed")
+	}
+}
+
+// storageStatus returns the status of a failed storage call, classified as
+// for the HTTP API by problem.StorageFailure
+func storageStatus(ctx context.Context, err error, detail string) error {
+	code, detail := problem.StorageFailure(ctx, err, detail)
+	switch code {
+	case "":
+		return status.Error(codes.Canceled, "The request was cancelled")
+	case models.CodeUnavailable:
+		return newStatus(ctx, &service.Error{Code: code, Detail: detail, RetryAfter: problem.UnavailableRetryAfter})
+	default:
+		return newStatus(ctx, &service.Error{Code: code, Detail: detail})
+	}
+}
+
+func newStatus(ctx context.Context, e *service.Error) error {
+	code, ok := errorCodes[e.Code]
+	if !ok {
+		code = codes.Unknown
+	}
+	st := status.New(code, e.Detail)
+
+	details := []protoiface.MessageV1{
+		&errdetails.ErrorInfo{Reason: string(e.Code), Domain: errorDomain},
+	}
+	if id := logging.RequestID(ctx); id != "" {
+		details = append(details, &errdetails.RequestInfo{RequestId: id})
+	}
+	if len(e.Fields) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, field := range e.Fields {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       field.Field,
+				Description: field.Message,
+			})
+		}
+		details = append(details, badRequest)
+	}
+	if e.RetryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)})
+	}
+
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
@@ -0,0 +1,178 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"order/auth"
+	"order/logging"
+	"order/metrics"
+	"order/models"
+	orderv1 "order/proto/order/v1"
+	"order/ratelimit"
+	"order/service"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys of gRPC calls, the counterparts of the HTTP headers
+const (
+	apiKeyMetadata        = "x-api-key"
+	authorizationMetadata = "authorization"
+	requestIDMetadata     = "x-request-id"
+)
+
+// methods gives each method the permission it needs and the HTTP route
+// whose rate limit it shares, so a client has the same limits on both APIs
+var methods = map[string]struct{ permission, route string }{
+	orderv1.OrderService_CreateOrder_FullMethodName:   {models.ScopeOrdersWrite, "POST /order"},
+	orderv1.OrderService_GetOrder_FullMethodName:      {models.ScopeOrdersRead, "GET /order/{id}"},
+	orderv1.OrderService_ListOrders_FullMethodName:    {models.ScopeOrdersRead, "GET /orders"},
+	orderv1.OrderService_CreateProduct_FullMethodName: {models.ScopeProductsWrite, "POST /product"},
+	orderv1.OrderService_GetProduct_FullMethodName:    {models.ScopeProductsRead, "GET /product/{id}"},
+	orderv1.OrderService_WatchOrder_FullMethodName:    {models.ScopeOrdersRead, "GET /order/{id}/events"},
+}
+
+// interceptors admit calls as the HTTP middleware admits requests, and log
+// and measure them
+type interceptors struct {
+	authenticator *auth.Authenticator
+	limiter       *ratelimit.Limiter
+	timeout       time.Duration
+}
+
+func (i *interceptors) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx, err := i.admit(withRequestID(ctx), info.FullMethod)
+	var resp interface{}
+	if err == nil {
+		if i.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, i.timeout)
+			defer cancel()
+		}
+		resp, err = handler(ctx, req)
+	}
+	callHandled(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (i *interceptors) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := i.admit(withRequestID(ss.Context()), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+	callHandled(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// serverStream is a stream with the context the interceptor built
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// withRequestID takes the request ID from the x-request-id metadata, or
+// assigns one, and sends it back in the response header
+func withRequestID(ctx context.Context) context.Context {
+	id := incoming(ctx, requestIDMetadata)
+	if !logging.ValidRequestID(id) {
+		id = uuid.NewString()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, id))
+	return logging.WithRequestID(ctx, id)
+}
+
+// admit authenticates the caller, checks it has the method's permission and
+// takes a token from its rate limit, returning ctx carrying its principal.
+// Callers whose IP address keeps failing to authenticate are refused
+// first. If the rate limiter is unavailable calls are let through.
+func (i *interceptors) admit(ctx context.Context, fullMethod string) (context.Context, error) {
+	method, ok := methods[fullMethod]
+	if !ok {
+		return ctx, status.Error(codes.Unimplemented, "Unknown method "+fullMethod)
+	}
+
+	ip := ""
+	if p, ok := peer.FromContext(ctx); ok && i.limiter != nil {
+		ip = ratelimit.IP(p.Addr.String())
+		result, err := i.limiter.AllowAuthentication(ctx, ip)
+		if err != nil {
+			slog.WarnContext(ctx, "Rate limiter unavailable, allowing request", "error", err)
+		} else if !result.Allowed {
+			metrics.RateLimited.WithLabelValues(method.route).Inc()
+			slog.InfoContext(ctx, "Too many failed authentications", "ip", ip)
+			return ctx, newStatus(ctx, &service.Error{Code: models.CodeRateLimited, Detail: "Too many failed authentications", RetryAfter: result.RetryAfter})
+		}
+	}
+
+	credential := auth.Credential(incoming(ctx, apiKeyMetadata), incoming(ctx, authorizationMetadata))
+	principal, err := i.authenticator.AuthenticateCredential(ctx, credential)
+	var authErr *auth.AuthError
+	if errors.As(err, &authErr) {
+		slog.InfoContext(ctx, "Request not authenticated", "error", err)
+		if ip != "" {
+			if err := i.limiter.AuthenticationFailed(ctx, ip); err != nil {
+				slog.WarnContext(ctx, "Unable to count failed authentication", "error", err)
+			}
+		}
+		return ctx, newStatus(ctx, &service.Error{Code: models.CodeUnauthenticated, Detail: "Authentication required"})
+	}
+	if err != nil {
+		return ctx, storageStatus(ctx, err, "Failed to authenticate request")
+	}
+	if !principal.Can(method.permission) {
+		return ctx, newStatus(ctx, &service.Error{Code: models.CodeForbidden, Detail: "Missing permission " + method.permission})
+	}
+	ctx = auth.WithPrincipal(ctx, principal)
+
+	if i.limiter == nil {
+		return ctx, nil
+	}
+	result, err := i.limiter.Allow(ctx, method.route, ratelimit.PrincipalClient(principal))
+	if err != nil {
+		slog.WarnContext(ctx, "Rate limiter unavailable, allowing request", "error", err)
+		return ctx, nil
+	}
+	if !result.Allowed {
+		metrics.RateLimited.WithLabelValues(method.route).Inc()
+		return ctx, newStatus(ctx, &service.Error{Code: models.CodeRateLimited, Detail: "Rate limit exceeded", RetryAfter: result.RetryAfter})
+	}
+	return ctx, nil
+}
+
+// incoming returns the first value of the key in the call's metadata
+func incoming(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// callHandled logs and measures a finished call
+func callHandled(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	duration := time.Since(start)
+	metrics.GRPC(method, code.String(), duration)
+
+	level := slog.LevelInfo
+	switch code {
+	case codes.Unknown, codes.Internal, codes.DataLoss:
+		level = slog.LevelError
+	}
+	slog.Log(ctx, level, "call handled",
+		"method", method,
+		"code", code.String(),
+		"duration_ms", float64(duration.Microseconds())/1000)
+}
@@ -0,0 +1,512 @@
+package grpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"order/auth"
+	"order/config"
+	"order/events"
+	"order/handlers"
+	"order/models"
+	orderv1 "order/proto/order/v1"
+	"order/ratelimit"
+	"order/repository"
+	"order/service"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const hmacSecret = "0123456789abcdef0123456789abcdef"
+
+// apis serves one store over both APIs: HTTP through the handlers, as the
+// service's router does, and gRPC over an in-memory connection
+type apis struct {
+	store  *repository.Memory
+	broker *events.Broker
+	quota  *ratelimit.OrderQuota
+	http   *httptest.Server
+	grpc   orderv1.OrderServiceClient
+}
+
+func newAPIs(t *testing.T) *apis {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when starting miniredis", err)
+	}
+	t.Cleanup(mr.Close)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	authenticator, err := auth.NewAuthenticator(nil, config.JWTConfig{HMACSecret: hmacSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &apis{store: repository.NewMemory(), broker: events.NewBroker(), quota: ratelimit.NewOrderQuota(rdb, 100)}
+	repos := a.store.Repositories()
+	orders := service.NewOrders(repos.Orders, repos.Customers, a.broker, rdb, a.quota)
+	products := service.NewProducts(repos.Products)
+
+	router := mux.NewRouter()
+	router.Use(authenticator.Middleware)
+	handle := func(path, method, permission string, h http.HandlerFunc) {
+		router.Handle(path, auth.Require(permission, h)).Methods(method)
+	}
+	handle("/product", http.MethodPost, models.ScopeProductsWrite, handlers.CreateProductHandler(products))
+	handle("/product/{id}", http.MethodGet, models.ScopeProductsRead, handlers.GetProductHandler(products))
+	handle("/order", http.MethodPost, models.ScopeOrdersWrite, handlers.CreateOrderHandler(orders))
+	handle("/order/{id}", http.MethodGet, models.ScopeOrdersRead, handlers.GetOrderHandler(orders))
+	handle("/orders", http.MethodGet, models.ScopeOrdersRead, handlers.ListOrdersHandler(orders))
+	handle("/order/{id}/events", http.MethodGet, models.ScopeOrdersRead, handlers.OrderEventsHandler(orders))
+	a.http = httptest.NewServer(router)
+	t.Cleanup(a.http.Close)
+
+	listener := bufconn.Listen(1 << 20)
+	server := New(orders, products, authenticator, nil, time.Second)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	a.grpc = orderv1.NewOrderServiceClient(conn)
+	return a
+}
+
+// token returns a bearer token for the role, on behalf of the customer if
+// one is given
+func token(t *testing.T, role string, customerID *uuid.UUID) string {
+	claims := jwt.MapClaims{"sub": "user-1", "role": role, "exp": time.Now().Add(time.Hour).Unix()}
+	if customerID != nil {
+		claims["customer_id"] = customerID.String()
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(hmacSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// do sends an HTTP request with the bearer token, if any, and decodes the
+// response into result, if it succeeded, or a problem
+func (a *apis) do(t *testing.T, bearer, method, path, body string, result interface{}) (*http.Response, models.Problem) {
+	req, err := http.NewRequest(method, a.http.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p models.Problem
+	if resp.StatusCode >= http.StatusBadRequest {
+		err = json.Unmarshal(data, &p)
+	} else {
+		err = json.Unmarshal(data, result)
+	}
+	if err != nil {
+		t.Fatalf("unable to decode %s: %v", data, err)
+	}
+	return resp, p
+}
+
+// withToken returns ctx sending the bearer token, if any, with gRPC calls
+func withToken(bearer string) context.Context {
+	ctx := context.Background()
+	if bearer == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+bearer)
+}
+
+// problemOf returns the problem a gRPC status stands for, read from its
+// details
+func problemOf(t *testing.T, err error) (codes.Code, models.Problem) {
+	st, ok := status.FromError(err)
+	if !ok {
+		t.Fatalf("%v is not a gRPC status", err)
+	}
+	p := models.Problem{Detail: st.Message()}
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			p.Code = models.ErrorCode(detail.Reason)
+		case *errdetails.RequestInfo:
+			p.RequestID = detail.RequestId
+		case *errdetails.BadRequest:
+			for _, violation := range detail.FieldViolations {
+				p.Errors = append(p.Errors, models.FieldError{Field: violation.Field, Message: violation.Description})
+			}
+		}
+	}
+	return st.Code(), p
+}
+
+// orderFromProto reads back an order as the HTTP API returns it
+func orderFromProto(order *orderv1.Order) models.OrderRead {
+	orderRead := models.OrderRead{
+		ID:         uuid.MustParse(order.Id),
+		CustomerID: uuid.MustParse(order.CustomerId),
+		ProductID:  uuid.MustParse(order.ProductId),
+		Status:     statusFromProto(order.Status),
+		Amount:     order.Amount,
+		CreatedAt:  order.CreatedAt.AsTime(),
+		UpdatedAt:  order.UpdatedAt.AsTime(),
+	}
+	if order.ShippingAddressId != "" {
+		addressID := uuid.MustParse(order.ShippingAddressId)
+		orderRead.ShippingAddressID = &addressID
+	}
+	if address := order.ShippingAddress; address != nil {
+		orderRead.ShippingAddress = &models.AddressSnapshot{
+			Line1:      address.Line1,
+			Line2:      address.Line2,
+			City:       address.City,
+			Region:     address.Region,
+			PostalCode: address.PostalCode,
+			Country:    address.Country,
+		}
+	}
+	return orderRead
+}
+
+// assertSameOrder compares orders read from the two APIs, whose times differ
+// only in location
+func assertSameOrder(t *testing.T, want, got models.OrderRead) {
+	assert.True(t, want.CreatedAt.Equal(got.CreatedAt), "created_at %v != %v", want.CreatedAt, got.CreatedAt)
+	assert.True(t, want.UpdatedAt.Equal(got.UpdatedAt), "updated_at %v != %v", want.UpdatedAt, got.UpdatedAt)
+	want.CreatedAt, want.UpdatedAt = got.CreatedAt, got.UpdatedAt
+	assert.Equal(t, want, got)
+}
+
+func TestParity(t *testing.T) {
+	a := newAPIs(t)
+	admin := token(t, models.RoleAdmin, nil)
+
+	customerID, productID, addressID := uuid.New(), uuid.New(), uuid.New()
+	a.store.AddCustomer(customerID)
+	a.store.AddProduct(models.ProductRead{ID: productID, Name: "Sample Product", Price: 99.99})
+	a.store.AddAddress(addressID, customerID, models.Shipping, models.AddressSnapshot{Line1: "96 Mowat Ave", City: "Toronto", Region: "ON", PostalCode: "M6K 3M1", Country: "CA"})
+	customer := token(t, models.RoleCustomer, &customerID)
+
+	t.Run("products created on one API are read the same on the other", func(t *testing.T) {
+		var created models.ProductRead
+		resp, _ := a.do(t, admin, "POST", "/product", `{"name": "Widget", "price": 9.5}`, &created)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		product, err := a.grpc.GetProduct(withToken(admin), &orderv1.GetProductRequest{Id: created.ID.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, created.ID.String(), product.Id)
+		assert.Equal(t, created.Name, product.Name)
+		assert.Equal(t, created.Price, product.Price)
+		assert.True(t, created.CreatedAt.Equal(product.CreatedAt.AsTime()))
+
+		product, err = a.grpc.CreateProduct(withToken(admin), &orderv1.CreateProductRequest{Name: "Gadget", Price: 12})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var read models.ProductRead
+		resp, _ = a.do(t, admin, "GET", "/product/"+product.Id, "", &read)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "Gadget", read.Name)
+		assert.Equal(t, 12.0, read.Price)
+	})
+
+	t.Run("orders created on one API are read the same on the other", func(t *testing.T) {
+		var created models.OrderRead
+		resp, _ := a.do(t, customer, "POST", "/order", `{"customer_id": "`+customerID.String()+`", "product_id": "`+productID.String()+`", "amount": 25, "shipping_address_id": "`+addressID.String()+`"}`, &created)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		order, err := a.grpc.GetOrder(withToken(customer), &orderv1.GetOrderRequest{Id: created.ID.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSameOrder(t, created, orderFromProto(order))
+
+		order, err = a.grpc.CreateOrder(withToken(customer), &orderv1.CreateOrderRequest{CustomerId: customerID.String(), ProductId: productID.String(), Amount: 30})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, orderv1.OrderStatus_ORDER_STATUS_PENDING, order.Status)
+		var read models.OrderRead
+		resp, _ = a.do(t, customer, "GET", "/order/"+order.Id, "", &read)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assertSameOrder(t, orderFromProto(order), read)
+	})
+
+	t.Run("orders are listed the same", func(t *testing.T) {
+		for _, query := range []struct {
+			path string
+			req  *orderv1.ListOrdersRequest
+		}{
+			{"/orders", &orderv1.ListOrdersRequest{}},
+			{"/orders?status=pending&limit=1&offset=1", &orderv1.ListOrdersRequest{Status: orderv1.OrderStatus_ORDER_STATUS_PENDING, Limit: 1, Offset: 1}},
+		} {
+			var listed []models.OrderRead
+			resp, _ := a.do(t, customer, "GET", query.path, "", &listed)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			list, err := a.grpc.ListOrders(withToken(customer), query.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if assert.Len(t, list.Orders, len(listed), query.path) {
+				for i, order := range list.Orders {
+					assertSameOrder(t, listed[i], orderFromProto(order))
+				}
+			}
+		}
+	})
+
+	t.Run("errors are reported the same", func(t *testing.T) {
+		otherID := uuid.New()
+		other := token(t, models.RoleCustomer, &otherID)
+		missingID := uuid.New().String()
+		orderID := uuid.New()
+		a.store.AddOrder(models.OrderRead{ID: orderID, CustomerID: customerID, ProductID: productID, Status: models.Pending})
+
+		thriftyID := uuid.New()
+		a.store.AddCustomer(thriftyID)
+		if _, err := a.quota.Reserve(context.Background(), thriftyID, 100); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range []struct {
+			name         string
+			token        string
+			method, path string
+			body         string
+			call         func(ctx context.Context) error
+			status       int
+			code         codes.Code
+			errorCode    models.ErrorCode
+		}{
+			{
+				name:  "missing credentials",
+				token: "", method: "GET", path: "/order/" + orderID.String(),
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.GetOrder(ctx, &orderv1.GetOrderRequest{Id: orderID.String()})
+					return err
+				},
+				status: http.StatusUnauthorized, code: codes.Unauthenticated, errorCode: models.CodeUnauthenticated,
+			},
+			{
+				name:  "missing permission",
+				token: customer, method: "POST", path: "/product", body: `{"name": "Widget", "price": 1}`,
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.CreateProduct(ctx, &orderv1.CreateProductRequest{Name: "Widget", Price: 1})
+					return err
+				},
+				status: http.StatusForbidden, code: codes.PermissionDenied, errorCode: models.CodeForbidden,
+			},
+			{
+				name:  "invalid order ID",
+				token: admin, method: "GET", path: "/order/not-a-uuid",
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.GetOrder(ctx, &orderv1.GetOrderRequest{Id: "not-a-uuid"})
+					return err
+				},
+				status: http.StatusBadRequest, code: codes.InvalidArgument, errorCode: models.CodeInvalidParameter,
+			},
+			{
+				name:  "order not found",
+				token: admin, method: "GET", path: "/order/" + missingID,
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.GetOrder(ctx, &orderv1.GetOrderRequest{Id: missingID})
+					return err
+				},
+				status: http.StatusNotFound, code: codes.NotFound, errorCode: models.CodeNotFound,
+			},
+			{
+				name:  "order of another customer",
+				token: other, method: "GET", path: "/order/" + orderID.String(),
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.GetOrder(ctx, &orderv1.GetOrderRequest{Id: orderID.String()})
+					return err
+				},
+				status: http.StatusNotFound, code: codes.NotFound, errorCode: models.CodeNotFound,
+			},
+			{
+				name:  "watching an order of another customer",
+				token: other, method: "GET", path: "/order/" + orderID.String() + "/events",
+				call: func(ctx context.Context) error {
+					stream, err := a.grpc.WatchOrder(ctx, &orderv1.WatchOrderRequest{Id: orderID.String()})
+					if err != nil {
+						return err
+					}
+					_, err = stream.Recv()
+					return err
+				},
+				status: http.StatusNotFound, code: codes.NotFound, errorCode: models.CodeNotFound,
+			},
+			{
+				name:  "malformed order",
+				token: admin, method: "POST", path: "/order", body: `{"customer_id": "not-a-uuid"}`,
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.CreateOrder(ctx, &orderv1.CreateOrderRequest{CustomerId: "not-a-uuid"})
+					return err
+				},
+				status: http.StatusBadRequest, code: codes.InvalidArgument, errorCode: models.CodeInvalidPayload,
+			},
+			{
+				name:  "invalid order",
+				token: admin, method: "POST", path: "/order", body: `{"customer_id": "` + customerID.String() + `"}`,
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.CreateOrder(ctx, &orderv1.CreateOrderRequest{CustomerId: customerID.String()})
+					return err
+				},
+				status: http.StatusBadRequest, code: codes.InvalidArgument, errorCode: models.CodeValidationFailed,
+			},
+			{
+				name:  "order for another customer",
+				token: other, method: "POST", path: "/order", body: `{"customer_id": "` + customerID.String() + `", "product_id": "` + productID.String() + `", "amount": 1}`,
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.CreateOrder(ctx, &orderv1.CreateOrderRequest{CustomerId: customerID.String(), ProductId: productID.String(), Amount: 1})
+					return err
+				},
+				status: http.StatusForbidden, code: codes.PermissionDenied, errorCode: models.CodeForbidden,
+			},
+			{
+				name:  "invalid shipping address",
+				token: admin, method: "POST", path: "/order", body: `{"customer_id": "` + customerID.String() + `", "product_id": "` + productID.String() + `", "amount": 1, "shipping_address_id": "` + missingID + `"}`,
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.CreateOrder(ctx, &orderv1.CreateOrderRequest{CustomerId: customerID.String(), ProductId: productID.String(), Amount: 1, ShippingAddressId: missingID})
+					return err
+				},
+				status: http.StatusBadRequest, code: codes.InvalidArgument, errorCode: models.CodeInvalidShippingAddress,
+			},
+			{
+				name:  "daily order quota exceeded",
+				token: admin, method: "POST", path: "/order", body: `{"customer_id": "` + thriftyID.String() + `", "product_id": "` + productID.String() + `", "amount": 1}`,
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.CreateOrder(ctx, &orderv1.CreateOrderRequest{CustomerId: thriftyID.String(), ProductId: productID.String(), Amount: 1})
+					return err
+				},
+				status: http.StatusTooManyRequests, code: codes.ResourceExhausted, errorCode: models.CodeQuotaExceeded,
+			},
+			{
+				name:  "listing orders of another customer",
+				token: other, method: "GET", path: "/orders?customer_id=" + customerID.String(),
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.ListOrders(ctx, &orderv1.ListOrdersRequest{CustomerId: customerID.String()})
+					return err
+				},
+				status: http.StatusForbidden, code: codes.PermissionDenied, errorCode: models.CodeForbidden,
+			},
+			{
+				name:  "limit out of range",
+				token: admin, method: "GET", path: "/orders?limit=1000",
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.ListOrders(ctx, &orderv1.ListOrdersRequest{Limit: 1000})
+					return err
+				},
+				status: http.StatusBadRequest, code: codes.InvalidArgument, errorCode: models.CodeInvalidParameter,
+			},
+			{
+				name:  "product not found",
+				token: admin, method: "GET", path: "/product/" + missingID,
+				call: func(ctx context.Context) error {
+					_, err := a.grpc.GetProduct(ctx, &orderv1.GetProductRequest{Id: missingID})
+					return err
+				},
+				status: http.StatusNotFound, code: codes.NotFound, errorCode: models.CodeNotFound,
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				resp, httpProblem := a.do(t, tc.token, tc.method, tc.path, tc.body, nil)
+				assert.Equal(t, tc.status, resp.StatusCode)
+				assert.Equal(t, tc.errorCode, httpProblem.Code)
+
+				code, grpcProblem := problemOf(t, tc.call(withToken(tc.token)))
+				assert.Equal(t, tc.code, code)
+				assert.Equal(t, httpProblem.Code, grpcProblem.Code)
+				assert.Equal(t, httpProblem.Detail, grpcProblem.Detail)
+				assert.NotEmpty(t, grpcProblem.RequestID)
+				assert.Len(t, grpcProblem.Errors, len(httpProblem.Errors))
+				for i, field := range grpcProblem.Errors {
+					assert.Equal(t, httpProblem.Errors[i].Field, field.Field)
+					assert.Equal(t, httpProblem.Errors[i].Message, field.Message)
+				}
+			})
+		}
+	})
+}
+
+func TestWatchOrder(t *testing.T) {
+	a := newAPIs(t)
+	admin := token(t, models.RoleAdmin, nil)
+
+	orderID, customerID := uuid.New(), uuid.New()
+	a.store.AddOrder(models.OrderRead{ID: orderID, CustomerID: customerID, Status: models.Completed})
+	a.store.AddEvent(models.OrderEvent{ID: 1, OrderID: orderID, CustomerID: customerID, Status: models.Pending})
+	a.store.AddEvent(models.OrderEvent{ID: 2, OrderID: orderID, CustomerID: customerID, Status: models.Completed})
+
+	ctx, cancel := context.WithCancel(withToken(admin))
+	defer cancel()
+	stream, err := a.grpc.WatchOrder(ctx, &orderv1.WatchOrderRequest{Id: orderID.String(), AfterEventId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(2), event.Id)
+	assert.Equal(t, orderv1.OrderStatus_ORDER_STATUS_COMPLETED, event.Status)
+
+	// The history is only sent once the stream is subscribed. Already sent
+	// and other orders' events are skipped; an event committed after a later
+	// one still gets through.
+	a.broker.Publish(models.OrderEvent{ID: 2, OrderID: orderID, CustomerID: customerID, Status: models.Completed})
+	a.broker.Publish(models.OrderEvent{ID: 3, OrderID: uuid.New(), CustomerID: customerID, Status: models.Failed})
+	a.broker.Publish(models.OrderEvent{ID: 1, OrderID: orderID, CustomerID: customerID, Status: models.Pending})
+	a.broker.Publish(models.OrderEvent{ID: 4, OrderID: orderID, CustomerID: customerID, Status: models.AwaitingShipment})
+
+	event, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(1), event.Id)
+	event, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(4), event.Id)
+	assert.Equal(t, orderv1.OrderStatus_ORDER_STATUS_AWAITING_SHIPMENT, event.Status)
+}
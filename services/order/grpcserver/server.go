@@ -0,0 +1,259 @@
+// Package grpcserver serves the gRPC API of the order service. It applies
+// the same rules as the JSON/HTTP API through the service package, and
+// authenticates and rate limits callers the same way.
+package grpcserver
+
+import (
+	"context"
+	"time"
+
+	"order/auth"
+	"order/models"
+	orderv1 "order/proto/order/v1"
+	"order/ratelimit"
+	"order/repository"
+	"order/service"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// New returns a gRPC server of the order service. Calls are authenticated
+// by authenticator and, if limiter is set, rate limited. Unary calls get
+// timeout to complete unless it is 0; WatchOrder streams stay open until the
+// client leaves.
+func New(orders *service.Orders, products *service.Products, authenticator *auth.Authenticator, limiter *ratelimit.Limiter, timeout time.Duration) *grpc.Server {
+	i := &interceptors{authenticator: authenticator, limiter: limiter, timeout: timeout}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), i.unary),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(), i.stream),
+	)
+	orderv1.RegisterOrderServiceServer(server, &Server{orders: orders, products: products})
+	return server
+}
+
+// Server implements the OrderService
+type Server struct {
+	orderv1.UnimplementedOrderServiceServer
+	orders   *service.Orders
+	products *service.Products
+}
+
+func (s *Server) CreateOrder(ctx context.Context, req *orderv1.CreateOrderRequest) (*orderv1.Order, error) {
+	orderWrite, err := orderWrite(req)
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	orderRead, err := s.orders.Create(ctx, orderWrite)
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	return orderToProto(orderRead), nil
+}
+
+func (s *Server) GetOrder(ctx context.Context, req *orderv1.GetOrderRequest) (*orderv1.Order, error) {
+	id, err := service.ParseID(req.GetId(), "order")
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	orderRead, err := s.orders.Get(ctx, id)
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	return orderToProto(orderRead), nil
+}
+
+func (s *Server) ListOrders(ctx context.Context, req *orderv1.ListOrdersRequest) (*orderv1.ListOrdersResponse, error) {
+	filter := repository.OrderFilter{
+		Status: statusFromProto(req.GetStatus()),
+		Limit:  int(req.GetLimit()),
+		Offset: int(req.GetOffset()),
+	}
+	if req.GetCustomerId() != "" {
+		customerID, err := service.ParseID(req.GetCustomerId(), "customer")
+		if err != nil {
+			return nil, statusOf(ctx, err)
+		}
+		filter.CustomerID = &customerID
+	}
+
+	orderList, err := s.orders.List(ctx, filter)
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	resp := &orderv1.ListOrdersResponse{Orders: make([]*orderv1.Order, len(orderList))}
+	for i, orderRead := range orderList {
+		resp.Orders[i] = orderToProto(orderRead)
+	}
+	return resp, nil
+}
+
+func (s *Server) CreateProduct(ctx context.Context, req *orderv1.CreateProductRequest) (*orderv1.Product, error) {
+	productRead, err := s.products.Create(ctx, models.ProductWrite{Name: req.GetName(), Price: req.GetPrice()})
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	return productToProto(productRead), nil
+}
+
+func (s *Server) GetProduct(ctx context.Context, req *orderv1.GetProductRequest) (*orderv1.Product, error) {
+	id, err := service.ParseID(req.GetId(), "product")
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	productRead, err := s.products.Get(ctx, id)
+	if err != nil {
+		return nil, statusOf(ctx, err)
+	}
+	return productToProto(productRead), nil
+}
+
+// WatchOrder sends the order's history, then its live events until the
+// client leaves or falls too far behind. Events already sent are skipped.
+// When resuming after an event, events recorded shortly before it are sent
+// again, in case they committed after it.
+func (s *Server) WatchOrder(req *orderv1.WatchOrderRequest, stream orderv1.OrderService_WatchOrderServer) error {
+	ctx := stream.Context()
+	id, err := service.ParseID(req.GetId(), "order")
+	if err != nil {
+		return statusOf(ctx, err)
+	}
+	subscription, err := s.orders.Watch(ctx, id, req.GetAfterEventId())
+	if err != nil {
+		return statusOf(ctx, err)
+	}
+	defer subscription.Close()
+
+	// Live events aren't compared with the last ID sent: one whose change
+	// committed late has a lower ID.
+	replayed := make(map[int64]bool, len(subscription.History))
+	for _, event := range subscription.History {
+		if err := stream.Send(eventToProto(event)); err != nil {
+			return err
+		}
+		replayed[event.ID] = true
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-subscription.Live:
+			if !ok {
+				return nil
+			}
+			if replayed[event.ID] {
+				delete(replayed, event.ID)
+				continue
+			}
+			if err := stream.Send(eventToProto(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// errInvalidPayload is the error for malformed IDs, which the HTTP API
+// reports for malformed JSON
+var errInvalidPayload = &service.Error{Code: models.CodeInvalidPayload, Detail: "Invalid request payload"}
+
+// orderWrite reads the order to create from req
+func orderWrite(req *orderv1.CreateOrderRequest) (models.OrderWrite, error) {
+	orderWrite := models.OrderWrite{Amount: req.GetAmount()}
+	var err error
+	if orderWrite.CustomerID, err = payloadID(req.GetCustomerId()); err != nil {
+		return models.OrderWrite{}, err
+	}
+	if orderWrite.ProductID, err = payloadID(req.GetProductId()); err != nil {
+		return models.OrderWrite{}, err
+	}
+	if req.GetShippingAddressId() != "" {
+		addressID, err := payloadID(req.GetShippingAddressId())
+		if err != nil {
+			return models.OrderWrite{}, err
+		}
+		orderWrite.ShippingAddressID = &addressID
+	}
+	return orderWrite, nil
+}
+
+// payloadID parses an ID of a request message. Missing IDs are left zero
+// for validation to report as required.
+func payloadID(raw string) (uuid.UUID, error) {
+	if raw == "" {
+		return uuid.Nil, nil
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, errInvalidPayload
+	}
+	return id, nil
+}
+
+var orderStatuses = map[models.OrderStatus]orderv1.OrderStatus{
+	models.Pending:          orderv1.OrderStatus_ORDER_STATUS_PENDING,
+	models.Completed:        orderv1.OrderStatus_ORDER_STATUS_COMPLETED,
+	models.Failed:           orderv1.OrderStatus_ORDER_STATUS_FAILED,
+	models.AwaitingShipment: orderv1.OrderStatus_ORDER_STATUS_AWAITING_SHIPMENT,
+	models.Shipped:          orderv1.OrderStatus_ORDER_STATUS_SHIPPED,
+	models.Delivered:        orderv1.OrderStatus_ORDER_STATUS_DELIVERED,
+	models.Returned:         orderv1.OrderStatus_ORDER_STATUS_RETURNED,
+}
+
+// statusFromProto returns the order status, or "" for unspecified
+func statusFromProto(status orderv1.OrderStatus) models.OrderStatus {
+	for s, p := range orderStatuses {
+		if p == status {
+			return s
+		}
+	}
+	return ""
+}
+
+func orderToProto(orderRead models.OrderRead) *orderv1.Order {
+	order := &orderv1.Order{
+		Id:         orderRead.ID.String(),
+		CustomerId: orderRead.CustomerID.String(),
+		ProductId:  orderRead.ProductID.String(),
+		Status:     orderStatuses[orderRead.Status],
+		Amount:     orderRead.Amount,
+		CreatedAt:  timestamppb.New(orderRead.CreatedAt),
+		UpdatedAt:  timestamppb.New(orderRead.UpdatedAt),
+	}
+	if orderRead.ShippingAddressID != nil {
+		order.ShippingAddressId = orderRead.ShippingAddressID.String()
+	}
+	if address := orderRead.ShippingAddress; address != nil {
+		order.ShippingAddress = &orderv1.AddressSnapshot{
+			Line1:      address.Line1,
+			Line2:      address.Line2,
+			City:       address.City,
+			Region:     address.Region,
+			PostalCode: address.PostalCode,
+			Country:    address.Country,
+		}
+	}
+	return order
+}
+
+func productToProto(productRead models.ProductRead) *orderv1.Product {
+	return &orderv1.Product{
+		Id:        productRead.ID.String(),
+		Name:      productRead.Name,
+		Price:     productRead.Price,
+		CreatedAt: timestamppb.New(productRead.CreatedAt),
+		UpdatedAt: timestamppb.New(productRead.UpdatedAt),
+	}
+}
+
+func eventToProto(event models.OrderEvent) *orderv1.OrderEvent {
+	return &orderv1.OrderEvent{
+		Id:         event.ID,
+		OrderId:    event.OrderID.String(),
+		CustomerId: event.CustomerID.String(),
+		Status:     orderStatuses[event.Status],
+		CreatedAt:  timestamppb.New(event.CreatedAt),
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"order/models"
+	"order/problem"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const addressColumns = "id, customer_id, type, line1, line2, city, region, postal_code, country, is_default, created_at, updated_at"
+
+func scanAddress(row interface{ Scan(...interface{}) error }, a *models.AddressRead) error {
+	return row.Scan(&a.ID, &a.CustomerID, &a.Type, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country, &a.IsDefault, &a.CreatedAt, &a.UpdatedAt)
+}
+
+// clearDefaultAddress unsets the default flag on the customer's other
+// addresses of the same type, so that at most one stays default.
+func clearDefaultAddress(ctx context.Context, tx *sql.Tx, customerID uuid.UUID, addressType models.AddressType) error {
+	_, err := tx.ExecContext(ctx, "UPDATE customer_addresses SET is_default = FALSE, updated_at = NOW() WHERE customer_id = $1 AND type = $2 AND is_default", customerID, addressType)
+	return err
+}
+
+func customerExists(ctx context.Context, tx *sql.Tx, customerID uuid.UUID) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM customers WHERE id = $1)", customerID).Scan(&exists)
+	return exists, err
+}
+
+func CreateAddressHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		customerID, err := uuid.Parse(vars["customer_id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid customer ID")
+			return
+		}
+
+		var addressWrite models.AddressWrite
+		if err := json.NewDecoder(r.Body).Decode(&addressWrite); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
+			return
+		}
+
+		// Validate the addressWrite struct
+		if err := validate.Struct(addressWrite); err != nil {
+			problem.Validation(w, r, err)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to create address")
+			return
+		}
+		defer tx.Rollback()
+
+		exists, err := customerExists(r.Context(), tx, customerID)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to create address")
+			return
+		}
+		if !exists {
+			problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Customer not found")
+			return
+		}
+
+		if addressWrite.IsDefault {
+			if err := clearDefaultAddress(r.Context(), tx, customerID, addressWrite.Type); err != nil {
+				problem.Storage(w, r, err, "Failed to create address")
+				return
+			}
+		}
+
+		var addressRead models.AddressRead
+		err = scanAddress(tx.QueryRowContext(r.Context(),
+			"INSERT INTO customer_addresses (customer_id, type, line1, line2, city, region, postal_code, country, is_default) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING "+addressColumns,
+			customerID, addressWrite.Type, addressWrite.Line1, addressWrite.Line2, addressWrite.City, addressWrite.Region, addressWrite.PostalCode, addressWrite.Country, addressWrite.IsDefault), &addressRead)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to create address")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			problem.Storage(w, r, err, "Failed to create address")
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(addressRead)
+	}
+}
+
+func ListAddressesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		customerID, err := uuid.Parse(vars["customer_id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid customer ID")
+			return
+		}
+
+		query := "SELECT " + addressColumns + " FROM customer_addresses WHERE customer_id = $1"
+		args := []interface{}{customerID}
+		if addressType := r.URL.Query().Get("type"); addressType != "" {
+			if addressType != string(models.Billing) && addressType != string(models.Shipping) {
+				problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid address type")
+				return
+			}
+			query += " AND type = $2"
+			args = append(args, addressType)
+		}
+		query += " ORDER BY created_at"
+
+		rows, err := db.QueryContext(r.Context(), query, args...)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve addresses")
+			return
+		}
+		defer rows.Close()
+
+		addresses := []models.AddressRead{}
+		for rows.Next() {
+			var addressRead models.AddressRead
+			if err := scanAddress(rows, &addressRead); err != nil {
+				problem.Storage(w, r, err, "Failed to retrieve addresses")
+				return
+			}
+			addresses = append(addresses, addressRead)
+		}
+		if err := rows.Err(); err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve addresses")
+			return
+		}
+
+		json.NewEncoder(w).Encode(addresses)
+	}
+}
+
+func GetAddressHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		customerID, err := uuid.Parse(vars["customer_id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid customer ID")
+			return
+		}
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid address ID")
+			return
+		}
+
+		var addressRead models.AddressRead
+		err = scanAddress(db.QueryRowContext(r.Context(), "SELECT "+addressColumns+" FROM customer_addresses WHERE id = $1 AND customer_id = $2", id, customerID), &addressRead)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Address not found")
+			} else {
+				problem.Storage(w, r, err, "Failed to retrieve address")
+			}
+			return
+		}
+
+		json.NewEncoder(w).Encode(addressRead)
+	}
+}
+
+func UpdateAddressHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		customerID, err := uuid.Parse(vars["customer_id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid customer ID")
+			return
+		}
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid address ID")
+			return
+		}
+
+		var addressWrite models.AddressWrite
+		if err := json.NewDecoder(r.Body).Decode(&addressWrite); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
+			return
+		}
+
+		// Validate the addressWrite struct
+		if err := validate.Struct(addressWrite); err != nil {
+			problem.Validation(w, r, err)
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to update address")
+			return
+		}
+		defer tx.Rollback()
+
+		if addressWrite.IsDefault {
+			if err := clearDefaultAddress(r.Context(), tx, customerID, addressWrite.Type); err != nil {
+				problem.Storage(w, r, err, "Failed to update address")
+				return
+			}
+		}
+
+		var addressRead models.AddressRead
+		err = scanAddress(tx.QueryRowContext(r.Context(),
+			"UPDATE customer_addresses SET type = $3, line1 = $4, line2 = $5, city = $6, region = $7, postal_code = $8, country = $9, is_default = $10, updated_at = NOW() WHERE id = $1 AND customer_id = $2 RETURNING "+addressColumns,
+			id, customerID, addressWrite.Type, addressWrite.Line1, addressWrite.Line2, addressWrite.City, addressWrite.Region, addressWrite.PostalCode, addressWrite.Country, addressWrite.IsDefault), &addressRead)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Address not found")
+			} else {
+				problem.Storage(w, r, err, "Failed to update address")
+			}
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			problem.Storage(w, r, err, "Failed to update address")
+			return
+		}
+
+		json.NewEncoder(w).Encode(addressRead)
+	}
+}
+
+func DeleteAddressHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		customerID, err := uuid.Parse(vars["customer_id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid customer ID")
+			return
+		}
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid address ID")
+			return
+		}
+
+		// Orders keep their own snapshot of the address, so deleting it here
+		// only clears the reference on past orders.
+		result, err := db.ExecContext(r.Context(), "DELETE FROM customer_addresses WHERE id = $1 AND customer_id = $2", id, customerID)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to delete address")
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Address not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"order/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var addressRowColumns = []string{"id", "customer_id", "type", "line1", "line2", "city", "region", "postal_code", "country", "is_default", "created_at", "updated_at"}
+
+func TestCreateAddressHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	handler := CreateAddressHandler(db)
+
+	t.Run("successful default address creation", func(t *testing.T) {
+		customerID := uuid.New()
+		addressID := uuid.New()
+		addressWrite := models.AddressWrite{Type: models.Shipping, Line1: "96 Mowat Ave", City: "Toronto", Region: "ON", PostalCode: "M6K 3M1", Country: "CA", IsDefault: true}
+		now := time.Now()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT EXISTS").
+			WithArgs(customerID).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec("UPDATE customer_addresses SET is_default = FALSE").
+			WithArgs(customerID, models.Shipping).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("INSERT INTO customer_addresses").
+			WithArgs(customerID, addressWrite.Type, addressWrite.Line1, addressWrite.Line2, addressWrite.City, addressWrite.Region, addressWrite.PostalCode, addressWrite.Country, true).
+			WillReturnRows(sqlmock.NewRows(addressRowColumns).
+				AddRow(addressID, customerID, addressWrite.Type, addressWrite.Line1, addressWrite.Line2, addressWrite.City, addressWrite.Region, addressWrite.PostalCode, addressWrite.Country, true, now, now))
+		mock.ExpectCommit()
+
+		body, _ := json.Marshal(addressWrite)
+		req, err := http.NewRequest("POST", "/customer/"+customerID.String()+"/address", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"customer_id": customerID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+
+		var result models.AddressRead
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, addressID, result.ID)
+		assert.Equal(t, customerID, result.CustomerID)
+		assert.Equal(t, models.Shipping, result.Type)
+		assert.True(t, result.IsDefault)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("customer not found", func(t *testing.T) {
+		customerID := uuid.New()
+		addressWrite := models.AddressWrite{Type: models.Billing, Line1: "96 Mowat Ave", City: "Toronto", PostalCode: "M6K 3M1", Country: "CA"}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT EXISTS").
+			WithArgs(customerID).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+		mock.ExpectRollback()
+
+		body, _ := json.Marshal(addressWrite)
+		req, err := http.NewRequest("POST", "/customer/"+customerID.String()+"/address", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"customer_id": customerID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodeNotFound, result.Code)
+		assert.Equal(t, "Customer not found", result.Detail)
+	})
+
+	t.Run("invalid address type", func(t *testing.T) {
+		customerID := uuid.New()
+		req, err := http.NewRequest("POST", "/customer/"+customerID.String()+"/address", bytes.NewBuffer([]byte(`{"type": "office", "line1": "96 Mowat Ave", "city": "Toronto", "postal_code": "M6K 3M1", "country": "CA"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"customer_id": customerID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestListAddressesHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	handler := ListAddressesHandler(db)
+
+	t.Run("filter by type", func(t *testing.T) {
+		customerID := uuid.New()
+		now := time.Now()
+
+		mock.ExpectQuery("SELECT (.+) FROM customer_addresses WHERE customer_id = \\$1 AND type = \\$2 ORDER BY created_at").
+			WithArgs(customerID, "shipping").
+			WillReturnRows(sqlmock.NewRows(addressRowColumns).
+				AddRow(uuid.New(), customerID, models.Shipping, "96 Mowat Ave", "", "Toronto", "ON", "M6K 3M1", "CA", true, now, now).
+				AddRow(uuid.New(), customerID, models.Shipping, "1 Yonge St", "", "Toronto", "ON", "M5E 1E5", "CA", false, now, now))
+
+		req, err := http.NewRequest("GET", "/customer/"+customerID.String()+"/address?type=shipping", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"customer_id": customerID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result []models.AddressRead
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, result, 2)
+		assert.True(t, result[0].IsDefault)
+	})
+}
+
+func TestUpdateAddressHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	handler := UpdateAddressHandler(db)
+
+	t.Run("address not found", func(t *testing.T) {
+		customerID := uuid.New()
+		addressID := uuid.New()
+		addressWrite := models.AddressWrite{Type: models.Billing, Line1: "96 Mowat Ave", City: "Toronto", PostalCode: "M6K 3M1", Country: "CA"}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("UPDATE customer_addresses SET type").
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		body, _ := json.Marshal(addressWrite)
+		req, err := http.NewRequest("PUT", "/customer/"+customerID.String()+"/address/"+addressID.String(), bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"customer_id": customerID.String(), "id": addressID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodeNotFound, result.Code)
+		assert.Equal(t, "Address not found", result.Detail)
+	})
+}
+
+func TestDeleteAddressHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	handler := DeleteAddressHandler(db)
+
+	t.Run("successful deletion", func(t *testing.T) {
+		customerID := uuid.New()
+		addressID := uuid.New()
+
+		mock.ExpectExec("DELETE FROM customer_addresses WHERE id = \\$1 AND customer_id = \\$2").
+			WithArgs(addressID, customerID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		req, err := http.NewRequest("DELETE", "/customer/"+customerID.String()+"/address/"+addressID.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"customer_id": customerID.String(), "id": addressID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+	})
+}
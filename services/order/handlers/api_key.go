@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"order/auth"
+	"order/models"
+	"order/problem"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// CreateAPIKeyHandler issues a new API key. The key itself is only ever
+// returned in this response.
+func CreateAPIKeyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var apiKeyWrite models.APIKeyWrite
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewDecoder(r.Body).Decode(&apiKeyWrite); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
+			return
+		}
+
+		// Validate the apiKeyWrite struct
+		if err := validate.Struct(apiKeyWrite); err != nil {
+			problem.Validation(w, r, err)
+			return
+		}
+
+		apiKeyRead, err := auth.CreateAPIKey(r.Context(), db, apiKeyWrite)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to create API key", "error", err)
+			problem.Storage(w, r, err, "Failed to create API key")
+			return
+		}
+		slog.InfoContext(r.Context(), "API key created", "api_key_id", apiKeyRead.ID, "scopes", apiKeyRead.Scopes)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(apiKeyRead)
+	}
+}
+
+func ListAPIKeysHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		apiKeys, err := auth.ListAPIKeys(r.Context(), db)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve API keys")
+			return
+		}
+
+		json.NewEncoder(w).Encode(apiKeys)
+	}
+}
+
+// RevokeAPIKeyHandler stops a key from authenticating. The key is kept so
+// that it still shows up in the list.
+func RevokeAPIKeyHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid API key ID")
+			return
+		}
+
+		apiKeyRead, err := auth.RevokeAPIKey(r.Context(), db, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "API key not found")
+			} else {
+				problem.Storage(w, r, err, "Failed to revoke API key")
+			}
+			return
+		}
+		slog.InfoContext(r.Context(), "API key revoked", "api_key_id", apiKeyRead.ID)
+
+		json.NewEncoder(w).Encode(apiKeyRead)
+	}
+}
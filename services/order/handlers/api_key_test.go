@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"order/auth"
+	"order/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var apiKeyColumns = []string{"id", "name", "key_prefix", "role", "scopes", "customer_id", "created_at", "revoked_at"}
+
+func TestCreateAPIKeyHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	handler := CreateAPIKeyHandler(db)
+
+	t.Run("returns the key once", func(t *testing.T) {
+		keyID := uuid.New()
+		scopesJSON := []byte(`["orders:read","orders:write"]`)
+
+		mock.ExpectQuery("INSERT INTO api_keys").
+			WithArgs("storefront", sqlmock.AnyArg(), sqlmock.AnyArg(), "service", scopesJSON, nil).
+			WillReturnRows(sqlmock.NewRows(apiKeyColumns).
+				AddRow(keyID, "storefront", "osk_abcdefgh", "service", scopesJSON, nil, time.Now(), nil))
+
+		req, err := http.NewRequest("POST", "/api-keys", bytes.NewBuffer([]byte(`{"name": "storefront", "role": "service", "scopes": ["orders:read", "orders:write"]}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+
+		var result models.APIKeyRead
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, keyID, result.ID)
+		assert.True(t, strings.HasPrefix(result.Key, auth.APIKeyPrefix))
+		assert.Equal(t, models.Scopes{"orders:read", "orders:write"}, result.Scopes)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	invalid := map[string]string{
+		"unknown scope":             `{"name": "storefront", "role": "service", "scopes": ["everything"]}`,
+		"unknown role":              `{"name": "storefront", "role": "root"}`,
+		"customer without customer": `{"name": "storefront", "role": "customer"}`,
+	}
+	for name, body := range invalid {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/api-keys", bytes.NewBuffer([]byte(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+		})
+	}
+}
+
+func TestRevokeAPIKeyHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api-keys/{id}", RevokeAPIKeyHandler(db)).Methods(http.MethodDelete)
+
+	t.Run("revokes the key", func(t *testing.T) {
+		keyID := uuid.New()
+		now := time.Now()
+
+		mock.ExpectQuery("UPDATE api_keys SET revoked_at = NOW\\(\\) WHERE id = \\$1 AND revoked_at IS NULL").
+			WithArgs(keyID).
+			WillReturnRows(sqlmock.NewRows(apiKeyColumns).
+				AddRow(keyID, "storefront", "osk_abcdefgh", "service", []byte(`["orders:read"]`), nil, now, now))
+
+		req, err := http.NewRequest("DELETE", "/api-keys/"+keyID.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result models.APIKeyRead
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Empty(t, result.Key)
+		assert.NotNil(t, result.RevokedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("already revoked", func(t *testing.T) {
+		keyID := uuid.New()
+
+		mock.ExpectQuery("UPDATE api_keys").
+			WithArgs(keyID).
+			WillReturnRows(sqlmock.NewRows(apiKeyColumns))
+
+		req, err := http.NewRequest("DELETE", "/api-keys/"+keyID.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"order/models"
+	"order/problem"
+	"order/service"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxBulkOrders is the most rows a single bulk import may contain
+	maxBulkOrders = 1000
+	// maxBulkUploadSize caps the size of bulk import bodies, whatever their
+	// format
+	maxBulkUploadSize = 10 << 20
+)
+
+var (
+	errTooManyOrders = fmt.Errorf("At most %d orders can be imported at once", maxBulkOrders)
+	errBodyTooLarge  = fmt.Errorf("At most %d MB can be imported at once", maxBulkUploadSize>>20)
+)
+
+// readError is the error for a body that couldn't be read, as opposed to
+// one that was malformed
+func readError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errBodyTooLarge
+	}
+	return errors.New("Unable to read request body")
+}
+
+// bulkRow is a parsed row awaiting creation
+type bulkRow struct {
+	row    int
+	order  models.OrderWrite
+	err    string
+	code   models.ErrorCode
+	fields []models.FieldError
+}
+
+// fail marks the row as not imported, for the reason code
+func (row *bulkRow) fail(code models.ErrorCode, detail string) {
+	row.code = code
+	row.err = detail
+}
+
+// failWith marks the row as not imported for the error of
+// service.Orders.CreateMany. Validation failures are summed up in one line.
+func (row *bulkRow) failWith(ctx context.Context, err error) {
+	var serviceErr *service.Error
+	var storageErr *service.StorageError
+	switch {
+	case errors.As(err, &serviceErr):
+		row.fail(serviceErr.Code, serviceErr.Detail)
+		if len(serviceErr.Fields) > 0 {
+			row.err = problem.FieldSummary(serviceErr.Fields)
+			row.fields = serviceErr.Fields
+		}
+	case errors.As(err, &storageErr):
+		code, detail := problem.StorageFailure(ctx, storageErr.Err, storageErr.Detail)
+		if code == "" {
+			code, detail = models.CodeInternal, storageErr.Detail
+		}
+		row.fail(code, detail)
+	default:
+		row.fail(models.CodeInternal, err.Error())
+	}
+}
+
+func BulkCreateOrdersHandler(orders *service.Orders) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		r.Body = http.MaxBytesReader(w, r.Body, maxBulkUploadSize)
+
+		rows, err := parseBulkOrders(r)
+		if errors.Is(err, errTooManyOrders) || errors.Is(err, errBodyTooLarge) {
+			problem.Write(w, r, http.StatusRequestEntityTooLarge, models.CodePayloadTooLarge, err.Error())
+			return
+		}
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, err.Error())
+			return
+		}
+		if len(rows) == 0 {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "No orders to import")
+			return
+		}
+
+		// Rows that parsed are created under the same rules as single orders
+		var parsed []*bulkRow
+		var orderWrites []models.OrderWrite
+		for _, row := range rows {
+			if row.err == "" {
+				parsed = append(parsed, row)
+				orderWrites = append(orderWrites, row.order)
+			}
+		}
+		created := make(map[int]uuid.UUID)
+		for i, result := range orders.CreateMany(r.Context(), orderWrites) {
+			if result.Err != nil {
+				parsed[i].failWith(r.Context(), result.Err)
+				continue
+			}
+			created[parsed[i].row] = result.Order.ID
+		}
+
+		report := models.BulkOrderReport{Results: make([]models.BulkOrderResult, 0, len(rows))}
+		for _, row := range rows {
+			result := models.BulkOrderResult{Row: row.row, Error: row.err, Code: row.code, Errors: row.fields}
+			if id, ok := created[row.row]; ok {
+				result.ID = &id
+				report.Created++
+			} else {
+				report.Failed++
+			}
+			report.Results = append(report.Results, result)
+		}
+		slog.InfoContext(r.Context(), "Bulk order import finished", "created", report.Created, "failed", report.Failed)
+
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// parseBulkOrders reads the orders from a JSON array body, a text/csv body
+// or a CSV file uploaded as the "file" field of a multipart form. It fails
+// with errTooManyOrders as soon as there are more than maxBulkOrders.
+func parseBulkOrders(r *http.Request) ([]*bulkRow, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return parseBulkOrdersCSV(r.Body)
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(maxBulkUploadSize); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, errBodyTooLarge
+			}
+			return nil, errors.New("Invalid multipart upload")
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, errors.New("Missing CSV file")
+		}
+		defer file.Close()
+		return parseBulkOrdersCSV(file)
+	default:
+		var orders []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&orders); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, errBodyTooLarge
+			}
+			return nil, errors.New("Invalid request payload")
+		}
+		if len(orders) > maxBulkOrders {
+			return nil, errTooManyOrders
+		}
+		rows := make([]*bulkRow, len(orders))
+		for i, raw := range orders {
+			rows[i] = &bulkRow{row: i + 1}
+			if err := json.Unmarshal(raw, &rows[i].order); err != nil {
+				rows[i].fail(models.CodeInvalidPayload, "Invalid order payload")
+			}
+		}
+		return rows, nil
+	}
+}
+
+// parseBulkOrdersCSV reads orders from CSV with a header row naming the
+// columns customer_id, product_id, amount and optionally shipping_address_id,
+// in any order.
+func parseBulkOrdersCSV(body io.Reader) ([]*bulkRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	var parseErr *csv.ParseError
+	if err != nil && err != io.EOF && !errors.As(err, &parseErr) {
+		return nil, readError(err)
+	}
+	if err != nil {
+		return nil, errors.New("Invalid CSV: missing header row")
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"customer_id", "product_id", "amount"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("Invalid CSV: missing %s column", required)
+		}
+	}
+
+	var rows []*bulkRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		// Malformed rows are reported and skipped, but a failed read fails
+		// every read after it
+		if err != nil && !errors.As(err, &parseErr) {
+			return nil, readError(err)
+		}
+		if len(rows) == maxBulkOrders {
+			return nil, errTooManyOrders
+		}
+		row := &bulkRow{row: len(rows) + 1}
+		rows = append(rows, row)
+		if err != nil {
+			row.fail(models.CodeInvalidPayload, "Invalid CSV row: "+err.Error())
+			continue
+		}
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		if value := field("customer_id"); value != "" {
+			if row.order.CustomerID, err = uuid.Parse(value); err != nil {
+				row.fail(models.CodeInvalidPayload, "Invalid customer_id")
+				continue
+			}
+		}
+		if value := field("product_id"); value != "" {
+			if row.order.ProductID, err = uuid.Parse(value); err != nil {
+				row.fail(models.CodeInvalidPayload, "Invalid product_id")
+				continue
+			}
+		}
+		if value := field("amount"); value != "" {
+			if row.order.Amount, err = strconv.ParseFloat(value, 64); err != nil {
+				row.fail(models.CodeInvalidPayload, "Invalid amount")
+				continue
+			}
+		}
+		if value := field("shipping_address_id"); value != "" {
+			addressID, err := uuid.Parse(value)
+			if err != nil {
+				row.fail(models.CodeInvalidPayload, "Invalid shipping_address_id")
+				continue
+			}
+			row.order.ShippingAddressID = &addressID
+		}
+	}
+	return rows, nil
+}
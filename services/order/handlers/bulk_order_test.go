@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"order/models"
+	"order/service"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingReader fails every read, as a connection that timed out does
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("i/o timeout")
+}
+
+func TestBulkCreateOrdersHandler(t *testing.T) {
+	store, customerID, productID := newCatalogue()
+	repos := store.Repositories()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+
+	handler := BulkCreateOrdersHandler(service.NewOrders(repos.Orders, repos.Customers, nil, rdb, nil))
+
+	t.Run("JSON array with an invalid row", func(t *testing.T) {
+		body := fmt.Sprintf(`[{"customer_id": "%s", "product_id": "%s", "amount": 25}, {"customer_id": "%s"}]`, customerID, productID, customerID)
+		req, err := http.NewRequest("POST", "/orders/bulk", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var report models.BulkOrderReport
+		err = json.NewDecoder(rr.Body).Decode(&report)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 1, report.Failed)
+		assert.Equal(t, 1, report.Results[0].Row)
+		if assert.NotNil(t, report.Results[0].ID) {
+			orderRead, err := repos.Orders.Get(context.Background(), *report.Results[0].ID)
+			assert.NoError(t, err)
+			assert.Equal(t, 25.0, orderRead.Amount)
+		}
+		assert.Equal(t, 2, report.Results[1].Row)
+		assert.Nil(t, report.Results[1].ID)
+		assert.Equal(t, models.CodeValidationFailed, report.Results[1].Code)
+		assert.Equal(t, "product_id is required; amount is required", report.Results[1].Error)
+		assert.Equal(t, []models.FieldError{
+			{Field: "product_id", Rule: "required", Message: "is required"},
+			{Field: "amount", Rule: "required", Message: "is required"},
+		}, report.Results[1].Errors)
+	})
+
+	t.Run("CSV upload falls back to single rows when the batch fails", func(t *testing.T) {
+		missingProductID := uuid.New()
+
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, _ := form.CreateFormFile("file", "orders.csv")
+		fmt.Fprintf(file, "amount,customer_id,product_id\n10.5,%s,%s\n3,%s,%s\nabc,%s,%s\n", customerID, productID, customerID, missingProductID, customerID, productID)
+		form.Close()
+
+		req, err := http.NewRequest("POST", "/orders/bulk", &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", form.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var report models.BulkOrderReport
+		err = json.NewDecoder(rr.Body).Decode(&report)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 2, report.Failed)
+		assert.NotNil(t, report.Results[0].ID)
+		assert.Equal(t, models.CodeInternal, report.Results[1].Code)
+		assert.Equal(t, "Failed to create order", report.Results[1].Error)
+		assert.Equal(t, models.CodeInvalidPayload, report.Results[2].Code)
+		assert.Equal(t, "Invalid amount", report.Results[2].Error)
+	})
+
+	t.Run("CSV missing a required column", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/orders/bulk", strings.NewReader("customer_id,amount\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "text/csv")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodeInvalidPayload, result.Code)
+		assert.Equal(t, "Invalid CSV: missing product_id column", result.Detail)
+	})
+
+	t.Run("CSV body that can't be read", func(t *testing.T) {
+		body := io.MultiReader(strings.NewReader("customer_id,product_id,amount\n\"unterminated\n"), failingReader{})
+		req, err := http.NewRequest("POST", "/orders/bulk", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "text/csv")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodeInvalidPayload, result.Code)
+		assert.Equal(t, "Unable to read request body", result.Detail)
+	})
+
+	t.Run("CSV with too many rows", func(t *testing.T) {
+		body := "customer_id,product_id,amount\n" + strings.Repeat(fmt.Sprintf("%s,%s,1\n", customerID, productID), maxBulkOrders+1)
+		req, err := http.NewRequest("POST", "/orders/bulk", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "text/csv")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodePayloadTooLarge, result.Code)
+		assert.Equal(t, "At most 1000 orders can be imported at once", result.Detail)
+	})
+
+	t.Run("JSON body too large", func(t *testing.T) {
+		body := "[" + strings.Repeat(" ", maxBulkUploadSize) + "]"
+		req, err := http.NewRequest("POST", "/orders/bulk", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodePayloadTooLarge, result.Code)
+		assert.Equal(t, "At most 10 MB can be imported at once", result.Detail)
+	})
+}
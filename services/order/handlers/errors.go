@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"order/models"
+	"order/problem"
+	"order/service"
+	"strconv"
+)
+
+// errorStatuses are the HTTP statuses of the errors the service package
+// reports
+var errorStatuses = map[models.ErrorCode]int{
+	models.CodeInvalidPayload:         http.StatusBadRequest,
+	models.CodeValidationFailed:       http.StatusBadRequest,
+	models.CodeInvalidParameter:       http.StatusBadRequest,
+	models.CodeInvalidShippingAddress: http.StatusBadRequest,
+	models.CodeForbidden:              http.StatusForbidden,
+	models.CodeNotFound:               http.StatusNotFound,
+	models.CodeQuotaExceeded:          http.StatusTooManyRequests,
+}
+
+// writeError writes the problem for an error returned by the service
+// package
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var serviceErr *service.Error
+	var storageErr *service.StorageError
+	switch {
+	case errors.As(err, &serviceErr):
+		status, ok := errorStatuses[serviceErr.Code]
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		if serviceErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(serviceErr.RetryAfter.Seconds())+1))
+		}
+		p := problem.New(r, status, serviceErr.Code, serviceErr.Detail)
+		p.Errors = serviceErr.Fields
+		problem.WriteProblem(w, p)
+	case errors.As(err, &storageErr):
+		problem.Storage(w, r, storageErr.Err, storageErr.Detail)
+	default:
+		problem.Storage(w, r, err, "The request could not be completed")
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"order/models"
+	"order/problem"
+	"order/service"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// keepAliveInterval is how often an idle stream sends a comment so that
+// proxies don't time the connection out.
+var keepAliveInterval = 15 * time.Second
+
+// lastEventID returns the position a client wants to resume from, taken from
+// the Last-Event-ID header browsers send on reconnect, or the last_event_id
+// query parameter for clients that can't set headers. ok is false if neither
+// was given.
+func lastEventID(r *http.Request) (id int64, ok bool, err error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+	id, err = strconv.ParseInt(value, 10, 64)
+	return id, true, err
+}
+
+func OrderEventsHandler(orders *service.Orders) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		orderID, err := service.ParseID(vars["id"], "order")
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		afterID, _, err := lastEventID(r)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid Last-Event-ID")
+			return
+		}
+
+		// A single order's history is short, so a fresh stream replays all
+		// of it to give the client the transitions so far.
+		subscription, err := orders.Watch(r.Context(), orderID, afterID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		defer subscription.Close()
+
+		streamEvents(w, r, subscription.History, subscription.Live)
+	}
+}
+
+func CustomerEventsHandler(orders *service.Orders) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		customerID, err := service.ParseID(vars["customer_id"], "customer")
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		afterID, resume, err := lastEventID(r)
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid Last-Event-ID")
+			return
+		}
+
+		// Unlike a single order, a customer's full history is unbounded, so
+		// it is only replayed when resuming.
+		subscription, err := orders.WatchCustomer(r.Context(), customerID, afterID, resume)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		defer subscription.Close()
+
+		streamEvents(w, r, subscription.History, subscription.Live)
+	}
+}
+
+// streamEvents writes the replayed history followed by live events as
+// Server-Sent Events until the client disconnects or the subscription is
+// dropped, in which case the client reconnects with its Last-Event-ID. The
+// history replayed then includes events recorded shortly before that ID, in
+// case they committed after it, so clients may get an event twice and should
+// skip those whose ID they have seen.
+func streamEvents(w http.ResponseWriter, r *http.Request, history []models.OrderEvent, live <-chan models.OrderEvent) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		problem.Write(w, r, http.StatusInternalServerError, models.CodeInternal, "Streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	// Events published while the history was read come in both, and are
+	// skipped by ID. Live events aren't compared with the last ID sent: one
+	// whose change committed late has a lower ID.
+	replayed := make(map[int64]bool, len(history))
+	for _, event := range history {
+		writeEvent(w, event)
+		replayed[event.ID] = true
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-live:
+			if !ok {
+				return
+			}
+			if replayed[event.ID] {
+				delete(replayed, event.ID)
+				continue
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event models.OrderEvent) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "id: %d\nevent: order.status\ndata: %s\n\n", event.ID, data)
+}
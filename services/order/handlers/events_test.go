@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"order/events"
+	"order/models"
+	"order/repository"
+	"order/service"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var eventRowColumns = []string{"id", "order_id", "customer_id", "status", "created_at"}
+
+// readEvent reads the next event from an SSE stream, skipping comments and
+// the retry directive.
+func readEvent(t *testing.T, reader *bufio.Reader) (string, models.OrderEvent) {
+	var id string
+	var event models.OrderEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && id != "":
+			return id, event
+		}
+	}
+}
+
+func TestOrderEventsHandler(t *testing.T) {
+	store := repository.NewMemory()
+	repos := store.Repositories()
+	broker := events.NewBroker()
+	router := mux.NewRouter()
+	router.HandleFunc("/order/{id}/events", OrderEventsHandler(service.NewOrders(repos.Orders, repos.Customers, broker, nil, nil)))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("resumes from Last-Event-ID then streams live events", func(t *testing.T) {
+		orderID := uuid.New()
+		customerID := uuid.New()
+		now := time.Now()
+
+		store.AddOrder(models.OrderRead{ID: orderID, CustomerID: customerID, Status: models.Completed, CreatedAt: now})
+		store.AddEvent(models.OrderEvent{ID: 5, OrderID: orderID, CustomerID: customerID, Status: models.Pending, CreatedAt: now})
+		store.AddEvent(models.OrderEvent{ID: 7, OrderID: orderID, CustomerID: customerID, Status: models.Completed, CreatedAt: now})
+
+		req, err := http.NewRequest("GET", server.URL+"/order/"+orderID.String()+"/events", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Last-Event-ID", "5")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		reader := bufio.NewReader(resp.Body)
+		id, event := readEvent(t, reader)
+		assert.Equal(t, "7", id)
+		assert.Equal(t, models.Completed, event.Status)
+
+		// Already replayed and other orders' events are skipped; an event
+		// committed after a later one still gets through
+		broker.Publish(models.OrderEvent{ID: 7, OrderID: orderID, CustomerID: customerID, Status: models.Completed})
+		broker.Publish(models.OrderEvent{ID: 8, OrderID: uuid.New(), CustomerID: customerID, Status: models.Failed})
+		broker.Publish(models.OrderEvent{ID: 6, OrderID: orderID, CustomerID: customerID, Status: models.Completed})
+		broker.Publish(models.OrderEvent{ID: 9, OrderID: orderID, CustomerID: customerID, Status: models.AwaitingShipment})
+
+		id, _ = readEvent(t, reader)
+		assert.Equal(t, "6", id)
+		id, event = readEvent(t, reader)
+		assert.Equal(t, "9", id)
+		assert.Equal(t, models.AwaitingShipment, event.Status)
+	})
+
+	t.Run("order not found", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/order/" + uuid.New().String() + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		var result models.Problem
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodeNotFound, result.Code)
+		assert.Equal(t, "Order not found", result.Detail)
+	})
+
+	t.Run("invalid Last-Event-ID", func(t *testing.T) {
+		req, err := http.NewRequest("GET", server.URL+"/order/"+uuid.New().String()+"/events", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Last-Event-ID", "abc")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestCustomerEventsHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	broker := events.NewBroker()
+	router := mux.NewRouter()
+	repos := repository.NewPostgres(db)
+	router.HandleFunc("/customer/{customer_id}/events", CustomerEventsHandler(service.NewOrders(repos.Orders, repos.Customers, broker, nil, nil)))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("streams events for all of the customer's orders", func(t *testing.T) {
+		customerID := uuid.New()
+		now := time.Now()
+
+		mock.ExpectQuery("SELECT (.+) FROM order_status_history WHERE customer_id = \\$1 AND \\(id > \\$2").
+			WithArgs(customerID, 0, events.CommitWindow.Seconds()).
+			WillReturnRows(sqlmock.NewRows(eventRowColumns).
+				AddRow(1, uuid.New(), customerID, models.Failed, now))
+
+		resp, err := http.Get(server.URL + "/customer/" + customerID.String() + "/events?last_event_id=0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		reader := bufio.NewReader(resp.Body)
+		id, _ := readEvent(t, reader)
+		assert.Equal(t, "1", id)
+
+		broker.Publish(models.OrderEvent{ID: 2, OrderID: uuid.New(), CustomerID: uuid.New(), Status: models.Completed})
+		broker.Publish(models.OrderEvent{ID: 3, OrderID: uuid.New(), CustomerID: customerID, Status: models.Completed})
+
+		id, event := readEvent(t, reader)
+		assert.Equal(t, "3", id)
+		assert.Equal(t, customerID, event.CustomerID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("history is only replayed when resuming", func(t *testing.T) {
+		customerID := uuid.New()
+
+		resp, err := http.Get(server.URL + "/customer/" + customerID.String() + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		reader := bufio.NewReader(resp.Body)
+		broker.Publish(models.OrderEvent{ID: 4, OrderID: uuid.New(), CustomerID: customerID, Status: models.Completed})
+
+		id, _ := readEvent(t, reader)
+		assert.Equal(t, "4", id)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("invalid customer ID", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/customer/not-a-uuid/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
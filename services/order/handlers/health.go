@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"order/health"
+	"order/models"
+)
+
+// HealthzHandler reports that the process is up and serving requests. It
+// deliberately checks no dependencies, so an outage of Postgres or Redis
+// doesn't get the service restarted.
+func HealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.HealthReport{Status: models.HealthOK})
+	}
+}
+
+// ReadyzHandler reports whether every dependency the service needs is
+// usable, with the result of each check.
+func ReadyzHandler(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Run(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != models.HealthOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
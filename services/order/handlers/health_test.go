@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"order/health"
+	"order/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthzHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/healthz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	HealthzHandler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, rr.Body.String())
+}
+
+func TestReadyzHandler(t *testing.T) {
+	var redisErr error
+	checker := health.NewChecker(time.Second)
+	checker.Add("database", func(ctx context.Context) (interface{}, error) { return nil, nil })
+	checker.Add("redis", func(ctx context.Context) (interface{}, error) { return nil, redisErr })
+	handler := ReadyzHandler(checker)
+
+	t.Run("ready", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/readyz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var report models.HealthReport
+		err = json.NewDecoder(rr.Body).Decode(&report)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.HealthOK, report.Status)
+		assert.Equal(t, models.HealthOK, report.Checks["database"].Status)
+		assert.Equal(t, models.HealthOK, report.Checks["redis"].Status)
+	})
+
+	t.Run("dependency down", func(t *testing.T) {
+		redisErr = errors.New("dial tcp: connection refused")
+
+		req, err := http.NewRequest("GET", "/readyz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+		var report models.HealthReport
+		err = json.NewDecoder(rr.Body).Decode(&report)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.HealthUnavailable, report.Status)
+		assert.Equal(t, models.HealthOK, report.Checks["database"].Status)
+		assert.Equal(t, models.HealthUnavailable, report.Checks["redis"].Status)
+		assert.Equal(t, "dial tcp: connection refused", report.Checks["redis"].Error)
+	})
+}
@@ -1,96 +1,115 @@
 package handlers
 
 import (
-	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"order/auth"
 	"order/models"
+	"order/problem"
+	"order/repository"
+	"order/service"
+	"strconv"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
-var ctx = context.Background()
+// mayReadOrder reports whether the request's principal may see the order.
+// Only customer principals are limited, to their own orders.
+func mayReadOrder(r *http.Request, db *sql.DB, orderID uuid.UUID) (bool, error) {
+	customerID := auth.FromContext(r.Context()).CustomerScope()
+	if customerID == nil {
+		return true, nil
+	}
+	var ok bool
+	err := db.QueryRowContext(r.Context(), "SELECT EXISTS(SELECT 1 FROM orders WHERE id = $1 AND customer_id = $2)", orderID, *customerID).Scan(&ok)
+	return ok, err
+}
 
-func CreateOrderHandler(db *sql.DB, rdb *redis.Client) http.HandlerFunc {
+func CreateOrderHandler(orders *service.Orders) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var orderWrite models.OrderWrite
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewDecoder(r.Body).Decode(&orderWrite); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Invalid request payload"})
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
 			return
 		}
 
-		// Validate the orderWrite struct
-		if err := validate.Struct(orderWrite); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrorResponse{Error: err.Error()})
-			return
-		}
-
-		var orderRead models.OrderRead
-		err := db.QueryRow(
-			"INSERT INTO orders (customer_id, product_id, status, amount, created_at, updated_at) VALUES ($1, $2, $3, $4, NOW(), NOW()) RETURNING id, customer_id, product_id, status, amount, created_at, updated_at",
-			orderWrite.CustomerID, orderWrite.ProductID, models.Pending, orderWrite.Amount).Scan(
-			&orderRead.ID, &orderRead.CustomerID, &orderRead.ProductID, &orderRead.Status, &orderRead.Amount, &orderRead.CreatedAt, &orderRead.UpdatedAt)
+		orderRead, err := orders.Create(r.Context(), orderWrite)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Failed to create order: " + err.Error()})
+			writeError(w, r, err)
 			return
 		}
 
-		go sendOrderForPayment(orderRead, rdb)
-
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(orderRead)
 	}
 }
 
-func sendOrderForPayment(orderRead models.OrderRead, rdb *redis.Client) {
-	// Send payment request to payment service
-	paymentRequest := map[string]interface{}{
-		"order_id":    orderRead.ID,
-		"amount":      orderRead.Amount,
-		"customer_id": orderRead.CustomerID,
-		"product_id":  orderRead.ProductID,
-		"created_at":  orderRead.CreatedAt,
-		"updated_at":  orderRead.UpdatedAt,
-	}
-	paymentRequestJSON, _ := json.Marshal(paymentRequest)
-	rdb.Publish(ctx, "payment_requests", paymentRequestJSON)
-}
-
-func GetOrderHandler(db *sql.DB) http.HandlerFunc {
+func GetOrderHandler(orders *service.Orders) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		w.Header().Set("Content-Type", "application/json")
-		id := vars["id"]
-		_, err := uuid.Parse(id)
+		id, err := service.ParseID(vars["id"], "order")
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Invalid order ID"})
+			writeError(w, r, err)
 			return
 		}
 
-		var orderRead models.OrderRead
-		err = db.QueryRow(
-			"SELECT id, customer_id, product_id, status, amount, created_at, updated_at FROM orders WHERE id = $1",
-			id).Scan(
-			&orderRead.ID, &orderRead.CustomerID, &orderRead.ProductID, &orderRead.Status, &orderRead.Amount, &orderRead.CreatedAt, &orderRead.UpdatedAt)
+		orderRead, err := orders.Get(r.Context(), id)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				w.WriteHeader(http.StatusNotFound)
-				json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Order not found"})
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Failed to retrieve order"})
-			}
+			writeError(w, r, err)
 			return
 		}
 
 		json.NewEncoder(w).Encode(orderRead)
 	}
 }
+
+// ListOrdersHandler lists orders newest first, optionally filtered by
+// customer_id and status and paged with limit and offset. Customer
+// principals only ever see their own orders.
+func ListOrdersHandler(orders *service.Orders) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		query := r.URL.Query()
+
+		var filter repository.OrderFilter
+		if raw := query.Get("customer_id"); raw != "" {
+			customerID, err := service.ParseID(raw, "customer")
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			filter.CustomerID = &customerID
+		}
+		filter.Status = models.OrderStatus(query.Get("status"))
+
+		if raw := query.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, fmt.Sprintf("limit must be between 1 and %d", service.MaxOrderListLimit))
+				return
+			}
+			filter.Limit = n
+		}
+		if raw := query.Get("offset"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "offset must not be negative")
+				return
+			}
+			filter.Offset = n
+		}
+
+		orderList, err := orders.List(r.Context(), filter)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(orderList)
+	}
+}
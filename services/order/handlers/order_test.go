@@ -2,53 +2,103 @@ package handlers
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"order/auth"
 	"order/models"
+	"order/problem"
+	"order/ratelimit"
+	"order/repository"
+	"order/service"
 	"testing"
 	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
 
+// failingOrders is an order repository whose writes fail
+type failingOrders struct {
+	repository.OrderRepository
+	err error
+}
+
+func (r failingOrders) Create(ctx context.Context, order repository.NewOrder) (models.OrderRead, error) {
+	return models.OrderRead{}, r.err
+}
+
+// blockingOrders is an order repository whose reads wait until the request
+// is done, like a hung database
+type blockingOrders struct {
+	repository.OrderRepository
+}
+
+func (blockingOrders) Get(ctx context.Context, id uuid.UUID) (models.OrderRead, error) {
+	<-ctx.Done()
+	return models.OrderRead{}, ctx.Err()
+}
+
+// newCatalogue returns an in-memory store holding a customer and a product
+func newCatalogue() (store *repository.Memory, customerID, productID uuid.UUID) {
+	store = repository.NewMemory()
+	customerID, productID = uuid.New(), uuid.New()
+	store.AddCustomer(customerID)
+	store.AddProduct(models.ProductRead{ID: productID, Name: "Sample Product", Price: 99.99})
+	return store, customerID, productID
+}
+
 func TestCreateOrderHandler(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
+	store, customerID, productID := newCatalogue()
+	repos := store.Repositories()
 
 	rdb := redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
 	})
 
-	handler := CreateOrderHandler(db, rdb)
+	handler := CreateOrderHandler(service.NewOrders(repos.Orders, repos.Customers, nil, rdb, nil))
 
 	t.Run("successful order creation", func(t *testing.T) {
-		customerID := uuid.New()
-		productID := uuid.New()
-		orderID := uuid.New()
 		orderWrite := models.OrderWrite{CustomerID: customerID, ProductID: productID, Amount: 100.0}
-		orderRead := models.OrderRead{
-			ID:         orderID,
-			CustomerID: customerID,
-			ProductID:  productID,
-			Status:     models.Pending,
-			Amount:     100.0,
-			CreatedAt:  time.Now(),
-			UpdatedAt:  time.Now(),
+
+		body, _ := json.Marshal(orderWrite)
+		req, err := http.NewRequest("POST", "/order", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
 		}
 
-		mock.ExpectQuery("INSERT INTO orders").
-			WithArgs(orderWrite.CustomerID, orderWrite.ProductID, models.Pending, orderWrite.Amount).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "product_id", "status", "amount", "created_at", "updated_at"}).
-				AddRow(orderRead.ID, orderRead.CustomerID, orderRead.ProductID, orderRead.Status, orderRead.Amount, orderRead.CreatedAt, orderRead.UpdatedAt))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+
+		var result models.OrderRead
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, customerID, result.CustomerID)
+		assert.Equal(t, productID, result.ProductID)
+		assert.Equal(t, models.Pending, result.Status)
+		assert.Equal(t, orderWrite.Amount, result.Amount)
+		assert.WithinDuration(t, time.Now(), result.CreatedAt, time.Second)
+
+		stored, err := repos.Orders.Get(context.Background(), result.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, result.Amount, stored.Amount)
+	})
+
+	t.Run("order with shipping address", func(t *testing.T) {
+		addressID := uuid.New()
+		snapshot := models.AddressSnapshot{Line1: "96 Mowat Ave", City: "Toronto", Region: "ON", PostalCode: "M6K 3M1", Country: "CA"}
+		store.AddAddress(addressID, customerID, models.Shipping, snapshot)
+		orderWrite := models.OrderWrite{CustomerID: customerID, ProductID: productID, Amount: 100.0, ShippingAddressID: &addressID}
 
 		body, _ := json.Marshal(orderWrite)
 		req, err := http.NewRequest("POST", "/order", bytes.NewBuffer(body))
@@ -67,12 +117,74 @@ func TestCreateOrderHandler(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		assert.Equal(t, orderRead.CustomerID, result.CustomerID)
-		assert.Equal(t, orderRead.ProductID, result.ProductID)
-		assert.Equal(t, orderRead.Status, result.Status)
-		assert.Equal(t, orderRead.Amount, result.Amount)
-		assert.WithinDuration(t, orderRead.CreatedAt, result.CreatedAt, time.Second)
-		assert.WithinDuration(t, orderRead.UpdatedAt, result.UpdatedAt, time.Second)
+		assert.Equal(t, &addressID, result.ShippingAddressID)
+		assert.Equal(t, &snapshot, result.ShippingAddress)
+	})
+
+	t.Run("shipping address of another customer", func(t *testing.T) {
+		addressID := uuid.New()
+		store.AddAddress(addressID, uuid.New(), models.Shipping, models.AddressSnapshot{Line1: "1 Elsewhere St"})
+		orderWrite := models.OrderWrite{CustomerID: customerID, ProductID: productID, Amount: 100.0, ShippingAddressID: &addressID}
+
+		body, _ := json.Marshal(orderWrite)
+		req, err := http.NewRequest("POST", "/order", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodeInvalidShippingAddress, result.Code)
+		assert.Equal(t, "Invalid shipping address", result.Detail)
+	})
+
+	t.Run("customer ordering for another customer", func(t *testing.T) {
+		otherID := uuid.New()
+
+		req, err := http.NewRequest("POST", "/order", bytes.NewBuffer([]byte(`{"customer_id": "`+customerID.String()+`", "product_id": "`+productID.String()+`", "amount": 10}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Role: models.RoleCustomer, CustomerID: &otherID}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("daily order quota exceeded", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when starting miniredis", err)
+		}
+		defer mr.Close()
+		quotaRedis := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer quotaRedis.Close()
+
+		quota := ratelimit.NewOrderQuota(quotaRedis, 1)
+		_, err = quota.Reserve(context.Background(), customerID, 1)
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest("POST", "/order", bytes.NewBuffer([]byte(`{"customer_id": "`+customerID.String()+`", "product_id": "`+productID.String()+`", "amount": 10}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		CreateOrderHandler(service.NewOrders(repos.Orders, repos.Customers, nil, rdb, quota)).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.NotEmpty(t, rr.Header().Get("Retry-After"))
 	})
 
 	t.Run("invalid request payload", func(t *testing.T) {
@@ -86,23 +198,18 @@ func TestCreateOrderHandler(t *testing.T) {
 
 		assert.Equal(t, http.StatusBadRequest, rr.Code)
 
-		var result models.ErrorResponse
+		var result models.Problem
 		err = json.NewDecoder(rr.Body).Decode(&result)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		assert.Equal(t, models.ErrorResponse{Error: "Invalid request payload"}, result)
+		assert.Equal(t, models.CodeInvalidPayload, result.Code)
+		assert.Equal(t, "Invalid request payload", result.Detail)
 	})
 
-	t.Run("database error on order creation", func(t *testing.T) {
-		customerID := uuid.New()
-		productID := uuid.New()
-		orderWrite := models.OrderWrite{CustomerID: customerID, ProductID: productID, Amount: 100.0}
-
-		mock.ExpectQuery("INSERT INTO orders").
-			WithArgs(orderWrite.CustomerID, orderWrite.ProductID, models.Pending, orderWrite.Amount).
-			WillReturnError(sql.ErrConnDone)
+	t.Run("unknown product", func(t *testing.T) {
+		orderWrite := models.OrderWrite{CustomerID: customerID, ProductID: uuid.New(), Amount: 100.0}
 
 		body, _ := json.Marshal(orderWrite)
 		req, err := http.NewRequest("POST", "/order", bytes.NewBuffer(body))
@@ -114,45 +221,51 @@ func TestCreateOrderHandler(t *testing.T) {
 		handler.ServeHTTP(rr, req)
 
 		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+
+	t.Run("storage error on order creation", func(t *testing.T) {
+		storageErr := errors.New("connection refused")
+		orderWrite := models.OrderWrite{CustomerID: customerID, ProductID: productID, Amount: 100.0}
+
+		body, _ := json.Marshal(orderWrite)
+		req, err := http.NewRequest("POST", "/order", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
 
-		var result models.ErrorResponse
+		rr := httptest.NewRecorder()
+		CreateOrderHandler(service.NewOrders(failingOrders{err: storageErr}, repos.Customers, nil, rdb, nil)).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+		var result models.Problem
 		err = json.NewDecoder(rr.Body).Decode(&result)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		assert.Equal(t, models.ErrorResponse{Error: "Failed to create order: " + sql.ErrConnDone.Error()}, result)
+		assert.Equal(t, models.CodeInternal, result.Code)
+		assert.Equal(t, "Failed to create order", result.Detail)
+		assert.NotContains(t, rr.Body.String(), storageErr.Error())
 	})
 }
 
 func TestGetOrderHandler(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	store := repository.NewMemory()
+	handler := GetOrderHandler(service.NewOrders(store.Repositories().Orders, nil, nil, nil, nil))
+
+	orderRead := models.OrderRead{
+		ID:         uuid.New(),
+		CustomerID: uuid.New(),
+		ProductID:  uuid.New(),
+		Status:     models.Pending,
+		Amount:     100.0,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
 	}
-	defer db.Close()
-
-	handler := GetOrderHandler(db)
+	store.AddOrder(orderRead)
 
 	t.Run("successful order retrieval", func(t *testing.T) {
-		customerID := uuid.New()
-		productID := uuid.New()
-		orderID := uuid.New()
-		orderRead := models.OrderRead{
-			ID:         orderID,
-			CustomerID: customerID,
-			ProductID:  productID,
-			Status:     models.Pending,
-			Amount:     100.0,
-			CreatedAt:  time.Now(),
-			UpdatedAt:  time.Now(),
-		}
-
-		mock.ExpectQuery("SELECT id, customer_id, product_id, status, amount, created_at, updated_at FROM orders WHERE id = \\$1").
-			WithArgs(orderRead.ID).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "customer_id", "product_id", "status", "amount", "created_at", "updated_at"}).
-				AddRow(orderRead.ID, orderRead.CustomerID, orderRead.ProductID, orderRead.Status, orderRead.Amount, orderRead.CreatedAt, orderRead.UpdatedAt))
-
 		req, err := http.NewRequest("GET", "/order/"+orderRead.ID.String(), nil)
 		if err != nil {
 			t.Fatal(err)
@@ -181,10 +294,6 @@ func TestGetOrderHandler(t *testing.T) {
 	t.Run("order not found", func(t *testing.T) {
 		orderID := uuid.New().String()
 
-		mock.ExpectQuery("SELECT id, customer_id, product_id, status, amount, created_at, updated_at FROM orders WHERE id = \\$1").
-			WithArgs(orderID).
-			WillReturnError(sql.ErrNoRows)
-
 		req, err := http.NewRequest("GET", "/order/"+orderID, nil)
 		if err != nil {
 			t.Fatal(err)
@@ -196,13 +305,14 @@ func TestGetOrderHandler(t *testing.T) {
 
 		assert.Equal(t, http.StatusNotFound, rr.Code)
 
-		var result models.ErrorResponse
+		var result models.Problem
 		err = json.NewDecoder(rr.Body).Decode(&result)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		assert.Equal(t, models.ErrorResponse{Error: "Order not found"}, result)
+		assert.Equal(t, models.CodeNotFound, result.Code)
+		assert.Equal(t, "Order not found", result.Detail)
 	})
 
 	t.Run("invalid order ID", func(t *testing.T) {
@@ -216,12 +326,135 @@ func TestGetOrderHandler(t *testing.T) {
 
 		assert.Equal(t, http.StatusBadRequest, rr.Code)
 
-		var result models.ErrorResponse
+		var result models.Problem
 		err = json.NewDecoder(rr.Body).Decode(&result)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		assert.Equal(t, models.ErrorResponse{Error: "Invalid order ID"}, result)
+		assert.Equal(t, models.CodeInvalidParameter, result.Code)
+		assert.Equal(t, "Invalid order ID", result.Detail)
+	})
+
+	t.Run("request times out", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/order/"+orderRead.ID.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"id": orderRead.ID.String()})
+		rr := httptest.NewRecorder()
+		WithTimeout(10*time.Millisecond, GetOrderHandler(service.NewOrders(blockingOrders{}, nil, nil, nil, nil))).ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodeTimeout, result.Code)
+		assert.Equal(t, problem.TimeoutDetail, result.Detail)
+	})
+
+	t.Run("order of another customer", func(t *testing.T) {
+		customerID := uuid.New()
+
+		// The order exists, but not for this customer
+		req, err := http.NewRequest("GET", "/order/"+orderRead.ID.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"id": orderRead.ID.String()})
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Role: models.RoleCustomer, CustomerID: &customerID}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestListOrdersHandler(t *testing.T) {
+	store := repository.NewMemory()
+	handler := ListOrdersHandler(service.NewOrders(store.Repositories().Orders, nil, nil, nil, nil))
+
+	customerID := uuid.New()
+	start := time.Now()
+	var completed []models.OrderRead
+	for i := 0; i < 25; i++ {
+		orderRead := models.OrderRead{ID: uuid.New(), CustomerID: customerID, ProductID: uuid.New(), Status: models.Completed, Amount: 10.0, CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+		store.AddOrder(orderRead)
+		completed = append(completed, orderRead)
+	}
+	store.AddOrder(models.OrderRead{ID: uuid.New(), CustomerID: customerID, Status: models.Pending, CreatedAt: start})
+	store.AddOrder(models.OrderRead{ID: uuid.New(), CustomerID: uuid.New(), Status: models.Completed, CreatedAt: start})
+
+	t.Run("filters and pages", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/orders?customer_id="+customerID.String()+"&status=completed&limit=10&offset=20", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result []models.OrderRead
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Newest first, so the last page holds the five oldest
+		if assert.Len(t, result, 5) {
+			assert.Equal(t, completed[4].ID, result[0].ID)
+			assert.Equal(t, completed[0].ID, result[4].ID)
+		}
+	})
+
+	t.Run("customers only see their own orders", func(t *testing.T) {
+		otherID := uuid.New()
+
+		req, err := http.NewRequest("GET", "/orders", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Role: models.RoleCustomer, CustomerID: &otherID}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "[]\n", rr.Body.String())
+	})
+
+	t.Run("customer asking for another customer", func(t *testing.T) {
+		otherID := uuid.New()
+
+		req, err := http.NewRequest("GET", "/orders?customer_id="+customerID.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Role: models.RoleCustomer, CustomerID: &otherID}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/orders?limit=1000", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
 	})
 }
@@ -1,70 +1,76 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
 	"net/http"
 	"order/models"
+	"order/problem"
+	"order/service"
 
-	"github.com/go-playground/validator/v10"
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
-var validate = validator.New()
+var validate = service.NewValidator()
 
-func CreateProductHandler(db *sql.DB) http.HandlerFunc {
+func CreateProductHandler(products *service.Products) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var productWrite models.ProductWrite
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewDecoder(r.Body).Decode(&productWrite); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Invalid request payload"})
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
 			return
 		}
 
-		// Validate the productWrite struct
-		if err := validate.Struct(productWrite); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrorResponse{Error: err.Error()})
+		productRead, err := products.Create(r.Context(), productWrite)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		var productRead models.ProductRead
-		err := db.QueryRow("INSERT INTO products (name, price) VALUES ($1, $2) RETURNING id, name, price, created_at, updated_at", productWrite.Name, productWrite.Price).Scan(&productRead.ID, &productRead.Name, &productRead.Price, &productRead.CreatedAt, &productRead.UpdatedAt)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(productRead)
+	}
+}
+
+func GetProductHandler(products *service.Products) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		id, err := service.ParseID(vars["id"], "product")
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Failed to create product: " + err.Error()})
+			writeError(w, r, err)
+			return
+		}
+
+		productRead, err := products.Get(r.Context(), id)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(productRead)
 	}
 }
 
-func GetProductHandler(db *sql.DB) http.HandlerFunc {
+func UpdateProductHandler(products *service.Products) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		w.Header().Set("Content-Type", "application/json")
-		id := vars["id"]
-		_, err := uuid.Parse(id)
+		id, err := service.ParseID(vars["id"], "product")
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Invalid product ID"})
+			writeError(w, r, err)
+			return
+		}
+
+		var productWrite models.ProductWrite
+		if err := json.NewDecoder(r.Body).Decode(&productWrite); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
 			return
 		}
 
-		var productRead models.ProductRead
-		err = db.QueryRow("SELECT id, name, price, created_at, updated_at FROM products where id = $1", id).Scan(&productRead.ID, &productRead.Name, &productRead.Price, &productRead.CreatedAt, &productRead.UpdatedAt)
+		productRead, err := products.Update(r.Context(), id, productWrite)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				w.WriteHeader(http.StatusNotFound)
-				json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Product not found"})
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Failed to retrieve product"})
-			}
+			writeError(w, r, err)
 			return
 		}
 
@@ -2,36 +2,27 @@ package handlers
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"order/models"
+	"order/repository"
+	"order/service"
 	"testing"
 
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestCreateProductHandler(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
-
-	handler := CreateProductHandler(db)
+	store := repository.NewMemory()
+	products := store.Repositories().Products
+	handler := CreateProductHandler(service.NewProducts(products))
 
 	t.Run("successful product creation", func(t *testing.T) {
-		productID := uuid.New()
 		productWrite := models.ProductWrite{Name: "New Product", Price: 99.99}
-		productRead := models.ProductRead{ID: productID, Name: "New Product", Price: 99.99}
-
-		mock.ExpectQuery("INSERT INTO products").
-			WithArgs(productWrite.Name, productWrite.Price).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).AddRow(productRead.ID, productRead.Name, productRead.Price))
 
 		body, _ := json.Marshal(productWrite)
 		req, err := http.NewRequest("POST", "/product", bytes.NewBuffer(body))
@@ -50,7 +41,13 @@ func TestCreateProductHandler(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		assert.Equal(t, productRead, result)
+		assert.Equal(t, productWrite.Name, result.Name)
+		assert.Equal(t, productWrite.Price, result.Price)
+
+		stored, err := products.Get(context.Background(), result.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, stored.ID, result.ID)
+		assert.Equal(t, stored.Name, result.Name)
 	})
 
 	t.Run("invalid request payload", func(t *testing.T) {
@@ -64,32 +61,27 @@ func TestCreateProductHandler(t *testing.T) {
 
 		assert.Equal(t, http.StatusBadRequest, rr.Code)
 
-		var result models.ErrorResponse
+		var result models.Problem
 		err = json.NewDecoder(rr.Body).Decode(&result)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		assert.Equal(t, models.ErrorResponse{Error: "Key: 'ProductWrite.Name' Error:Field validation for 'Name' failed on the 'required' tag\nKey: 'ProductWrite.Price' Error:Field validation for 'Price' failed on the 'required' tag"}, result)
+		assert.Equal(t, models.CodeValidationFailed, result.Code)
+		assert.Equal(t, []models.FieldError{
+			{Field: "name", Rule: "required", Message: "is required"},
+			{Field: "price", Rule: "required", Message: "is required"},
+		}, result.Errors)
 	})
 }
 
 func TestGetProductHandler(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
-
-	handler := GetProductHandler(db)
+	store := repository.NewMemory()
+	handler := GetProductHandler(service.NewProducts(store.Repositories().Products))
 
 	t.Run("successful product retrieval", func(t *testing.T) {
-		productID := uuid.New()
-		productRead := models.ProductRead{ID: productID, Name: "Sample Product", Price: 99.99}
-
-		mock.ExpectQuery("SELECT id, name, price FROM products where id = \\$1").
-			WithArgs(productRead.ID).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price"}).AddRow(productRead.ID, productRead.Name, productRead.Price))
+		productRead := models.ProductRead{ID: uuid.New(), Name: "Sample Product", Price: 99.99}
+		store.AddProduct(productRead)
 
 		req, err := http.NewRequest("GET", "/product/"+productRead.ID.String(), nil)
 		if err != nil {
@@ -114,10 +106,6 @@ func TestGetProductHandler(t *testing.T) {
 	t.Run("product not found", func(t *testing.T) {
 		productID := uuid.New().String()
 
-		mock.ExpectQuery("SELECT id, name, price FROM products where id = \\$1").
-			WithArgs(productID).
-			WillReturnError(sql.ErrNoRows)
-
 		req, err := http.NewRequest("GET", "/product/"+productID, nil)
 		if err != nil {
 			t.Fatal(err)
@@ -129,13 +117,14 @@ func TestGetProductHandler(t *testing.T) {
 
 		assert.Equal(t, http.StatusNotFound, rr.Code)
 
-		var result models.ErrorResponse
+		var result models.Problem
 		err = json.NewDecoder(rr.Body).Decode(&result)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		assert.Equal(t, models.ErrorResponse{Error: "Product not found"}, result)
+		assert.Equal(t, models.CodeNotFound, result.Code)
+		assert.Equal(t, "Product not found", result.Detail)
 	})
 
 	t.Run("invalid product ID", func(t *testing.T) {
@@ -149,12 +138,60 @@ func TestGetProductHandler(t *testing.T) {
 
 		assert.Equal(t, http.StatusBadRequest, rr.Code)
 
-		var result models.ErrorResponse
+		var result models.Problem
 		err = json.NewDecoder(rr.Body).Decode(&result)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		assert.Equal(t, models.ErrorResponse{Error: "Invalid product ID"}, result)
+		assert.Equal(t, models.CodeInvalidParameter, result.Code)
+		assert.Equal(t, "Invalid product ID", result.Detail)
+	})
+}
+
+func TestUpdateProductHandler(t *testing.T) {
+	store := repository.NewMemory()
+	handler := UpdateProductHandler(service.NewProducts(store.Repositories().Products))
+
+	productRead := models.ProductRead{ID: uuid.New(), Name: "Sample Product", Price: 99.99}
+	store.AddProduct(productRead)
+
+	t.Run("successful product update", func(t *testing.T) {
+		body, _ := json.Marshal(models.ProductWrite{Name: "Renamed Product", Price: 89.99})
+		req, err := http.NewRequest("PUT", "/product/"+productRead.ID.String(), bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"id": productRead.ID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result models.ProductRead
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, productRead.ID, result.ID)
+		assert.Equal(t, "Renamed Product", result.Name)
+		assert.Equal(t, 89.99, result.Price)
+	})
+
+	t.Run("product not found", func(t *testing.T) {
+		productID := uuid.New().String()
+		body, _ := json.Marshal(models.ProductWrite{Name: "Renamed Product", Price: 89.99})
+		req, err := http.NewRequest("PUT", "/product/"+productID, bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"id": productID})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
 	})
 }
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"order/events"
+	"order/models"
+	"order/problem"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const shipmentColumns = "id, order_id, status, carrier, tracking_number, shipped_at, delivered_at, created_at, updated_at"
+
+func scanShipment(row interface{ Scan(...interface{}) error }, s *models.ShipmentRead) error {
+	return row.Scan(&s.ID, &s.OrderID, &s.Status, &s.Carrier, &s.TrackingNumber, &s.ShippedAt, &s.DeliveredAt, &s.CreatedAt, &s.UpdatedAt)
+}
+
+func insertShipmentEvent(ctx context.Context, tx *sql.Tx, shipmentID uuid.UUID, status models.ShipmentStatus, note string, occurredAt time.Time) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO shipment_events (shipment_id, status, note, occurred_at) VALUES ($1, $2, $3, $4)", shipmentID, status, note, occurredAt)
+	return err
+}
+
+// syncOrderFulfilmentStatus recomputes the order status from its shipments.
+// It returns the resulting status event, or nil if the status didn't change.
+// The caller must hold the order's row lock, so concurrent changes to its
+// other shipments are committed before they are read.
+func syncOrderFulfilmentStatus(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) (*models.OrderEvent, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT status FROM shipments WHERE order_id = $1", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []models.ShipmentStatus
+	for rows.Next() {
+		var status models.ShipmentStatus
+		if err := rows.Scan(&status); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events.UpdateOrderStatus(ctx, tx, orderID, models.FulfilmentStatus(statuses))
+}
+
+func publishEvent(broker *events.Broker, event *models.OrderEvent) {
+	if event != nil {
+		broker.Publish(*event)
+	}
+}
+
+func CreateShipmentHandler(db *sql.DB, broker *events.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		orderID, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid order ID")
+			return
+		}
+
+		var shipmentWrite models.ShipmentWrite
+		if err := json.NewDecoder(r.Body).Decode(&shipmentWrite); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to create shipment")
+			return
+		}
+		defer tx.Rollback()
+
+		var orderStatus models.OrderStatus
+		err = tx.QueryRowContext(r.Context(), "SELECT status FROM orders WHERE id = $1 FOR UPDATE", orderID).Scan(&orderStatus)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Order not found")
+			} else {
+				problem.Storage(w, r, err, "Failed to create shipment")
+			}
+			return
+		}
+		if !orderStatus.Paid() {
+			problem.Write(w, r, http.StatusConflict, models.CodeOrderNotPaid, "Order has not been paid")
+			return
+		}
+
+		var shipmentRead models.ShipmentRead
+		err = scanShipment(tx.QueryRowContext(r.Context(),
+			"INSERT INTO shipments (order_id, status, carrier, tracking_number) VALUES ($1, $2, $3, $4) RETURNING "+shipmentColumns,
+			orderID, models.ShipmentAwaiting, shipmentWrite.Carrier, shipmentWrite.TrackingNumber), &shipmentRead)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to create shipment")
+			return
+		}
+
+		if err := insertShipmentEvent(r.Context(), tx, shipmentRead.ID, shipmentRead.Status, "", shipmentRead.CreatedAt); err != nil {
+			problem.Storage(w, r, err, "Failed to create shipment")
+			return
+		}
+
+		event, err := syncOrderFulfilmentStatus(r.Context(), tx, orderID)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to create shipment")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			problem.Storage(w, r, err, "Failed to create shipment")
+			return
+		}
+		publishEvent(broker, event)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(shipmentRead)
+	}
+}
+
+func ListShipmentsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		orderID, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid order ID")
+			return
+		}
+
+		mayRead, err := mayReadOrder(r, db, orderID)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve shipments")
+			return
+		}
+		if !mayRead {
+			problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Order not found")
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), "SELECT "+shipmentColumns+" FROM shipments WHERE order_id = $1 ORDER BY created_at", orderID)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve shipments")
+			return
+		}
+		defer rows.Close()
+
+		shipments := []models.ShipmentRead{}
+		for rows.Next() {
+			var shipmentRead models.ShipmentRead
+			if err := scanShipment(rows, &shipmentRead); err != nil {
+				problem.Storage(w, r, err, "Failed to retrieve shipments")
+				return
+			}
+			shipments = append(shipments, shipmentRead)
+		}
+		if err := rows.Err(); err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve shipments")
+			return
+		}
+
+		json.NewEncoder(w).Encode(shipments)
+	}
+}
+
+func GetShipmentHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid shipment ID")
+			return
+		}
+
+		var shipmentRead models.ShipmentRead
+		err = scanShipment(db.QueryRowContext(r.Context(), "SELECT "+shipmentColumns+" FROM shipments WHERE id = $1", id), &shipmentRead)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Shipment not found")
+			} else {
+				problem.Storage(w, r, err, "Failed to retrieve shipment")
+			}
+			return
+		}
+		mayRead, err := mayReadOrder(r, db, shipmentRead.OrderID)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve shipment")
+			return
+		}
+		if !mayRead {
+			problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Shipment not found")
+			return
+		}
+
+		rows, err := db.QueryContext(r.Context(), "SELECT id, shipment_id, status, note, occurred_at, created_at FROM shipment_events WHERE shipment_id = $1 ORDER BY occurred_at, id", id)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve shipment")
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var event models.ShipmentEventRead
+			if err := rows.Scan(&event.ID, &event.ShipmentID, &event.Status, &event.Note, &event.OccurredAt, &event.CreatedAt); err != nil {
+				problem.Storage(w, r, err, "Failed to retrieve shipment")
+				return
+			}
+			shipmentRead.Events = append(shipmentRead.Events, event)
+		}
+		if err := rows.Err(); err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve shipment")
+			return
+		}
+
+		json.NewEncoder(w).Encode(shipmentRead)
+	}
+}
+
+func CreateShipmentEventHandler(db *sql.DB, broker *events.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid shipment ID")
+			return
+		}
+
+		var eventWrite models.ShipmentEventWrite
+		if err := json.NewDecoder(r.Body).Decode(&eventWrite); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
+			return
+		}
+
+		// Validate the eventWrite struct
+		if err := validate.Struct(eventWrite); err != nil {
+			problem.Validation(w, r, err)
+			return
+		}
+
+		occurredAt := time.Now()
+		if eventWrite.OccurredAt != nil {
+			occurredAt = *eventWrite.OccurredAt
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to record shipment event")
+			return
+		}
+		defer tx.Rollback()
+
+		// The order is locked before the shipment, as when creating one, so
+		// events on shipments of the same order are applied one at a time and
+		// each sees the others' statuses when recomputing the order's.
+		var orderID uuid.UUID
+		err = tx.QueryRowContext(r.Context(), "SELECT order_id FROM shipments WHERE id = $1", id).Scan(&orderID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Shipment not found")
+			} else {
+				problem.Storage(w, r, err, "Failed to record shipment event")
+			}
+			return
+		}
+		var orderStatus models.OrderStatus
+		err = tx.QueryRowContext(r.Context(), "SELECT status FROM orders WHERE id = $1 FOR UPDATE", orderID).Scan(&orderStatus)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to record shipment event")
+			return
+		}
+
+		var shipmentRead models.ShipmentRead
+		err = scanShipment(tx.QueryRowContext(r.Context(), "SELECT "+shipmentColumns+" FROM shipments WHERE id = $1 FOR UPDATE", id), &shipmentRead)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to record shipment event")
+			return
+		}
+
+		if !shipmentRead.Status.CanTransitionTo(eventWrite.Status) {
+			problem.Write(w, r, http.StatusConflict, models.CodeInvalidTransition, "Shipment cannot move from "+string(shipmentRead.Status)+" to "+string(eventWrite.Status))
+			return
+		}
+
+		err = scanShipment(tx.QueryRowContext(r.Context(),
+			`UPDATE shipments SET status = $2,
+				carrier = COALESCE(NULLIF($3, ''), carrier),
+				tracking_number = COALESCE(NULLIF($4, ''), tracking_number),
+				shipped_at = CASE WHEN $2 = 'shipped' THEN $5 ELSE shipped_at END,
+				delivered_at = CASE WHEN $2 = 'delivered' THEN $5 ELSE delivered_at END,
+				updated_at = NOW()
+			WHERE id = $1 RETURNING `+shipmentColumns,
+			id, eventWrite.Status, eventWrite.Carrier, eventWrite.TrackingNumber, occurredAt), &shipmentRead)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to record shipment event")
+			return
+		}
+
+		if err := insertShipmentEvent(r.Context(), tx, id, eventWrite.Status, eventWrite.Note, occurredAt); err != nil {
+			problem.Storage(w, r, err, "Failed to record shipment event")
+			return
+		}
+
+		event, err := syncOrderFulfilmentStatus(r.Context(), tx, shipmentRead.OrderID)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to record shipment event")
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			problem.Storage(w, r, err, "Failed to record shipment event")
+			return
+		}
+		publishEvent(broker, event)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(shipmentRead)
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"order/events"
+	"order/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var shipmentRowColumns = []string{"id", "order_id", "status", "carrier", "tracking_number", "shipped_at", "delivered_at", "created_at", "updated_at"}
+
+func TestCreateShipmentHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	broker := events.NewBroker()
+	handler := CreateShipmentHandler(db, broker)
+
+	t.Run("successful shipment creation", func(t *testing.T) {
+		orderID := uuid.New()
+		shipmentID := uuid.New()
+		now := time.Now()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM orders WHERE id = \\$1 FOR UPDATE").
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.Completed))
+		mock.ExpectQuery("INSERT INTO shipments").
+			WithArgs(orderID, models.ShipmentAwaiting, "UPS", "").
+			WillReturnRows(sqlmock.NewRows(shipmentRowColumns).
+				AddRow(shipmentID, orderID, models.ShipmentAwaiting, "UPS", "", nil, nil, now, now))
+		mock.ExpectExec("INSERT INTO shipment_events").
+			WithArgs(shipmentID, models.ShipmentAwaiting, "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT status FROM shipments WHERE order_id = \\$1").
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.ShipmentAwaiting))
+		mock.ExpectQuery("UPDATE orders SET status").
+			WithArgs(orderID, models.AwaitingShipment).
+			WillReturnRows(sqlmock.NewRows(eventRowColumns).AddRow(1, orderID, uuid.New(), models.AwaitingShipment, now))
+		mock.ExpectCommit()
+
+		req, err := http.NewRequest("POST", "/order/"+orderID.String()+"/shipment", bytes.NewBuffer([]byte(`{"carrier": "UPS"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"id": orderID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+
+		var result models.ShipmentRead
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, shipmentID, result.ID)
+		assert.Equal(t, models.ShipmentAwaiting, result.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("order not paid", func(t *testing.T) {
+		orderID := uuid.New()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM orders WHERE id = \\$1 FOR UPDATE").
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.Pending))
+		mock.ExpectRollback()
+
+		req, err := http.NewRequest("POST", "/order/"+orderID.String()+"/shipment", bytes.NewBuffer([]byte(`{}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"id": orderID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusConflict, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodeOrderNotPaid, result.Code)
+		assert.Equal(t, "Order has not been paid", result.Detail)
+	})
+}
+
+func TestCreateShipmentEventHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	broker := events.NewBroker()
+	handler := CreateShipmentEventHandler(db, broker)
+
+	t.Run("mark shipped", func(t *testing.T) {
+		orderID := uuid.New()
+		published, cancel := broker.Subscribe(func(e models.OrderEvent) bool { return e.OrderID == orderID })
+		defer cancel()
+		shipmentID := uuid.New()
+		now := time.Now()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT order_id FROM shipments WHERE id = \\$1").
+			WithArgs(shipmentID).
+			WillReturnRows(sqlmock.NewRows([]string{"order_id"}).AddRow(orderID))
+		mock.ExpectQuery("SELECT status FROM orders WHERE id = \\$1 FOR UPDATE").
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.AwaitingShipment))
+		mock.ExpectQuery("SELECT (.+) FROM shipments WHERE id = \\$1 FOR UPDATE").
+			WithArgs(shipmentID).
+			WillReturnRows(sqlmock.NewRows(shipmentRowColumns).
+				AddRow(shipmentID, orderID, models.ShipmentAwaiting, "UPS", "", nil, nil, now, now))
+		mock.ExpectQuery("UPDATE shipments SET status").
+			WithArgs(shipmentID, models.ShipmentShipped, "", "1Z999", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(shipmentRowColumns).
+				AddRow(shipmentID, orderID, models.ShipmentShipped, "UPS", "1Z999", now, nil, now, now))
+		mock.ExpectExec("INSERT INTO shipment_events").
+			WithArgs(shipmentID, models.ShipmentShipped, "left warehouse", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectQuery("SELECT status FROM shipments WHERE order_id = \\$1").
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.ShipmentShipped))
+		mock.ExpectQuery("UPDATE orders SET status").
+			WithArgs(orderID, models.Shipped).
+			WillReturnRows(sqlmock.NewRows(eventRowColumns).AddRow(2, orderID, uuid.New(), models.Shipped, now))
+		mock.ExpectCommit()
+
+		req, err := http.NewRequest("POST", "/shipment/"+shipmentID.String()+"/event", bytes.NewBuffer([]byte(`{"status": "shipped", "tracking_number": "1Z999", "note": "left warehouse"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"id": shipmentID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+
+		var result models.ShipmentRead
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.ShipmentShipped, result.Status)
+		assert.Equal(t, "1Z999", result.TrackingNumber)
+		assert.NotNil(t, result.ShippedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+
+		event := <-published
+		assert.Equal(t, models.Shipped, event.Status)
+	})
+
+	t.Run("invalid transition", func(t *testing.T) {
+		shipmentID, orderID := uuid.New(), uuid.New()
+		now := time.Now()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT order_id FROM shipments WHERE id = \\$1").
+			WithArgs(shipmentID).
+			WillReturnRows(sqlmock.NewRows([]string{"order_id"}).AddRow(orderID))
+		mock.ExpectQuery("SELECT status FROM orders WHERE id = \\$1 FOR UPDATE").
+			WithArgs(orderID).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.AwaitingShipment))
+		mock.ExpectQuery("SELECT (.+) FROM shipments WHERE id = \\$1 FOR UPDATE").
+			WithArgs(shipmentID).
+			WillReturnRows(sqlmock.NewRows(shipmentRowColumns).
+				AddRow(shipmentID, orderID, models.ShipmentAwaiting, "", "", nil, nil, now, now))
+		mock.ExpectRollback()
+
+		req, err := http.NewRequest("POST", "/shipment/"+shipmentID.String()+"/event", bytes.NewBuffer([]byte(`{"status": "delivered"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"id": shipmentID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusConflict, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodeInvalidTransition, result.Code)
+		assert.Equal(t, "Shipment cannot move from awaiting_shipment to delivered", result.Detail)
+	})
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTimeout(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+	})
+
+	req, err := http.NewRequest("GET", "/orders", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	WithTimeout(time.Minute, h).ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	WithTimeout(0, h).ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, ok)
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"order/models"
+	"order/problem"
+	"order/webhooks"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const webhookColumns = "id, url, event_types, active, created_at, updated_at"
+
+func scanWebhook(row interface{ Scan(...interface{}) error }, wh *models.WebhookRead) error {
+	return row.Scan(&wh.ID, &wh.URL, &wh.EventTypes, &wh.Active, &wh.CreatedAt, &wh.UpdatedAt)
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func CreateWebhookHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var webhookWrite models.WebhookWrite
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewDecoder(r.Body).Decode(&webhookWrite); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
+			return
+		}
+
+		// Validate the webhookWrite struct
+		if err := validate.Struct(webhookWrite); err != nil {
+			problem.Validation(w, r, err)
+			return
+		}
+
+		secret := webhookWrite.Secret
+		if secret == "" {
+			var err error
+			if secret, err = generateSecret(); err != nil {
+				problem.Write(w, r, http.StatusInternalServerError, models.CodeInternal, "Failed to create webhook")
+				return
+			}
+		}
+		active := webhookWrite.Active == nil || *webhookWrite.Active
+
+		var webhookRead models.WebhookRead
+		err := scanWebhook(db.QueryRowContext(r.Context(),
+			"INSERT INTO webhooks (url, secret, event_types, active) VALUES ($1, $2, $3, $4) RETURNING "+webhookColumns,
+			webhookWrite.URL, secret, webhookWrite.EventTypes, active), &webhookRead)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to create webhook")
+			return
+		}
+		webhookRead.Secret = secret
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(webhookRead)
+	}
+}
+
+func ListWebhooksHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		rows, err := db.QueryContext(r.Context(), "SELECT "+webhookColumns+" FROM webhooks ORDER BY created_at")
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve webhooks")
+			return
+		}
+		defer rows.Close()
+
+		webhookList := []models.WebhookRead{}
+		for rows.Next() {
+			var webhookRead models.WebhookRead
+			if err := scanWebhook(rows, &webhookRead); err != nil {
+				problem.Storage(w, r, err, "Failed to retrieve webhooks")
+				return
+			}
+			webhookList = append(webhookList, webhookRead)
+		}
+		if err := rows.Err(); err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve webhooks")
+			return
+		}
+
+		json.NewEncoder(w).Encode(webhookList)
+	}
+}
+
+func GetWebhookHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid webhook ID")
+			return
+		}
+
+		var webhookRead models.WebhookRead
+		err = scanWebhook(db.QueryRowContext(r.Context(), "SELECT "+webhookColumns+" FROM webhooks WHERE id = $1", id), &webhookRead)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Webhook not found")
+			} else {
+				problem.Storage(w, r, err, "Failed to retrieve webhook")
+			}
+			return
+		}
+
+		json.NewEncoder(w).Encode(webhookRead)
+	}
+}
+
+func UpdateWebhookHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid webhook ID")
+			return
+		}
+
+		var webhookWrite models.WebhookWrite
+		if err := json.NewDecoder(r.Body).Decode(&webhookWrite); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidPayload, "Invalid request payload")
+			return
+		}
+
+		// Validate the webhookWrite struct
+		if err := validate.Struct(webhookWrite); err != nil {
+			problem.Validation(w, r, err)
+			return
+		}
+		active := webhookWrite.Active == nil || *webhookWrite.Active
+
+		// The secret is only rotated when a new one is given
+		var webhookRead models.WebhookRead
+		err = scanWebhook(db.QueryRowContext(r.Context(),
+			"UPDATE webhooks SET url = $2, secret = COALESCE(NULLIF($3, ''), secret), event_types = $4, active = $5, updated_at = NOW() WHERE id = $1 RETURNING "+webhookColumns,
+			id, webhookWrite.URL, webhookWrite.Secret, webhookWrite.EventTypes, active), &webhookRead)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Webhook not found")
+			} else {
+				problem.Storage(w, r, err, "Failed to update webhook")
+			}
+			return
+		}
+
+		json.NewEncoder(w).Encode(webhookRead)
+	}
+}
+
+func DeleteWebhookHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid webhook ID")
+			return
+		}
+
+		result, err := db.ExecContext(r.Context(), "DELETE FROM webhooks WHERE id = $1", id)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to delete webhook")
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Webhook not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ListWebhookDeliveriesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid webhook ID")
+			return
+		}
+
+		deliveries, err := webhooks.ListDeliveries(r.Context(), db, id)
+		if err != nil {
+			problem.Storage(w, r, err, "Failed to retrieve webhook deliveries")
+			return
+		}
+
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+func TestWebhookHandler(dispatcher *webhooks.Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Header().Set("Content-Type", "application/json")
+		id, err := uuid.Parse(vars["id"])
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, models.CodeInvalidParameter, "Invalid webhook ID")
+			return
+		}
+
+		delivery, err := dispatcher.SendTest(r.Context(), id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				problem.Write(w, r, http.StatusNotFound, models.CodeNotFound, "Webhook not found")
+			} else {
+				problem.Storage(w, r, err, "Failed to send test delivery")
+			}
+			return
+		}
+
+		// The outcome of the delivery itself is reported in the body
+		json.NewEncoder(w).Encode(delivery)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"order/models"
+	"order/webhooks"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateWebhookHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	handler := CreateWebhookHandler(db)
+
+	t.Run("generates a secret", func(t *testing.T) {
+		webhookID := uuid.New()
+		eventTypes := models.EventTypes{"order.completed", "order.failed"}
+		eventTypesJSON, _ := json.Marshal(eventTypes)
+		now := time.Now()
+
+		mock.ExpectQuery("INSERT INTO webhooks").
+			WithArgs("https://example.com/hooks", sqlmock.AnyArg(), eventTypesJSON, true).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "url", "event_types", "active", "created_at", "updated_at"}).
+				AddRow(webhookID, "https://example.com/hooks", eventTypesJSON, true, now, now))
+
+		req, err := http.NewRequest("POST", "/webhooks", bytes.NewBuffer([]byte(`{"url": "https://example.com/hooks", "event_types": ["order.completed", "order.failed"]}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusCreated, rr.Code)
+
+		var result models.WebhookRead
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, webhookID, result.ID)
+		assert.Equal(t, eventTypes, result.EventTypes)
+		assert.Len(t, result.Secret, 64)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown event type", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/webhooks", bytes.NewBuffer([]byte(`{"url": "https://example.com/hooks", "event_types": ["order.exploded"]}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestTestWebhookHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	handler := TestWebhookHandler(webhooks.NewDispatcher(db, http.DefaultClient))
+
+	t.Run("webhook not found", func(t *testing.T) {
+		webhookID := uuid.New()
+
+		mock.ExpectQuery("SELECT url, secret FROM webhooks WHERE id = \\$1").
+			WithArgs(webhookID).
+			WillReturnError(sql.ErrNoRows)
+
+		req, err := http.NewRequest("POST", "/webhooks/"+webhookID.String()+"/test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"id": webhookID.String()})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+
+		var result models.Problem
+		err = json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, models.CodeNotFound, result.Code)
+		assert.Equal(t, "Webhook not found", result.Detail)
+	})
+}
@@ -0,0 +1,130 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"order/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Check reports whether a dependency is usable. Details, if not nil, are
+// included in the readiness report whether or not the check passed.
+type Check func(ctx context.Context) (details interface{}, err error)
+
+// Checker runs the named readiness checks concurrently, each bounded by the
+// timeout.
+type Checker struct {
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Add registers a check under name, replacing any check already there
+func (c *Checker) Add(name string, check Check) {
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Run runs every check and reports the service ready only if all of them
+// passed.
+func (c *Checker) Run(ctx context.Context) models.HealthReport {
+	results := make([]models.CheckResult, len(c.names))
+	var wg sync.WaitGroup
+	for i, name := range c.names {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}(i, c.checks[name])
+	}
+	wg.Wait()
+
+	report := models.HealthReport{Status: models.HealthOK, Checks: make(map[string]models.CheckResult, len(c.names))}
+	for i, name := range c.names {
+		report.Checks[name] = results[i]
+		if results[i].Status != models.HealthOK {
+			report.Status = models.HealthUnavailable
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) models.CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	details, err := check(ctx)
+	result := models.CheckResult{
+		Status:    models.HealthOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Details:   details,
+	}
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		result.Status = models.HealthUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Database pings Postgres
+func Database(db *sql.DB) Check {
+	return func(ctx context.Context) (interface{}, error) {
+		return nil, db.PingContext(ctx)
+	}
+}
+
+// Redis pings the Redis server
+func Redis(rdb *redis.Client) Check {
+	return func(ctx context.Context) (interface{}, error) {
+		return nil, rdb.Ping(ctx).Err()
+	}
+}
+
+// Subscription pings over the subscription's own connection, which fails
+// once the subscription has been closed or while it is reconnecting.
+func Subscription(subscription interface{ Ping(context.Context) error }) Check {
+	return func(ctx context.Context) (interface{}, error) {
+		return nil, subscription.Ping(ctx)
+	}
+}
+
+// OutboxLag is how far the webhook dispatcher is behind the order history
+type OutboxLag struct {
+	PendingEvents int64   `json:"pending_events"`
+	LagSeconds    float64 `json:"lag_seconds"`
+}
+
+// WebhookOutbox fails once the oldest order event not yet queued for webhook
+// delivery is older than maxLag, which means the dispatcher has stopped
+// keeping up.
+func WebhookOutbox(db *sql.DB, maxLag time.Duration) Check {
+	return func(ctx context.Context) (interface{}, error) {
+		var lag OutboxLag
+		err := db.QueryRowContext(ctx,
+			`SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at)), 0)
+			FROM order_status_history WHERE NOT webhooks_enqueued`).
+			Scan(&lag.PendingEvents, &lag.LagSeconds)
+		if err != nil {
+			return nil, err
+		}
+		if behind := time.Duration(lag.LagSeconds * float64(time.Second)); behind > maxLag {
+			return lag, fmt.Errorf("oldest pending event is %s old, more than %s", behind.Round(time.Second), maxLag)
+		}
+		return lag, nil
+	}
+}
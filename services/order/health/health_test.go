@@ -0,0 +1,85 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"order/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckerRun(t *testing.T) {
+	t.Run("ready when every check passes", func(t *testing.T) {
+		checker := NewChecker(time.Second)
+		checker.Add("database", func(ctx context.Context) (interface{}, error) { return nil, nil })
+		checker.Add("redis", func(ctx context.Context) (interface{}, error) { return map[string]int{"clients": 3}, nil })
+
+		report := checker.Run(context.Background())
+
+		assert.Equal(t, models.HealthOK, report.Status)
+		assert.Len(t, report.Checks, 2)
+		assert.Equal(t, models.HealthOK, report.Checks["database"].Status)
+		assert.Equal(t, map[string]int{"clients": 3}, report.Checks["redis"].Details)
+	})
+
+	t.Run("not ready when any check fails", func(t *testing.T) {
+		checker := NewChecker(time.Second)
+		checker.Add("database", func(ctx context.Context) (interface{}, error) { return nil, nil })
+		checker.Add("redis", func(ctx context.Context) (interface{}, error) { return nil, errors.New("connection refused") })
+
+		report := checker.Run(context.Background())
+
+		assert.Equal(t, models.HealthUnavailable, report.Status)
+		assert.Equal(t, models.HealthOK, report.Checks["database"].Status)
+		assert.Equal(t, models.HealthUnavailable, report.Checks["redis"].Status)
+		assert.Equal(t, "connection refused", report.Checks["redis"].Error)
+	})
+
+	t.Run("times out slow checks", func(t *testing.T) {
+		checker := NewChecker(10 * time.Millisecond)
+		checker.Add("database", func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+
+		report := checker.Run(context.Background())
+
+		assert.Equal(t, models.HealthUnavailable, report.Status)
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["database"].Error)
+	})
+}
+
+func TestWebhookOutbox(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	check := WebhookOutbox(db, time.Minute)
+
+	t.Run("within the allowed lag", func(t *testing.T) {
+		mock.ExpectQuery("FROM order_status_history WHERE NOT webhooks_enqueued").
+			WillReturnRows(sqlmock.NewRows([]string{"count", "lag"}).AddRow(4, 2.5))
+
+		details, err := check(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, OutboxLag{PendingEvents: 4, LagSeconds: 2.5}, details)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("lagging behind", func(t *testing.T) {
+		mock.ExpectQuery("FROM order_status_history WHERE NOT webhooks_enqueued").
+			WillReturnRows(sqlmock.NewRows([]string{"count", "lag"}).AddRow(120, 90.0))
+
+		details, err := check(context.Background())
+
+		assert.EqualError(t, err, "oldest pending event is 1m30s old, more than 1m0s")
+		assert.Equal(t, OutboxLag{PendingEvents: 120, LagSeconds: 90}, details)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"order/config"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLogs routes the default logger into a buffer for the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(New(config.LogConfig{Level: "info", Format: "json"}, &buf))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestMiddleware(t *testing.T) {
+	orderID := uuid.New()
+	customerID := uuid.New()
+
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/order/{id}", func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "Order fetched", Order(orderID, customerID)...)
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	t.Run("propagates the client's request ID", func(t *testing.T) {
+		buf := captureLogs(t)
+
+		req := httptest.NewRequest("GET", "/order/"+orderID.String(), nil)
+		req.Header.Set(RequestIDHeader, "checkout-42")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, "checkout-42", rr.Header().Get(RequestIDHeader))
+
+		lines := decodeLines(t, buf)
+		if assert.Len(t, lines, 2) {
+			assert.Equal(t, "Order fetched", lines[0]["msg"])
+			assert.Equal(t, "checkout-42", lines[0][RequestIDKey])
+			assert.Equal(t, orderID.String(), lines[0][OrderIDKey])
+			assert.Equal(t, customerID.String(), lines[0][CustomerIDKey])
+
+			assert.Equal(t, "request handled", lines[1]["msg"])
+			assert.Equal(t, "checkout-42", lines[1][RequestIDKey])
+			assert.Equal(t, "/order/{id}", lines[1]["route"])
+			assert.Equal(t, 404.0, lines[1]["status"])
+		}
+	})
+
+	t.Run("assigns an ID when missing or invalid", func(t *testing.T) {
+		for _, header := range []string{"", "has spaces", strings.Repeat("x", maxRequestIDLength+1)} {
+			captureLogs(t)
+
+			req := httptest.NewRequest("GET", "/order/"+orderID.String(), nil)
+			req.Header.Set(RequestIDHeader, header)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			_, err := uuid.Parse(rr.Header().Get(RequestIDHeader))
+			assert.NoError(t, err, "header %q", header)
+		}
+	})
+}
+
+func TestOrder(t *testing.T) {
+	orderID := uuid.New()
+
+	assert.Equal(t, []interface{}{OrderIDKey, orderID.String()}, Order(orderID, uuid.Nil))
+}
+
+func TestContextHandler(t *testing.T) {
+	buf := captureLogs(t)
+
+	slog.InfoContext(WithRequestID(context.Background(), "abc"), "with request")
+	slog.Info("without request")
+
+	lines := decodeLines(t, buf)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "abc", lines[0][RequestIDKey])
+		assert.NotContains(t, lines[1], RequestIDKey)
+	}
+}
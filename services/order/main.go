@@ -4,71 +4,549 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"order/auth"
+	"order/cache"
+	"order/config"
 	"order/database"
+	"order/events"
+	"order/graphqlserver"
+	"order/grpcserver"
 	"order/handlers"
+	"order/health"
+	"order/logging"
+	"order/metrics"
+	"order/migrate"
+	"order/models"
+	"order/openapi"
+	"order/problem"
+	"order/ratelimit"
 	"order/redisconn"
+	"order/repository"
+	"order/service"
+	"order/tracing"
+	"order/webhooks"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
 )
 
 func main() {
 
-	orderServicePort := os.Getenv("ORDER_SERVICE_PORT")
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	slog.SetDefault(logging.New(cfg.Log, os.Stdout).With("service", "order-service"))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, "order-service")
+	if err != nil {
+		fatal("Unable to set up tracing", err)
+	}
+
+	spec, err := openapi.Load()
+	if err != nil {
+		fatal("Unable to load the OpenAPI specification", err)
+	}
+
+	// Stopping while waiting for Postgres or Redis to come up gives up on
+	// them
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := database.Connect(ctx, cfg.DB)
+	if err != nil {
+		fatal("Unable to connect to the database", err)
+	}
+
+	if len(cfg.Args) > 0 {
+		err := runCommand(db, cfg.Args)
+		db.Close()
+		if err != nil {
+			fatal("Command failed", err)
+		}
+		return
+	}
+
+	if cfg.DB.MigrateOnStart {
+		if err := migrateOnStart(db); err != nil {
+			db.Close()
+			fatal("Unable to migrate the database", err)
+		}
+	}
 
-	db, err := database.Connect()
+	if err := metrics.RegisterDB(db, cfg.DB.Name); err != nil {
+		slog.Warn("Unable to export database pool metrics", "error", err)
+	}
+
+	rdb, err := redisconn.Connect(ctx, cfg.Redis)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		db.Close()
+		fatal("Unable to connect to Redis", err)
 	}
-	defer db.Close()
 
-	rdb, err := redisconn.Connect()
+	replicas, err := database.OpenReplicas(cfg.DB)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		rdb.Close()
+		db.Close()
+		fatal("Unable to open the read replicas", err)
+	}
+	for _, replica := range replicas {
+		if err := metrics.RegisterDB(replica.DB, replica.Name); err != nil {
+			slog.Warn("Unable to export replica pool metrics", "replica", replica.Name, "error", err)
+		}
+	}
+	// Records written are read from the primary for as long as a replica
+	// serving reads may be behind: the maximum lag, until the next check
+	cluster := database.NewCluster(db, replicas, database.NewRedisWrites(rdb, cfg.DB.ReplicaMaxLag+cfg.DB.ReplicaCheckInterval), cfg.DB.ReplicaMaxLag)
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	go cluster.Monitor(monitorCtx, cfg.DB.ReplicaCheckInterval)
+
+	authenticator, err := auth.NewAuthenticator(db, cfg.Auth.JWT)
+	if err != nil {
+		stopMonitor()
+		cluster.CloseReplicas()
+		rdb.Close()
+		db.Close()
+		fatal("Unable to set up authentication", err)
+	}
+
+	subscription := redisconn.Subscribe(rdb, "payment_results")
+
+	broker := events.NewBroker()
+
+	repos := repository.NewPostgresCluster(cluster)
+	var orderCache *cache.Orders
+	if cfg.Cache.Enabled {
+		orderCache = cache.NewOrders(repos.Orders, rdb, cfg.Cache.OrderTTL)
+		repos.Orders = orderCache
+		repos.Products = cache.NewProducts(repos.Products, rdb, cfg.Cache.ProductTTL)
+	}
+	// Status changes are made by events.UpdateOrderStatus rather than the
+	// repositories, so they are recorded as they are published, before
+	// anything publishes them. The order is marked written before it leaves
+	// the cache, so it isn't cached again from a replica without the change.
+	broker.OnPublish(func(event models.OrderEvent) {
+		ctx := context.Background()
+		cluster.Written(ctx, repository.OrderKey(event.OrderID), repository.CustomerKey(event.CustomerID))
+		if orderCache != nil {
+			orderCache.Invalidate(ctx, event.OrderID)
+		}
+	})
+
+	resultsDone := make(chan struct{})
+	go func() {
+		defer close(resultsDone)
+		handlePaymentResults(db, subscription.Channel(), broker)
+	}()
+
+	dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+	dispatcherDone := make(chan struct{})
+	dispatcher := webhooks.NewDispatcher(db, &http.Client{Timeout: 10 * time.Second})
+	go func() {
+		defer close(dispatcherDone)
+		dispatcher.Run(dispatcherCtx, broker)
+	}()
+
+	checker := health.NewChecker(cfg.Health.CheckTimeout)
+	checker.Add("database", health.Database(db))
+	checker.Add("redis", health.Redis(rdb))
+	checker.Add("payment_results_subscriber", health.Subscription(subscription))
+	checker.Add("webhook_outbox", health.WebhookOutbox(db, cfg.Health.MaxOutboxLag))
+
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimit.Enabled {
+		limiter = ratelimit.NewLimiter(rdb, cfg.RateLimit)
+	}
+	quota := ratelimit.NewOrderQuota(rdb, cfg.RateLimit.DailyOrderQuota)
+
+	orders := service.NewOrders(repos.Orders, repos.Customers, broker, rdb, quota)
+	products := service.NewProducts(repos.Products)
+	customers := service.NewCustomers(repos.Customers)
+
+	router := setupRouter(cfg, spec, db, broker, orders, products, customers, dispatcher, checker, authenticator, limiter)
+	server := &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.Port),
+		Handler:           router,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+
+	serverErr := make(chan error, 2)
+	go func() {
+		slog.Info("Order Management Service is running", "port", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErr <- err
+		}
+	}()
+
+	var grpcServer *grpc.Server
+	if cfg.GRPCPort != 0 {
+		listener, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.GRPCPort))
+		if err != nil {
+			fatal("Unable to listen for gRPC", err)
+		}
+		grpcServer = grpcserver.New(orders, products, authenticator, limiter, cfg.HTTP.HandlerTimeout)
+		go func() {
+			slog.Info("gRPC API is running", "port", cfg.GRPCPort)
+			if err := grpcServer.Serve(listener); err != nil {
+				serverErr <- err
+			}
+		}()
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down, waiting for in-flight work", "timeout", cfg.ShutdownTimeout.String())
+	case err := <-serverErr:
+		slog.Error("Server failed", "error", err)
+		exitCode = 1
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// The dispatcher is woken by the broker, so stop it before closing the
+	// broker; other instances deliver whatever it leaves pending.
+	stopDispatcher()
+	if err := waitFor(shutdownCtx, dispatcherDone); err != nil {
+		slog.Error("Error waiting for webhook dispatcher", "error", err)
+		exitCode = 1
+	}
+
+	// Event streams never go idle, so end them first to let the server
+	// drain; clients reconnect elsewhere and resume from their last event.
+	broker.Close()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error waiting for in-flight requests", "error", err)
+		exitCode = 1
+	}
+	if grpcServer != nil {
+		if err := stopGRPC(shutdownCtx, grpcServer); err != nil {
+			slog.Error("Error waiting for in-flight gRPC calls", "error", err)
+			exitCode = 1
+		}
+	}
+
+	if err := service.WaitForPublishes(shutdownCtx); err != nil {
+		slog.Error("Error waiting for payment requests to be published", "error", err)
+		exitCode = 1
+	}
+
+	// Closing the subscription closes its channel, after which the results
+	// handler finishes the message it is on and returns.
+	if err := subscription.Close(); err != nil {
+		slog.Error("Error closing payment results subscription", "error", err)
+	}
+	if err := waitFor(shutdownCtx, resultsDone); err != nil {
+		slog.Error("Error waiting for payment results handler", "error", err)
+		exitCode = 1
+	}
+
+	authenticator.Close()
+	stopMonitor()
+	if err := cluster.CloseReplicas(); err != nil {
+		slog.Error("Error closing read replicas", "error", err)
+	}
+	if err := rdb.Close(); err != nil {
+		slog.Error("Error closing Redis client", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("Error closing database", "error", err)
 	}
 
-	channel := redisconn.Subscribe(rdb, "payment_results")
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
 
-	go handlePaymentResults(db, channel)
+	slog.Info("Order Management Service stopped")
+	cancel()
+	os.Exit(exitCode)
+}
 
-	router := setupRouter(db, rdb)
-	fmt.Println("Order Management Service is running on port " + orderServicePort)
-	http.ListenAndServe(":"+orderServicePort, router)
+// fatal logs the error that stops the service from starting and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
 
+// runCommand runs an administrative command instead of serving
+func runCommand(db *sql.DB, args []string) error {
+	switch args[0] {
+	case "create-api-key":
+		return createAPIKey(db, args[1:])
+	case "migrate":
+		return runMigrations(db, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q, expected create-api-key or migrate", args[0])
+	}
 }
 
-func handlePaymentResults(db *sql.DB, channel <-chan *redis.Message) {
+// runMigrations applies, reverts or lists the schema migrations:
+// migrate up, migrate down [-steps N] or migrate status
+func runMigrations(db *sql.DB, args []string) error {
+	migrator, err := migrate.New(db)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("expected migrate up, down or status")
+	}
 	ctx := context.Background()
-	for msg := range channel {
-		var notification map[string]interface{}
-		err := json.Unmarshal([]byte(msg.Payload), &notification)
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		fmt.Printf("Applied %d migrations\n", applied)
+		return err
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := fs.Int("steps", 1, "number of migrations to revert")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		reverted, err := migrator.Down(ctx, *steps)
+		fmt.Printf("Reverted %d migrations\n", reverted)
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+	}
+}
+
+// createAPIKey issues an API key from the command line, to bootstrap access
+func createAPIKey(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("create-api-key", flag.ContinueOnError)
+	name := fs.String("name", "", "name describing who uses the key")
+	role := fs.String("role", models.RoleAdmin, "role of the key: admin, support, customer or service")
+	scopes := fs.String("scopes", "", "comma separated scopes narrowing the role's permissions")
+	customerID := fs.String("customer-id", "", "customer the key acts on behalf of")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	apiKeyWrite := models.APIKeyWrite{Name: *name, Role: *role}
+	if *scopes != "" {
+		apiKeyWrite.Scopes = strings.Split(*scopes, ",")
+	}
+	if *customerID != "" {
+		id, err := uuid.Parse(*customerID)
 		if err != nil {
-			log.Printf("Error unmarshalling payment result notification: %v\n", err)
-			continue
+			return fmt.Errorf("invalid customer ID: %v", err)
 		}
-		orderID := int(notification["order_id"].(float64))
-		status := notification["status"].(string)
+		apiKeyWrite.CustomerID = &id
+	}
+	if err := service.Validate(apiKeyWrite); err != nil {
+		return err
+	}
+
+	apiKeyRead, err := auth.CreateAPIKey(context.Background(), db, apiKeyWrite)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s API key %s (%s)\n%s\n", apiKeyRead.Role, apiKeyRead.ID, apiKeyRead.Name, apiKeyRead.Key)
+	return nil
+}
+
+// migrateOnStart applies pending migrations; replicas starting together
+// wait for each other on the migration lock.
+func migrateOnStart(db *sql.DB) error {
+	migrator, err := migrate.New(db)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(context.Background())
+	if applied > 0 {
+		slog.Info("Database migrated", "applied", applied)
+	}
+	return err
+}
+
+// stopGRPC stops the gRPC server once its in-flight calls finish, or
+// cancels them when ctx is done
+func stopGRPC(ctx context.Context, server *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	if err := waitFor(ctx, stopped); err != nil {
+		server.Stop()
+		return err
+	}
+	return nil
+}
+
+// waitFor blocks until done is closed or ctx is done
+func waitFor(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// paymentResult is the message the payment service publishes on
+// payment_results
+type paymentResult struct {
+	MessageID    string            `json:"message_id"`
+	RequestID    string            `json:"request_id"`
+	OrderID      string            `json:"order_id"`
+	CustomerID   string            `json:"customer_id"`
+	Status       string            `json:"status"`
+	SentAt       time.Time         `json:"sent_at"`
+	TraceContext map[string]string `json:"trace_context"`
+}
+
+func handlePaymentResults(db *sql.DB, channel <-chan *redis.Message, broker *events.Broker) {
+	for msg := range channel {
+		sentAt, err := applyPaymentResult(db, msg, broker)
+		metrics.Consume(msg.Channel, sentAt, err)
+	}
+}
 
-		_, err = db.ExecContext(ctx, "UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2", status, orderID)
+// applyPaymentResult resolves the order named by the payment result and
+// returns when the result was sent, if known.
+func applyPaymentResult(db *sql.DB, msg *redis.Message, broker *events.Broker) (sentAt time.Time, err error) {
+	var result paymentResult
+	if err := json.Unmarshal([]byte(msg.Payload), &result); err != nil {
+		slog.Error("Error unmarshalling payment result", "channel", msg.Channel, "error", err)
+		return time.Time{}, err
+	}
+
+	// The request ID is that of the request which created the order, so
+	// its whole journey can be found by it.
+	ctx, span := tracing.StartConsume(logging.WithRequestID(context.Background(), result.RequestID), msg.Channel, result.TraceContext)
+	span.SetAttributes(attribute.String("order_id", result.OrderID), attribute.String("payment.status", result.Status))
+	attrs := []interface{}{logging.MessageIDKey, result.MessageID, logging.OrderIDKey, result.OrderID, logging.CustomerIDKey, result.CustomerID, "payment_status", result.Status}
+	defer func() {
+		tracing.End(span, err)
 		if err != nil {
-			log.Printf("Error updating order status in order service: %v\n", err)
-			continue
+			slog.ErrorContext(ctx, "Error applying payment result", append(attrs, "error", err)...)
 		}
+	}()
+
+	orderID, err := uuid.Parse(result.OrderID)
+	if err != nil {
+		return result.SentAt, fmt.Errorf("unable to parse order ID in payment result notification: %v", err)
+	}
+	status, ok := models.OrderStatusFromPayment(result.Status)
+	if !ok {
+		return result.SentAt, fmt.Errorf("unknown payment result %q for order %s", result.Status, orderID)
+	}
+
+	// Only pending orders are resolved by a payment result; anything
+	// further along is owned by fulfilment.
+	event, err := events.UpdateOrderStatus(ctx, db, orderID, status, models.Pending)
+	if err != nil {
+		return result.SentAt, fmt.Errorf("unable to update status of order %s: %v", orderID, err)
 	}
+	if event != nil {
+		broker.Publish(*event)
+		slog.InfoContext(ctx, "Payment result applied", append(attrs, "order_status", event.Status)...)
+	} else {
+		slog.InfoContext(ctx, "Payment result ignored, order is no longer pending", attrs...)
+	}
+	return result.SentAt, nil
 }
 
-func setupRouter(db *sql.DB, rdb *redis.Client) *mux.Router {
+func setupRouter(cfg *config.Config, spec *openapi.Spec, db *sql.DB, broker *events.Broker, orders *service.Orders, products *service.Products, customers *service.Customers, dispatcher *webhooks.Dispatcher, checker *health.Checker, authenticator *auth.Authenticator, limiter *ratelimit.Limiter) *mux.Router {
 	router := mux.NewRouter()
+	router.NotFoundHandler = problem.NotFoundHandler()
+	router.MethodNotAllowedHandler = problem.MethodNotAllowedHandler()
+	router.Use(otelmux.Middleware("order-service"), logging.Middleware, metrics.Middleware)
+
+	router.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	router.HandleFunc("/healthz", handlers.HealthzHandler()).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", handlers.ReadyzHandler(checker)).Methods(http.MethodGet)
+	router.Handle("/openapi.json", spec.Handler()).Methods(http.MethodGet)
+	router.PathPrefix("/docs/").Handler(http.StripPrefix("/docs/", openapi.DocsHandler())).Methods(http.MethodGet)
+
+	// Everything else needs credentials whose role grants the route's
+	// permission; see models.RolePermissions. IP addresses that keep failing
+	// to authenticate are turned away first. Each principal is then rate
+	// limited, and its requests checked against the OpenAPI specification.
+	api := router.NewRoute().Subrouter()
+	if limiter != nil {
+		api.Use(limiter.AuthenticationMiddleware)
+	}
+	api.Use(authenticator.Middleware)
+	if limiter != nil {
+		api.Use(limiter.Middleware)
+	}
+	api.Use(spec.Middleware)
+	// Requests get cfg.HTTP.HandlerTimeout to complete, except event
+	// streams, which stay open until the client leaves.
+	stream := func(path, method, permission string, h http.HandlerFunc) {
+		api.Handle(path, auth.Require(permission, h)).Methods(method)
+	}
+	handle := func(path, method, permission string, h http.HandlerFunc) {
+		api.Handle(path, handlers.WithTimeout(cfg.HTTP.HandlerTimeout, auth.Require(permission, h))).Methods(method)
+	}
 
-	router.HandleFunc("/product", handlers.CreateProductHandler(db)).Methods(http.MethodPost)
-	router.HandleFunc("/product/{id}", handlers.GetProductHandler(db)).Methods(http.MethodGet)
-	router.HandleFunc("/order", handlers.CreateOrderHandler(db, rdb)).Methods(http.MethodPost)
-	router.HandleFunc("/order/{id}", handlers.GetOrderHandler(db)).Methods(http.MethodGet)
+	handle("/product", http.MethodPost, models.ScopeProductsWrite, handlers.CreateProductHandler(products))
+	handle("/product/{id}", http.MethodGet, models.ScopeProductsRead, handlers.GetProductHandler(products))
+	handle("/product/{id}", http.MethodPut, models.ScopeProductsWrite, handlers.UpdateProductHandler(products))
+	handle("/customer/{customer_id}/address", http.MethodPost, models.ScopeCustomersWrite, handlers.CreateAddressHandler(db))
+	handle("/customer/{customer_id}/address", http.MethodGet, models.ScopeCustomersRead, handlers.ListAddressesHandler(db))
+	handle("/customer/{customer_id}/address/{id}", http.MethodGet, models.ScopeCustomersRead, handlers.GetAddressHandler(db))
+	handle("/customer/{customer_id}/address/{id}", http.MethodPut, models.ScopeCustomersWrite, handlers.UpdateAddressHandler(db))
+	handle("/customer/{customer_id}/address/{id}", http.MethodDelete, models.ScopeCustomersWrite, handlers.DeleteAddressHandler(db))
+	stream("/customer/{customer_id}/events", http.MethodGet, models.ScopeOrdersRead, handlers.CustomerEventsHandler(orders))
+	handle("/order", http.MethodPost, models.ScopeOrdersWrite, handlers.CreateOrderHandler(orders))
+	handle("/order/{id}", http.MethodGet, models.ScopeOrdersRead, handlers.GetOrderHandler(orders))
+	handle("/orders", http.MethodGet, models.ScopeOrdersRead, handlers.ListOrdersHandler(orders))
+	handle("/orders/bulk", http.MethodPost, models.ScopeOrdersWrite, handlers.BulkCreateOrdersHandler(orders))
+	stream("/order/{id}/events", http.MethodGet, models.ScopeOrdersRead, handlers.OrderEventsHandler(orders))
+	handle("/order/{id}/shipment", http.MethodPost, models.ScopeFulfilment, handlers.CreateShipmentHandler(db, broker))
+	handle("/order/{id}/shipment", http.MethodGet, models.ScopeOrdersRead, handlers.ListShipmentsHandler(db))
+	handle("/shipment/{id}", http.MethodGet, models.ScopeOrdersRead, handlers.GetShipmentHandler(db))
+	handle("/shipment/{id}/event", http.MethodPost, models.ScopeFulfilment, handlers.CreateShipmentEventHandler(db, broker))
+	handle("/webhooks", http.MethodPost, models.ScopeWebhooks, handlers.CreateWebhookHandler(db))
+	handle("/webhooks", http.MethodGet, models.ScopeWebhooks, handlers.ListWebhooksHandler(db))
+	handle("/webhooks/{id}", http.MethodGet, models.ScopeWebhooks, handlers.GetWebhookHandler(db))
+	handle("/webhooks/{id}", http.MethodPut, models.ScopeWebhooks, handlers.UpdateWebhookHandler(db))
+	handle("/webhooks/{id}", http.MethodDelete, models.ScopeWebhooks, handlers.DeleteWebhookHandler(db))
+	handle("/webhooks/{id}/deliveries", http.MethodGet, models.ScopeWebhooks, handlers.ListWebhookDeliveriesHandler(db))
+	handle("/webhooks/{id}/test", http.MethodPost, models.ScopeWebhooks, handlers.TestWebhookHandler(dispatcher))
+	handle("/api-keys", http.MethodPost, models.ScopeAPIKeys, handlers.CreateAPIKeyHandler(db))
+	handle("/api-keys", http.MethodGet, models.ScopeAPIKeys, handlers.ListAPIKeysHandler(db))
+	handle("/api-keys/{id}", http.MethodDelete, models.ScopeAPIKeys, handlers.RevokeAPIKeyHandler(db))
+	// GraphQL checks the permission of each field it resolves
+	api.Handle("/graphql", handlers.WithTimeout(cfg.HTTP.HandlerTimeout, auth.Authenticated(graphqlserver.Handler(orders, products, customers)))).Methods(http.MethodPost)
 
 	return router
 }
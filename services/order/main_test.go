@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"order/config"
+	"order/openapi"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRoutesMatchSpec fails when a route is added to or removed from the
+// router without updating openapi/openapi.yaml, or the other way round.
+func TestRoutesMatchSpec(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := setupRouter(&config.Config{}, spec, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	// Swagger UI is how the spec is browsed rather than part of the API
+	undocumented := map[string]bool{"/docs/": true}
+
+	var routed []string
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || undocumented[path] {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routed = append(routed, method+" "+path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var specified []string
+	for path, item := range spec.Doc.Paths.Map() {
+		for method := range item.Operations() {
+			specified = append(specified, method+" "+path)
+		}
+	}
+
+	sort.Strings(routed)
+	sort.Strings(specified)
+	assert.Equal(t, specified, routed)
+}
+
+func TestDocs(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := setupRouter(&config.Config{}, spec, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	for path, contentType := range map[string]string{
+		"/openapi.json":              "application/json",
+		"/docs/":                     "text/html",
+		"/docs/swagger-ui-bundle.js": "javascript",
+		"/docs/swagger-ui.css":       "text/css",
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+		assert.True(t, strings.Contains(rr.Header().Get("Content-Type"), contentType), path)
+	}
+}
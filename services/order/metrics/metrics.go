@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "order_service"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route template, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route template, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "gRPC calls handled, by full method name and status code.",
+	}, []string{"method", "code"})
+
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Time taken to handle gRPC calls, by full method name and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	// OrdersCreated counts orders created through the API by their initial
+	// status.
+	OrdersCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_created_total",
+		Help:      "Orders created, by initial status.",
+	}, []string{"status"})
+
+	// OrderStatusChanges counts order status transitions by the status moved
+	// to.
+	OrderStatusChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "order_status_changes_total",
+		Help:      "Order status transitions, by new status.",
+	}, []string{"status"})
+
+	// RateLimited counts requests rejected for exceeding the client's rate
+	// limit, by method and route template.
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected by the rate limiter, by route.",
+	}, []string{"route"})
+
+	// OrderQuotaExceeded counts orders refused because their customer used
+	// up the daily quota.
+	OrderQuotaExceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "order_quota_exceeded_total",
+		Help:      "Orders refused by the daily order quota.",
+	})
+
+	// MessagesPublished counts Redis messages published by channel and
+	// result, "ok" or "error".
+	MessagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_published_total",
+		Help:      "Redis messages published, by channel and result.",
+	}, []string{"channel", "result"})
+
+	// MessagesConsumed counts Redis messages received by channel and result,
+	// "ok" or "error".
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Redis messages consumed, by channel and result.",
+	}, []string{"channel", "result"})
+
+	// Resubscriptions counts Redis subscriptions lost and subscribed again,
+	// by channel.
+	Resubscriptions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_resubscriptions_total",
+		Help:      "Redis subscriptions lost and subscribed again, by channel.",
+	}, []string{"channel"})
+
+	// CacheRequests counts reads through the cache by cache and result:
+	// "hit", "miss", or "error" when Redis couldn't be used.
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Reads through the Redis cache, by cache and result.",
+	}, []string{"cache", "result"})
+
+	// DBReads counts reads of the repositories by the database they were
+	// sent to, "primary" or "replica".
+	DBReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_reads_total",
+		Help:      "Repository reads, by the database they were sent to.",
+	}, []string{"target"})
+
+	// DBReplicaHealthy is 1 for read replicas serving reads, and 0 for those
+	// left out of reads.
+	DBReplicaHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "db_replica_healthy",
+		Help:      "Whether the read replica serves reads, by replica.",
+	}, []string{"replica"})
+
+	// DBReplicaLag is the replication lag of each read replica at its last
+	// check.
+	DBReplicaLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "db_replica_lag_seconds",
+		Help:      "Replication lag of the read replica at its last check, by replica.",
+	}, []string{"replica"})
+
+	// MessageLag observes the time between a message being sent and received
+	MessageLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "message_lag_seconds",
+		Help:      "Time between a Redis message being published and consumed, by channel.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 30, 60},
+	}, []string{"channel"})
+)
+
+// GRPC records a handled gRPC call by its full method name and status code
+func GRPC(method, code string, duration time.Duration) {
+	grpcRequests.WithLabelValues(method, code).Inc()
+	grpcDuration.WithLabelValues(method, code).Observe(duration.Seconds())
+}
+
+// Publish records the outcome of publishing to channel
+func Publish(channel string, err error) {
+	MessagesPublished.WithLabelValues(channel, result(err)).Inc()
+}
+
+// Consume records the outcome of consuming a message from channel, and its
+// lag if the time it was sent is known.
+func Consume(channel string, sentAt time.Time, err error) {
+	MessagesConsumed.WithLabelValues(channel, result(err)).Inc()
+	if !sentAt.IsZero() {
+		MessageLag.WithLabelValues(channel).Observe(time.Since(sentAt).Seconds())
+	}
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// RegisterDB exports the connection pool statistics of db
+func RegisterDB(db *sql.DB, name string) error {
+	err := prometheus.Register(collectors.NewDBStatsCollector(db, name))
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		return nil
+	}
+	return err
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records the count and latency of every request handled by the
+// router, labelled with the matched route template so that IDs in the path
+// don't each get their own series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+
+		status := strconv.Itoa(recorder.status)
+		httpRequests.WithLabelValues(route, r.Method, status).Inc()
+		httpDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder captures the response status. It passes Flush and Hijack
+// through so that event streams keep working behind it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
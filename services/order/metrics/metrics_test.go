@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/order/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/order/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Flusher)
+		assert.True(t, ok, "event streams need the response writer to flush")
+		w.Write([]byte("data: {}\n\n"))
+	}).Methods(http.MethodGet)
+
+	for _, path := range []string{"/order/1", "/order/2", "/order/3/events"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(httpRequests.WithLabelValues("/order/{id}", "GET", "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues("/order/{id}/events", "GET", "200")))
+}
+
+func TestMessages(t *testing.T) {
+	Publish("test_channel", nil)
+	Publish("test_channel", errors.New("connection refused"))
+	Consume("test_channel", time.Now().Add(-time.Second), nil)
+	Consume("test_channel", time.Time{}, errors.New("bad payload"))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(MessagesPublished.WithLabelValues("test_channel", "ok")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(MessagesPublished.WithLabelValues("test_channel", "error")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(MessagesConsumed.WithLabelValues("test_channel", "ok")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(MessagesConsumed.WithLabelValues("test_channel", "error")))
+	assert.Equal(t, 1, testutil.CollectAndCount(MessageLag))
+}
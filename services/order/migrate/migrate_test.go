@@ -0,0 +1,249 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("embedded migrations", func(t *testing.T) {
+		migrations, err := Load(embedded)
+		assert.NoError(t, err)
+		if assert.NotEmpty(t, migrations) {
+			assert.Equal(t, int64(1), migrations[0].Version)
+			assert.Equal(t, "baseline", migrations[0].Name)
+		}
+		for i := 1; i < len(migrations); i++ {
+			assert.Less(t, migrations[i-1].Version, migrations[i].Version)
+		}
+	})
+
+	t.Run("ordered by version", func(t *testing.T) {
+		migrations, err := Load(fstest.MapFS{
+			"migrations/0010_later.up.sql":   {Data: []byte("up 10")},
+			"migrations/0010_later.down.sql": {Data: []byte("down 10")},
+			"migrations/0002_first.up.sql":   {Data: []byte("up 2")},
+			"migrations/0002_first.down.sql": {Data: []byte("down 2")},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []Migration{
+			{Version: 2, Name: "first", Up: "up 2", Down: "down 2"},
+			{Version: 10, Name: "later", Up: "up 10", Down: "down 10"},
+		}, migrations)
+	})
+
+	invalid := map[string]fstest.MapFS{
+		"missing down": {"migrations/0001_a.up.sql": {Data: []byte("up")}},
+		"bad name":     {"migrations/first.up.sql": {Data: []byte("up")}},
+		"mismatched names": {
+			"migrations/0001_a.up.sql":   {Data: []byte("up")},
+			"migrations/0001_b.down.sql": {Data: []byte("down")},
+		},
+	}
+	for name, fsys := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func newMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &Migrator{db: db, migrations: []Migration{
+		{Version: 1, Name: "baseline", Up: "CREATE TABLE one", Down: "DROP TABLE one"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE two", Down: "DROP TABLE two"},
+	}}, mock
+}
+
+func expectLock(mock sqlmock.Sqlmock, applied ...int64) {
+	mock.ExpectExec("SET statement_timeout = 0").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, version := range applied {
+		rows.AddRow(version, time.Now())
+	}
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(rows)
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RESET statement_timeout").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestUp(t *testing.T) {
+	t.Run("applies pending migrations", func(t *testing.T) {
+		migrator, mock := newMigrator(t)
+
+		expectLock(mock, 1)
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE two").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(int64(2), "second").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		expectUnlock(mock)
+
+		applied, err := migrator.Up(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed migration is rolled back", func(t *testing.T) {
+		migrator, mock := newMigrator(t)
+
+		expectLock(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE one").WillReturnError(sqlmock.ErrCancelled)
+		mock.ExpectRollback()
+		expectUnlock(mock)
+
+		applied, err := migrator.Up(context.Background())
+		assert.ErrorContains(t, err, "migration 1_baseline failed")
+		assert.Equal(t, 0, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("nothing pending", func(t *testing.T) {
+		migrator, mock := newMigrator(t)
+
+		// Version 3 comes from a newer release and is left alone
+		expectLock(mock, 1, 2, 3)
+		expectUnlock(mock)
+
+		applied, err := migrator.Up(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDown(t *testing.T) {
+	migrator, mock := newMigrator(t)
+
+	expectLock(mock, 1, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP TABLE two").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations WHERE version = \\$1").WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	reverted, err := migrator.Down(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// postgres returns the throwaway database named by MIGRATE_TEST_DATABASE_URL,
+// emptied, skipping the test if there is none. Everything in it is dropped.
+func postgres(t *testing.T) *sql.DB {
+	dsn := os.Getenv("MIGRATE_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("MIGRATE_TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when emptying the database", err)
+	}
+	return db
+}
+
+// assertCurrentSchema writes to every part of the schema the service uses
+func assertCurrentSchema(t *testing.T, db *sql.DB) {
+	ctx := context.Background()
+	var customerID, productID, orderID string
+	assert.NoError(t, db.QueryRowContext(ctx, "SELECT id FROM customers LIMIT 1").Scan(&customerID))
+	assert.NoError(t, db.QueryRowContext(ctx, "SELECT id FROM products LIMIT 1").Scan(&productID))
+
+	var addressID string
+	assert.NoError(t, db.QueryRowContext(ctx,
+		"INSERT INTO customer_addresses (customer_id, type, line1, city, postal_code, country, is_default) VALUES ($1, 'shipping', '1 Main St', 'Toronto', 'M5V 1A1', 'CA', true) RETURNING id",
+		customerID).Scan(&addressID))
+	assert.NoError(t, db.QueryRowContext(ctx,
+		`INSERT INTO orders (customer_id, product_id, status, amount, shipping_address_id, shipping_address) VALUES ($1, $2, 'awaiting_shipment', 10, $3, '{"line1": "1 Main St"}') RETURNING id`,
+		customerID, productID, addressID).Scan(&orderID))
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE orders SET status = 'shipped' WHERE id = $1", []interface{}{orderID}},
+		{"INSERT INTO order_status_history (order_id, customer_id, status) VALUES ($1, $2, 'delivered')", []interface{}{orderID, customerID}},
+		{"WITH s AS (INSERT INTO shipments (order_id) VALUES ($1) RETURNING id) INSERT INTO shipment_events (shipment_id, status, occurred_at) SELECT id, 'shipped', NOW() FROM s", []interface{}{orderID}},
+		{"INSERT INTO webhooks (url, secret, event_types) VALUES ('https://example.com', 'secret', '[\"order.shipped\"]')", nil},
+		{"UPDATE order_status_history SET webhooks_enqueued = TRUE", nil},
+		{"INSERT INTO api_keys (name, key_prefix, key_hash, role) VALUES ('admin', 'ok_', 'hash', 'admin')", nil},
+	}
+	for _, statement := range statements {
+		_, err := db.ExecContext(ctx, statement.query, statement.args...)
+		assert.NoError(t, err, statement.query)
+	}
+}
+
+func TestPostgres(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("database created by init.sql", func(t *testing.T) {
+		db := postgres(t)
+		initSQL, err := os.ReadFile("testdata/init.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(initSQL)); err != nil {
+			t.Fatalf("an error '%s' was not expected when running init.sql", err)
+		}
+		_, err = db.Exec("INSERT INTO orders (customer_id, product_id, status, amount) SELECT c.id, p.id, 'completed', 99.99 FROM customers c, products p")
+		assert.NoError(t, err)
+
+		migrator, err := New(db)
+		assert.NoError(t, err)
+		applied, err := migrator.Up(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, len(migrator.migrations), applied)
+		assertCurrentSchema(t, db)
+
+		var customers, orders int
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM customers").Scan(&customers))
+		assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM orders WHERE status = 'completed'").Scan(&orders))
+		assert.Equal(t, 1, customers, "the sample customer isn't inserted again")
+		assert.Equal(t, 1, orders)
+	})
+
+	t.Run("new database, migrated down and up again", func(t *testing.T) {
+		db := postgres(t)
+		migrator, err := New(db)
+		assert.NoError(t, err)
+
+		applied, err := migrator.Up(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, len(migrator.migrations), applied)
+		assertCurrentSchema(t, db)
+
+		reverted, err := migrator.Down(ctx, len(migrator.migrations))
+		assert.NoError(t, err)
+		assert.Equal(t, len(migrator.migrations), reverted)
+
+		_, err = migrator.Up(ctx)
+		assert.NoError(t, err)
+		assertCurrentSchema(t, db)
+	})
+}
@@ -0,0 +1,5 @@
+-- Drops the baseline schema; the uuid-ossp extension is left in place
+DROP TABLE IF EXISTS orders;
+DROP TYPE IF EXISTS order_status;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS customers;
@@ -0,0 +1,4 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_address;
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_address_id;
+DROP TABLE IF EXISTS customer_addresses;
+DROP TYPE IF EXISTS address_type;
@@ -0,0 +1,46 @@
+-- Define the enum type for customer address types
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'address_type') THEN
+        CREATE TYPE address_type AS ENUM ('billing', 'shipping');
+    END IF;
+END $$;
+
+-- Create customer_addresses table; a customer may have many addresses of each type
+CREATE TABLE IF NOT EXISTS customer_addresses (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    customer_id UUID NOT NULL,
+    type address_type NOT NULL,
+    line1 TEXT NOT NULL,
+    line2 TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL,
+    region TEXT NOT NULL DEFAULT '',
+    postal_code TEXT NOT NULL,
+    country TEXT NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_customer
+        FOREIGN KEY(customer_id)
+        REFERENCES customers(id)
+        ON DELETE CASCADE
+);
+
+-- At most one default address per customer and type
+CREATE UNIQUE INDEX IF NOT EXISTS customer_addresses_default_idx
+    ON customer_addresses (customer_id, type)
+    WHERE is_default;
+
+-- Snapshot the shipping address onto orders, keeping a reference to the address it was taken from
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_address_id UUID;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_address JSONB;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_shipping_address' AND conrelid = 'orders'::regclass) THEN
+        ALTER TABLE orders ADD CONSTRAINT fk_shipping_address
+            FOREIGN KEY(shipping_address_id)
+            REFERENCES customer_addresses(id)
+            ON DELETE SET NULL;
+    END IF;
+END $$;
@@ -0,0 +1,45 @@
+-- Fulfilment statuses of paid orders
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'awaiting_shipment';
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'shipped';
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'delivered';
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'returned';
+
+-- Define the enum type for shipment status
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'shipment_status') THEN
+        CREATE TYPE shipment_status AS ENUM ('awaiting_shipment', 'shipped', 'delivered', 'returned');
+    END IF;
+END $$;
+
+-- Create shipments table; an order may be fulfilled by several shipments
+CREATE TABLE IF NOT EXISTS shipments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL,
+    status shipment_status NOT NULL DEFAULT 'awaiting_shipment',
+    carrier TEXT NOT NULL DEFAULT '',
+    tracking_number TEXT NOT NULL DEFAULT '',
+    shipped_at TIMESTAMP,
+    delivered_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_order
+        FOREIGN KEY(order_id)
+        REFERENCES orders(id)
+);
+
+CREATE INDEX IF NOT EXISTS shipments_order_id_idx ON shipments (order_id);
+
+-- Create shipment_events table recording every state a shipment went through
+CREATE TABLE IF NOT EXISTS shipment_events (
+    id BIGSERIAL PRIMARY KEY,
+    shipment_id UUID NOT NULL,
+    status shipment_status NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    occurred_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_shipment
+        FOREIGN KEY(shipment_id)
+        REFERENCES shipments(id)
+        ON DELETE CASCADE
+);
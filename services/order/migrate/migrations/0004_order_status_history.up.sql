@@ -0,0 +1,14 @@
+-- Create order_status_history table; its IDs are the event IDs of the order status streams
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    order_id UUID NOT NULL,
+    customer_id UUID NOT NULL,
+    status order_status NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_order
+        FOREIGN KEY(order_id)
+        REFERENCES orders(id)
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_id_idx ON order_status_history (order_id, id);
+CREATE INDEX IF NOT EXISTS order_status_history_customer_id_idx ON order_status_history (customer_id, id);
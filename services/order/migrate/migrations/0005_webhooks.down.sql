@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS webhook_cursor;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TYPE IF EXISTS delivery_status;
+DROP TABLE IF EXISTS webhooks;
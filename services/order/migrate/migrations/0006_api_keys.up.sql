@@ -0,0 +1,17 @@
+-- Create api_keys table; only the SHA-256 of each key is stored, role is one of admin, support, customer or service, and scopes is a JSON array such as "orders:read" narrowing the role's permissions (empty for all of them)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name TEXT NOT NULL,
+    key_prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    role TEXT NOT NULL CHECK (role IN ('admin', 'support', 'customer', 'service')),
+    scopes JSONB NOT NULL DEFAULT '[]',
+    customer_id UUID,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP,
+    CHECK (role <> 'customer' OR customer_id IS NOT NULL),
+    CONSTRAINT fk_customer
+        FOREIGN KEY(customer_id)
+        REFERENCES customers(id)
+        ON DELETE CASCADE
+);
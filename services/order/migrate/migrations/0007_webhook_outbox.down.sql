@@ -0,0 +1,15 @@
+-- The cursor resumes before the oldest event not yet enqueued
+CREATE TABLE IF NOT EXISTS webhook_cursor (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    last_event_id BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO webhook_cursor (last_event_id)
+    SELECT COALESCE(
+        (SELECT MIN(id) - 1 FROM order_status_history WHERE NOT webhooks_enqueued),
+        (SELECT MAX(id) FROM order_status_history),
+        0)
+    ON CONFLICT DO NOTHING;
+
+DROP INDEX IF EXISTS order_status_history_webhooks_pending_idx;
+ALTER TABLE order_status_history DROP COLUMN IF EXISTS webhooks_enqueued;
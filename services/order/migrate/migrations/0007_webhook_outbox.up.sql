@@ -0,0 +1,14 @@
+-- Events are marked once their webhook deliveries are enqueued, replacing
+-- the cursor over event IDs: IDs are taken when a change is made but become
+-- visible when it commits, so a cursor could move past an event committed
+-- after one with a higher ID and never enqueue it.
+ALTER TABLE order_status_history ADD COLUMN IF NOT EXISTS webhooks_enqueued BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE order_status_history SET webhooks_enqueued = TRUE
+    WHERE id <= (SELECT last_event_id FROM webhook_cursor);
+
+CREATE INDEX IF NOT EXISTS order_status_history_webhooks_pending_idx
+    ON order_status_history (id)
+    WHERE NOT webhooks_enqueued;
+
+DROP TABLE IF EXISTS webhook_cursor;
@@ -46,4 +46,4 @@ CREATE TABLE IF NOT EXISTS orders (
 
 -- Insert initial data
 INSERT INTO customers (id, name) VALUES (uuid_generate_v4(), 'John Doe') ON CONFLICT DO NOTHING;
-INSERT INTO products (id, name, price) VALUES (uuid_generate_v4(), 'Sample Product', 99.99) ON CONFLICT DO NOTHING;
\ No newline at end of file
+INSERT INTO products (id, name, price) VALUES (uuid_generate_v4(), 'Sample Product', 99.99) ON CONFLICT DO NOTHING;
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AddressType string
+
+const (
+	Billing  AddressType = "billing"
+	Shipping AddressType = "shipping"
+)
+
+// AddressWrite represents a customer address for creating or updating
+type AddressWrite struct {
+	Type       AddressType `json:"type" validate:"required,oneof=billing shipping"`
+	Line1      string      `json:"line1" validate:"required"`
+	Line2      string      `json:"line2"`
+	City       string      `json:"city" validate:"required"`
+	Region     string      `json:"region"`
+	PostalCode string      `json:"postal_code" validate:"required"`
+	Country    string      `json:"country" validate:"required,iso3166_1_alpha2"`
+	IsDefault  bool        `json:"is_default"`
+}
+
+// AddressRead represents a customer address for reading
+type AddressRead struct {
+	ID         uuid.UUID   `json:"id"`
+	CustomerID uuid.UUID   `json:"customer_id"`
+	Type       AddressType `json:"type"`
+	Line1      string      `json:"line1"`
+	Line2      string      `json:"line2"`
+	City       string      `json:"city"`
+	Region     string      `json:"region"`
+	PostalCode string      `json:"postal_code"`
+	Country    string      `json:"country"`
+	IsDefault  bool        `json:"is_default"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// AddressSnapshot is the copy of a shipping address stored on an order, so
+// that later edits to the customer's address book don't rewrite history.
+type AddressSnapshot struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+// Snapshot returns the parts of the address that are copied onto an order
+func (a AddressRead) Snapshot() AddressSnapshot {
+	return AddressSnapshot{
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		Region:     a.Region,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+	}
+}
+
+// Value stores the snapshot in a JSONB column
+func (s AddressSnapshot) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+// Scan reads the snapshot from a JSONB column
+func (s *AddressSnapshot) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		return fmt.Errorf("cannot scan %T into AddressSnapshot", src)
+	}
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes grant access to groups of endpoints
+const (
+	ScopeProductsRead   = "products:read"
+	ScopeProductsWrite  = "products:write"
+	ScopeOrdersRead     = "orders:read"
+	ScopeOrdersWrite    = "orders:write"
+	ScopeCustomersRead  = "customers:read"
+	ScopeCustomersWrite = "customers:write"
+	ScopeFulfilment     = "fulfilment:write"
+	ScopeWebhooks       = "webhooks:manage"
+	ScopeAPIKeys        = "api_keys:manage"
+)
+
+// AllScopes lists every scope, in the order they are documented
+var AllScopes = []string{
+	ScopeProductsRead, ScopeProductsWrite,
+	ScopeOrdersRead, ScopeOrdersWrite,
+	ScopeCustomersRead, ScopeCustomersWrite,
+	ScopeFulfilment, ScopeWebhooks, ScopeAPIKeys,
+}
+
+// Scopes is the list of scopes granted to an API key
+type Scopes []string
+
+// Value stores the scopes in a JSONB column
+func (s Scopes) Value() (driver.Value, error) {
+	if s == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(s)
+}
+
+// Scan reads the scopes from a JSONB column
+func (s *Scopes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		return fmt.Errorf("cannot scan %T into Scopes", src)
+	}
+}
+
+// APIKeyWrite represents an API key for creating. The key gets the
+// permissions of its role, narrowed to Scopes if any are given. Customer
+// keys must name the customer they act on behalf of.
+type APIKeyWrite struct {
+	Name       string     `json:"name" validate:"required,max=100"`
+	Role       string     `json:"role" validate:"required,oneof=admin support customer service"`
+	Scopes     Scopes     `json:"scopes" validate:"omitempty,dive,oneof=products:read products:write orders:read orders:write customers:read customers:write fulfilment:write webhooks:manage api_keys:manage"`
+	CustomerID *uuid.UUID `json:"customer_id" validate:"required_if=Role customer"`
+}
+
+// APIKeyRead represents an API key for reading. The key itself is only
+// returned when it is created; afterwards only its prefix identifies it.
+type APIKeyRead struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Role       string     `json:"role"`
+	Scopes     Scopes     `json:"scopes"`
+	CustomerID *uuid.UUID `json:"customer_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
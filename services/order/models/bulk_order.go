@@ -0,0 +1,22 @@
+package models
+
+import "github.com/google/uuid"
+
+// BulkOrderResult reports the outcome of one row of a bulk import. Row is the
+// 1-based position of the order in the request, not counting a CSV header.
+// Rows that failed carry the error code and, if they failed validation, the
+// invalid fields.
+type BulkOrderResult struct {
+	Row    int          `json:"row"`
+	ID     *uuid.UUID   `json:"id,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Code   ErrorCode    `json:"code,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// BulkOrderReport is the response to a bulk import
+type BulkOrderReport struct {
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Results []BulkOrderResult `json:"results"`
+}
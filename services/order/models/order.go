@@ -9,23 +9,60 @@ import (
 type OrderStatus string
 
 const (
-	Pending   OrderStatus = "pending"
-	Completed OrderStatus = "completed"
-	Failed    OrderStatus = "failed"
+	Pending          OrderStatus = "pending"
+	Completed        OrderStatus = "completed"
+	Failed           OrderStatus = "failed"
+	AwaitingShipment OrderStatus = "awaiting_shipment"
+	Shipped          OrderStatus = "shipped"
+	Delivered        OrderStatus = "delivered"
+	Returned         OrderStatus = "returned"
 )
 
+// Payment results as published by the payment service
+const (
+	PaymentSuccess = "success"
+	PaymentFailure = "failure"
+)
+
+// OrderStatusFromPayment maps a payment result onto the order status it
+// resolves a pending order to.
+func OrderStatusFromPayment(result string) (OrderStatus, bool) {
+	switch result {
+	case PaymentSuccess:
+		return Completed, true
+	case PaymentFailure:
+		return Failed, true
+	default:
+		return "", false
+	}
+}
+
+// Paid reports whether an order in this status has been paid for, and so
+// can have shipments attached to it.
+func (s OrderStatus) Paid() bool {
+	switch s {
+	case Completed, AwaitingShipment, Shipped, Delivered, Returned:
+		return true
+	default:
+		return false
+	}
+}
+
 type OrderWrite struct {
-	CustomerID uuid.UUID `json:"customer_id" validate:"required"`
-	ProductID  uuid.UUID `json:"product_id" validate:"required"`
-	Amount     float64   `json:"amount" validate:"required"`
+	CustomerID        uuid.UUID  `json:"customer_id" validate:"required"`
+	ProductID         uuid.UUID  `json:"product_id" validate:"required"`
+	Amount            float64    `json:"amount" validate:"required"`
+	ShippingAddressID *uuid.UUID `json:"shipping_address_id,omitempty"`
 }
 
 type OrderRead struct {
-	ID         uuid.UUID   `json:"id"`
-	CustomerID uuid.UUID   `json:"customer_id"`
-	ProductID  uuid.UUID   `json:"product_id"`
-	Status     OrderStatus `json:"status"`
-	Amount     float64     `json:"amount"`
-	CreatedAt  time.Time   `json:"created_at"`
-	UpdatedAt  time.Time   `json:"updated_at"`
+	ID                uuid.UUID        `json:"id"`
+	CustomerID        uuid.UUID        `json:"customer_id"`
+	ProductID         uuid.UUID        `json:"product_id"`
+	Status            OrderStatus      `json:"status"`
+	Amount            float64          `json:"amount"`
+	ShippingAddressID *uuid.UUID       `json:"shipping_address_id,omitempty"`
+	ShippingAddress   *AddressSnapshot `json:"shipping_address,omitempty"`
+	CreatedAt         time.Time        `json:"created_at"`
+	UpdatedAt         time.Time        `json:"updated_at"`
 }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OrderEvent is an entry in an order's status history. IDs increase
+// monotonically across all orders, so they double as stream positions.
+type OrderEvent struct {
+	ID         int64       `json:"id"`
+	OrderID    uuid.UUID   `json:"order_id"`
+	CustomerID uuid.UUID   `json:"customer_id"`
+	Status     OrderStatus `json:"status"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
@@ -0,0 +1,76 @@
+package models
+
+// ProblemContentType is the media type of Problem bodies
+const ProblemContentType = "application/problem+json"
+
+// ErrorCode identifies the kind of an error. Codes are stable, so clients
+// can branch on them rather than on messages.
+type ErrorCode string
+
+const (
+	CodeInvalidPayload         ErrorCode = "invalid_payload"
+	CodeValidationFailed       ErrorCode = "validation_failed"
+	CodeInvalidParameter       ErrorCode = "invalid_parameter"
+	CodeInvalidShippingAddress ErrorCode = "invalid_shipping_address"
+	CodeUnauthenticated        ErrorCode = "unauthenticated"
+	CodeForbidden              ErrorCode = "forbidden"
+	CodeNotFound               ErrorCode = "not_found"
+	CodeMethodNotAllowed       ErrorCode = "method_not_allowed"
+	CodeOrderNotPaid           ErrorCode = "order_not_paid"
+	CodeInvalidTransition      ErrorCode = "invalid_transition"
+	CodePayloadTooLarge        ErrorCode = "payload_too_large"
+	CodeRateLimited            ErrorCode = "rate_limited"
+	CodeQuotaExceeded          ErrorCode = "quota_exceeded"
+	CodeInternal               ErrorCode = "internal_error"
+	CodeUnavailable            ErrorCode = "unavailable"
+	CodeTimeout                ErrorCode = "timeout"
+)
+
+// ErrorTitles summarises each kind of error, as the title of its problems
+var ErrorTitles = map[ErrorCode]string{
+	CodeInvalidPayload:         "Invalid request payload",
+	CodeValidationFailed:       "Validation failed",
+	CodeInvalidParameter:       "Invalid parameter",
+	CodeInvalidShippingAddress: "Invalid shipping address",
+	CodeUnauthenticated:        "Authentication required",
+	CodeForbidden:              "Forbidden",
+	CodeNotFound:               "Not found",
+	CodeMethodNotAllowed:       "Method not allowed",
+	CodeOrderNotPaid:           "Order has not been paid",
+	CodeInvalidTransition:      "Invalid status transition",
+	CodePayloadTooLarge:        "Payload too large",
+	CodeRateLimited:            "Rate limit exceeded",
+	CodeQuotaExceeded:          "Daily order quota exceeded",
+	CodeInternal:               "Internal error",
+	CodeUnavailable:            "Service unavailable",
+	CodeTimeout:                "Request timed out",
+}
+
+// ProblemType returns the URI identifying the kind of problem
+func (c ErrorCode) ProblemType() string {
+	return "urn:order-service:problem:" + string(c)
+}
+
+// Problem is an RFC 7807 problem details body. Besides the standard members
+// it carries the error code, the failed fields of a validation error and
+// the IDs to find the request by in logs and traces.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      ErrorCode    `json:"code"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	TraceID   string       `json:"trace_id,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError is a request field that failed validation. Field is the path
+// of the field in the JSON payload, and Rule the validation it failed.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
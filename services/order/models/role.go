@@ -0,0 +1,38 @@
+package models
+
+// Roles say what kind of caller a principal is. Each grants a fixed set of
+// permissions, the same strings as API key scopes.
+const (
+	RoleAdmin    = "admin"
+	RoleSupport  = "support"
+	RoleCustomer = "customer"
+	RoleService  = "service"
+)
+
+// RolePermissions lists the permissions granted to each role. Customers
+// are further limited to their own data.
+var RolePermissions = map[string][]string{
+	RoleAdmin: AllScopes,
+	RoleSupport: {
+		ScopeProductsRead, ScopeOrdersRead,
+		ScopeCustomersRead, ScopeCustomersWrite, ScopeFulfilment,
+	},
+	RoleCustomer: {
+		ScopeProductsRead, ScopeOrdersRead, ScopeOrdersWrite,
+		ScopeCustomersRead, ScopeCustomersWrite,
+	},
+	RoleService: {
+		ScopeProductsRead, ScopeOrdersRead, ScopeOrdersWrite,
+		ScopeCustomersRead, ScopeFulfilment,
+	},
+}
+
+// RoleGrants reports whether the role grants the permission
+func RoleGrants(role, permission string) bool {
+	for _, p := range RolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ShipmentStatus string
+
+const (
+	ShipmentAwaiting  ShipmentStatus = "awaiting_shipment"
+	ShipmentShipped   ShipmentStatus = "shipped"
+	ShipmentDelivered ShipmentStatus = "delivered"
+	ShipmentReturned  ShipmentStatus = "returned"
+)
+
+// shipmentTransitions lists the states a shipment may move to from each state
+var shipmentTransitions = map[ShipmentStatus][]ShipmentStatus{
+	ShipmentAwaiting:  {ShipmentShipped},
+	ShipmentShipped:   {ShipmentDelivered, ShipmentReturned},
+	ShipmentDelivered: {ShipmentReturned},
+}
+
+// CanTransitionTo reports whether a shipment in this state may move to next
+func (s ShipmentStatus) CanTransitionTo(next ShipmentStatus) bool {
+	for _, allowed := range shipmentTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// FulfilmentStatus derives the order status from the states of its shipments.
+// An order is only as far along as its least progressed active shipment;
+// returned shipments are ignored unless every shipment was returned.
+func FulfilmentStatus(shipments []ShipmentStatus) OrderStatus {
+	if len(shipments) == 0 {
+		return Completed
+	}
+
+	status := Returned
+	for _, s := range shipments {
+		switch s {
+		case ShipmentAwaiting:
+			return AwaitingShipment
+		case ShipmentShipped:
+			status = Shipped
+		case ShipmentDelivered:
+			if status != Shipped {
+				status = Delivered
+			}
+		}
+	}
+	return status
+}
+
+// ShipmentWrite represents a shipment for creating
+type ShipmentWrite struct {
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"tracking_number"`
+}
+
+// ShipmentRead represents a shipment for reading
+type ShipmentRead struct {
+	ID             uuid.UUID           `json:"id"`
+	OrderID        uuid.UUID           `json:"order_id"`
+	Status         ShipmentStatus      `json:"status"`
+	Carrier        string              `json:"carrier"`
+	TrackingNumber string              `json:"tracking_number"`
+	ShippedAt      *time.Time          `json:"shipped_at,omitempty"`
+	DeliveredAt    *time.Time          `json:"delivered_at,omitempty"`
+	Events         []ShipmentEventRead `json:"events,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+}
+
+// ShipmentEventWrite represents a shipment event reported by a carrier or the warehouse
+type ShipmentEventWrite struct {
+	Status         ShipmentStatus `json:"status" validate:"required,oneof=shipped delivered returned"`
+	Carrier        string         `json:"carrier"`
+	TrackingNumber string         `json:"tracking_number"`
+	Note           string         `json:"note"`
+	OccurredAt     *time.Time     `json:"occurred_at"`
+}
+
+// ShipmentEventRead represents a shipment event for reading
+type ShipmentEventRead struct {
+	ID         int64          `json:"id"`
+	ShipmentID uuid.UUID      `json:"shipment_id"`
+	Status     ShipmentStatus `json:"status"`
+	Note       string         `json:"note"`
+	OccurredAt time.Time      `json:"occurred_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShipmentStatusCanTransitionTo(t *testing.T) {
+	assert.True(t, ShipmentAwaiting.CanTransitionTo(ShipmentShipped))
+	assert.True(t, ShipmentShipped.CanTransitionTo(ShipmentDelivered))
+	assert.True(t, ShipmentDelivered.CanTransitionTo(ShipmentReturned))
+	assert.False(t, ShipmentAwaiting.CanTransitionTo(ShipmentDelivered))
+	assert.False(t, ShipmentReturned.CanTransitionTo(ShipmentShipped))
+}
+
+func TestFulfilmentStatus(t *testing.T) {
+	tests := []struct {
+		name      string
+		shipments []ShipmentStatus
+		expected  OrderStatus
+	}{
+		{"no shipments", nil, Completed},
+		{"one awaiting", []ShipmentStatus{ShipmentShipped, ShipmentAwaiting}, AwaitingShipment},
+		{"partially delivered", []ShipmentStatus{ShipmentDelivered, ShipmentShipped}, Shipped},
+		{"all delivered", []ShipmentStatus{ShipmentDelivered, ShipmentDelivered}, Delivered},
+		{"delivered and returned", []ShipmentStatus{ShipmentReturned, ShipmentDelivered}, Delivered},
+		{"all returned", []ShipmentStatus{ShipmentReturned}, Returned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, FulfilmentStatus(tt.shipments))
+		})
+	}
+}
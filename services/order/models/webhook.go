@@ -0,0 +1,90 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookTestEvent is the event type of deliveries sent by the test endpoint
+const WebhookTestEvent = "webhook.test"
+
+// OrderEventType is the webhook event type for an order reaching status
+func OrderEventType(status OrderStatus) string {
+	return "order." + string(status)
+}
+
+// EventTypes is the list of event types a webhook is subscribed to
+type EventTypes []string
+
+// Value stores the event types in a JSONB column
+func (e EventTypes) Value() (driver.Value, error) {
+	return json.Marshal(e)
+}
+
+// Scan reads the event types from a JSONB column
+func (e *EventTypes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, e)
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	default:
+		return fmt.Errorf("cannot scan %T into EventTypes", src)
+	}
+}
+
+// WebhookWrite represents a webhook subscription for creating or updating.
+// If Secret is empty one is generated.
+type WebhookWrite struct {
+	URL        string     `json:"url" validate:"required,url,startswith=http"`
+	Secret     string     `json:"secret" validate:"omitempty,min=16"`
+	EventTypes EventTypes `json:"event_types" validate:"required,min=1,dive,oneof=order.pending order.completed order.failed order.awaiting_shipment order.shipped order.delivered order.returned"`
+	Active     *bool      `json:"active"`
+}
+
+// WebhookRead represents a webhook subscription for reading. The secret is
+// only returned when the webhook is created.
+type WebhookRead struct {
+	ID         uuid.UUID  `json:"id"`
+	URL        string     `json:"url"`
+	Secret     string     `json:"secret,omitempty"`
+	EventTypes EventTypes `json:"event_types"`
+	Active     bool       `json:"active"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is an entry in a webhook's delivery log
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	WebhookID      uuid.UUID       `json:"webhook_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         DeliveryStatus  `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// WebhookPayload is the body POSTed to webhook receivers
+type WebhookPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
@@ -0,0 +1,87 @@
+// Package openapi serves the OpenAPI specification of the order service,
+// with Swagger UI to browse it, and validates requests against it.
+package openapi
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/google/uuid"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+//go:embed openapi.yaml
+var spec []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+// loaded is when the process started, as the modification time of the
+// embedded files
+var loaded = time.Now()
+
+func init() {
+	// Accept the same IDs as uuid.Parse, which the handlers use
+	openapi3.DefineStringFormatCallback("uuid", func(value string) error {
+		_, err := uuid.Parse(value)
+		return err
+	})
+}
+
+// Spec is the parsed specification
+type Spec struct {
+	Doc    *openapi3.T
+	json   []byte
+	router routers.Router
+}
+
+// Load parses the embedded specification and checks that it is valid
+func Load() (*Spec, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the OpenAPI specification: %v", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI specification: %v", err)
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Spec{Doc: doc, json: body, router: router}, nil
+}
+
+// Handler serves the specification as JSON
+func (s *Spec) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeContent(w, r, "openapi.json", loaded, bytes.NewReader(s.json))
+	})
+}
+
+// DocsHandler serves Swagger UI, showing the specification served at
+// /openapi.json. It expects requests with prefix stripped from their path.
+func DocsHandler() http.Handler {
+	assets := http.FileServer(http.FS(swaggerFiles.FS))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "", "/", "index.html", "/index.html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			http.ServeContent(w, r, "index.html", loaded, bytes.NewReader(docsPage))
+		default:
+			assets.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"order/models"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	spec, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := spec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	serve := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	decode := func(t *testing.T, rr *httptest.ResponseRecorder) models.Problem {
+		var result models.Problem
+		err := json.NewDecoder(rr.Body).Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	t.Run("valid request", func(t *testing.T) {
+		body := `{"customer_id": "` + uuid.NewString() + `", "product_id": "` + uuid.NewString() + `", "amount": 10}`
+		rr := serve("POST", "/order", "application/json", body)
+		assert.Equal(t, http.StatusTeapot, rr.Code)
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		rr := serve("POST", "/order", "application/json", `{"customer_id": "abc", "amount": "ten"}`)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, models.ProblemContentType, rr.Header().Get("Content-Type"))
+
+		result := decode(t, rr)
+		assert.Equal(t, models.CodeValidationFailed, result.Code)
+		fields := make(map[string]string)
+		for _, field := range result.Errors {
+			fields[field.Field] = field.Rule
+		}
+		assert.Equal(t, map[string]string{"customer_id": "format", "product_id": "required", "amount": "type"}, fields)
+	})
+
+	t.Run("missing body", func(t *testing.T) {
+		rr := serve("POST", "/product", "", "")
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, []models.FieldError{
+			{Field: "body", Rule: "required", Message: "value is required but missing"},
+		}, decode(t, rr).Errors)
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		rr := serve("GET", "/orders?limit=500", "", "")
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, []models.FieldError{
+			{Field: "limit", Rule: "maximum", Message: "number must be at most 200"},
+		}, decode(t, rr).Errors)
+
+		rr = serve("GET", "/order/abc", "", "")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		if errs := decode(t, rr).Errors; assert.Len(t, errs, 1) {
+			assert.Equal(t, "id", errs[0].Field)
+			assert.Equal(t, "format", errs[0].Rule)
+		}
+	})
+
+	t.Run("nested fields", func(t *testing.T) {
+		rr := serve("POST", "/webhooks", "application/json", `{"url": "https://example.com", "event_types": ["order.lost"]}`)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		if errs := decode(t, rr).Errors; assert.Len(t, errs, 1) {
+			assert.Equal(t, "event_types.0", errs[0].Field)
+			assert.Equal(t, "enum", errs[0].Rule)
+		}
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		rr := serve("POST", "/orders/bulk", "application/json", "["+strings.Repeat(" ", maxBufferedBody)+"]")
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		assert.Equal(t, models.CodePayloadTooLarge, decode(t, rr).Code)
+	})
+
+	t.Run("streamed CSV is left to the handler", func(t *testing.T) {
+		rr := serve("POST", "/orders/bulk", "text/csv", "customer_id,product_id,amount\n")
+		assert.Equal(t, http.StatusTeapot, rr.Code)
+	})
+
+	t.Run("route missing from the spec", func(t *testing.T) {
+		rr := serve("GET", "/unknown", "", "")
+		assert.Equal(t, http.StatusTeapot, rr.Code)
+	})
+}
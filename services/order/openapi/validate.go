@@ -0,0 +1,133 @@
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"order/models"
+	"order/problem"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+)
+
+// streamedMediaTypes are request bodies left to the handler, which reads
+// them as a stream rather than buffering them whole for validation
+var streamedMediaTypes = map[string]bool{
+	"multipart/form-data": true,
+	"text/csv":            true,
+}
+
+// maxBufferedBody is the largest request body read whole for validation.
+// Larger bodies are answered with 413 payload_too_large.
+const maxBufferedBody = 10 << 20
+
+// Middleware answers requests whose parameters or body don't match the
+// specification with a 400 validation_failed problem listing every invalid
+// field. Credentials are left to auth.Middleware, and routes missing from
+// the specification to the router.
+func (s *Spec) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := s.router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if !streamedMediaTypes[mediaType] && r.Body != nil && r.Body != http.NoBody {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBufferedBody)
+		}
+		err = openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				ExcludeRequestBody: streamedMediaTypes[mediaType],
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		})
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problem.Write(w, r, http.StatusRequestEntityTooLarge, models.CodePayloadTooLarge, fmt.Sprintf("The request body is larger than %d MB", maxBufferedBody>>20))
+			return
+		}
+		if err != nil {
+			p := problem.New(r, http.StatusBadRequest, models.CodeValidationFailed, "The request does not match the API specification")
+			p.Errors = Fields(err)
+			problem.WriteProblem(w, p)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Fields lists the fields reported by an error of ValidateRequest. Body
+// fields are named by their JSON path and parameters by their name.
+func Fields(err error) []models.FieldError {
+	var fields []models.FieldError
+	for _, err := range flatten(err) {
+		var requestErr *openapi3filter.RequestError
+		if !errors.As(err, &requestErr) {
+			fields = append(fields, models.FieldError{Field: "request", Rule: "invalid", Message: err.Error()})
+			continue
+		}
+
+		field := "body"
+		if requestErr.Parameter != nil {
+			field = requestErr.Parameter.Name
+		}
+		schemaErrs := schemaErrors(requestErr.Err)
+		if len(schemaErrs) == 0 {
+			rule := "invalid"
+			if errors.Is(requestErr.Err, openapi3filter.ErrInvalidRequired) {
+				rule = "required"
+			}
+			message := requestErr.Reason
+			if requestErr.Err != nil {
+				message = requestErr.Err.Error()
+			}
+			fields = append(fields, models.FieldError{Field: field, Rule: rule, Message: message})
+			continue
+		}
+		for _, schemaErr := range schemaErrs {
+			path := field
+			if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+				path = strings.Join(pointer, ".")
+				if requestErr.Parameter != nil {
+					path = field + "." + path
+				}
+			}
+			fields = append(fields, models.FieldError{Field: path, Rule: schemaErr.SchemaField, Message: schemaErr.Reason})
+		}
+	}
+	return fields
+}
+
+// flatten lists the errors joined in a MultiError, recursively
+func flatten(err error) []error {
+	// Not errors.As, which would also unwrap a RequestError
+	multi, ok := err.(openapi3.MultiError)
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, err := range multi {
+		errs = append(errs, flatten(err)...)
+	}
+	return errs
+}
+
+func schemaErrors(err error) []*openapi3.SchemaError {
+	var schemaErrs []*openapi3.SchemaError
+	for _, err := range flatten(err) {
+		var schemaErr *openapi3.SchemaError
+		if errors.As(err, &schemaErr) {
+			schemaErrs = append(schemaErrs, schemaErr)
+		}
+	}
+	return schemaErrs
+}
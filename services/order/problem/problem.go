@@ -0,0 +1,149 @@
+// Package problem writes errors as RFC 7807 problem details
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"order/logging"
+	"order/models"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// New returns the problem of the given status and code for the request
+func New(r *http.Request, status int, code models.ErrorCode, detail string) models.Problem {
+	p := models.Problem{
+		Type:      code.ProblemType(),
+		Title:     models.ErrorTitles[code],
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: logging.RequestID(r.Context()),
+	}
+	if span := trace.SpanContextFromContext(r.Context()); span.HasTraceID() {
+		p.TraceID = span.TraceID().String()
+	}
+	return p
+}
+
+// Write writes the problem of the given status and code
+func Write(w http.ResponseWriter, r *http.Request, status int, code models.ErrorCode, detail string) {
+	WriteProblem(w, New(r, status, code, detail))
+}
+
+// WriteProblem writes p with its status
+func WriteProblem(w http.ResponseWriter, p models.Problem) {
+	w.Header().Set("Content-Type", models.ProblemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// NotFoundHandler answers requests that match no route
+func NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, http.StatusNotFound, models.CodeNotFound, "No route matches "+r.URL.Path)
+	})
+}
+
+// MethodNotAllowedHandler answers requests to a route that doesn't accept
+// their method
+func MethodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Write(w, r, http.StatusMethodNotAllowed, models.CodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+	})
+}
+
+// Validation writes a validation_failed problem listing the fields err
+// reports
+func Validation(w http.ResponseWriter, r *http.Request, err error) {
+	p := New(r, http.StatusBadRequest, models.CodeValidationFailed, "The request has invalid fields")
+	p.Errors = Fields(err)
+	WriteProblem(w, p)
+}
+
+// Fields lists the fields that failed validation in err, a
+// validator.ValidationErrors, named by their JSON path. See JSONFieldName.
+func Fields(err error) []models.FieldError {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil
+	}
+	fields := make([]models.FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		// The namespace starts with the name of the validated struct
+		path := fe.Namespace()
+		if i := strings.Index(path, "."); i >= 0 {
+			path = path[i+1:]
+		}
+		fields = append(fields, models.FieldError{
+			Field:   path,
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: message(fe),
+		})
+	}
+	return fields
+}
+
+// Summary joins the messages of the failed fields in err into one line
+func Summary(err error) string {
+	fields := Fields(err)
+	if len(fields) == 0 {
+		return err.Error()
+	}
+	return FieldSummary(fields)
+}
+
+// FieldSummary joins the messages of the failed fields into one line
+func FieldSummary(fields []models.FieldError) string {
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = field.Field + " " + field.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "required_if":
+		return "is required when " + strings.Replace(fe.Param(), " ", " is ", 1)
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "max":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters long", fe.Param())
+		}
+		return "must be at most " + fe.Param()
+	case "min":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %s characters long", fe.Param())
+		}
+		return "must be at least " + fe.Param()
+	case "url":
+		return "must be a URL"
+	case "startswith":
+		return "must start with " + fe.Param()
+	case "iso3166_1_alpha2":
+		return "must be a two-letter ISO 3166 country code"
+	default:
+		return "failed the " + fe.Tag() + " rule"
+	}
+}
+
+// JSONFieldName names struct fields by their JSON key in validation errors,
+// for use with validator.Validate.RegisterTagNameFunc
+func JSONFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" || name == "" {
+		return field.Name
+	}
+	return name
+}
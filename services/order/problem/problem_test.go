@@ -0,0 +1,132 @@
+package problem
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"order/logging"
+	"order/models"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func decode(t *testing.T, rr *httptest.ResponseRecorder) models.Problem {
+	var result models.Problem
+	err := json.NewDecoder(rr.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestWrite(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(logging.WithRequestID(context.Background(), "req-1"), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+	req, err := http.NewRequestWithContext(ctx, "GET", "/order/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	Write(rr, req, http.StatusNotFound, models.CodeNotFound, "Order not found")
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, models.ProblemContentType, rr.Header().Get("Content-Type"))
+	assert.Equal(t, models.Problem{
+		Type:      "urn:order-service:problem:not_found",
+		Title:     "Not found",
+		Status:    http.StatusNotFound,
+		Detail:    "Order not found",
+		Instance:  "/order/1",
+		Code:      models.CodeNotFound,
+		TraceID:   traceID.String(),
+		RequestID: "req-1",
+	}, decode(t, rr))
+}
+
+func TestValidation(t *testing.T) {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(JSONFieldName)
+
+	type address struct {
+		Country string `json:"country" validate:"required,iso3166_1_alpha2"`
+	}
+	type payload struct {
+		Name    string  `json:"name" validate:"required,max=5"`
+		Type    string  `json:"type,omitempty" validate:"oneof=home work"`
+		Address address `json:"address"`
+		Ignored string  `json:"-" validate:"required"`
+	}
+
+	err := validate.Struct(payload{Name: "too long", Type: "office", Address: address{Country: "XX"}})
+
+	req, _ := http.NewRequest("POST", "/customer", nil)
+	rr := httptest.NewRecorder()
+	Validation(rr, req, err)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	result := decode(t, rr)
+	assert.Equal(t, models.CodeValidationFailed, result.Code)
+	assert.Equal(t, []models.FieldError{
+		{Field: "name", Rule: "max", Param: "5", Message: "must be at most 5 characters long"},
+		{Field: "type", Rule: "oneof", Param: "home work", Message: "must be one of home, work"},
+		{Field: "address.country", Rule: "iso3166_1_alpha2", Message: "must be a two-letter ISO 3166 country code"},
+		{Field: "Ignored", Rule: "required", Message: "is required"},
+	}, result.Errors)
+	assert.Equal(t, "name must be at most 5 characters long; type must be one of home, work; address.country must be a two-letter ISO 3166 country code; Ignored is required", Summary(err))
+}
+
+func TestStorage(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+		code   models.ErrorCode
+		detail string
+	}{
+		{"timed out", context.DeadlineExceeded, http.StatusGatewayTimeout, models.CodeTimeout, TimeoutDetail},
+		{"database down", driver.ErrBadConn, http.StatusServiceUnavailable, models.CodeUnavailable, UnavailableDetail},
+		{"anything else", errors.New(`pq: relation "orders" does not exist`), http.StatusInternalServerError, models.CodeInternal, "Failed to retrieve order"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/order/1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			Storage(rr, req, tc.err, "Failed to retrieve order")
+
+			assert.Equal(t, tc.status, rr.Code)
+			assert.NotContains(t, rr.Body.String(), tc.err.Error())
+
+			result := decode(t, rr)
+			assert.Equal(t, tc.code, result.Code)
+			assert.Equal(t, tc.detail, result.Detail)
+		})
+	}
+
+	t.Run("client went away", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, err := http.NewRequestWithContext(ctx, "GET", "/order/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		Storage(rr, req, context.Canceled, "Failed to retrieve order")
+
+		assert.Empty(t, rr.Body.String())
+	})
+}
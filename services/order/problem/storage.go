@@ -0,0 +1,63 @@
+package problem
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"order/database"
+	"order/models"
+	"strconv"
+	"time"
+)
+
+// Details of requests that failed on their dependencies rather than on
+// anything the client did
+const (
+	TimeoutDetail     = "The request timed out"
+	UnavailableDetail = "The service is temporarily unavailable"
+)
+
+// UnavailableRetryAfter is when to retry requests that failed because the
+// database was unavailable
+const UnavailableRetryAfter = 5 * time.Second
+
+// Storage writes the problem for a failed database call, as classified by
+// StorageFailure: 504 if it ran out of time, 503 if the database couldn't be
+// reached and 500 with detail otherwise. Nothing is written for clients that
+// have gone away.
+func Storage(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	code, detail := StorageFailure(r.Context(), err, detail)
+	switch code {
+	case "":
+	case models.CodeTimeout:
+		Write(w, r, http.StatusGatewayTimeout, code, detail)
+	case models.CodeUnavailable:
+		w.Header().Set("Retry-After", strconv.Itoa(int(UnavailableRetryAfter.Seconds())))
+		Write(w, r, http.StatusServiceUnavailable, code, detail)
+	default:
+		Write(w, r, http.StatusInternalServerError, code, detail)
+	}
+}
+
+// StorageFailure returns the code and detail to report a database call
+// made for the request of ctx that failed with err: timeout if it ran out of
+// time, unavailable if the database couldn't be reached and internal with
+// detail otherwise. The error itself is only logged, never reported. The
+// code is empty if the client has gone away.
+func StorageFailure(ctx context.Context, err error, detail string) (models.ErrorCode, string) {
+	switch {
+	case errors.Is(err, context.Canceled) && ctx.Err() != nil:
+		slog.InfoContext(ctx, "Request cancelled by the client", "error", err)
+		return "", ""
+	case database.IsTimeout(err) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		slog.WarnContext(ctx, "Request timed out", "error", err)
+		return models.CodeTimeout, TimeoutDetail
+	case database.IsUnavailable(err):
+		slog.WarnContext(ctx, "Database unavailable", "error", err)
+		return models.CodeUnavailable, UnavailableDetail
+	default:
+		slog.ErrorContext(ctx, detail, "error", err)
+		return models.CodeInternal, detail
+	}
+}
@@ -0,0 +1,6 @@
+// Package orderv1 holds the order service's gRPC API, generated from
+// order.proto. Regenerate it after changing the proto with go generate,
+// which needs protoc, protoc-gen-go and protoc-gen-go-grpc on the PATH.
+package orderv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative order/v1/order.proto
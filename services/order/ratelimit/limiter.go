@@ -0,0 +1,249 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"order/auth"
+	"order/config"
+	"order/metrics"
+	"order/models"
+	"order/problem"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// tokenBucket refills the bucket at KEYS[1] for the time since it was last
+// used, then takes cost tokens if there is at least one. It returns whether
+// the request is allowed, the tokens left, the milliseconds until a token is
+// available if not, and the milliseconds until the bucket is full again. A
+// cost of 0 only looks at the bucket.
+//
+// ARGV: refill rate in tokens per millisecond, burst, now in milliseconds,
+// cost
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - cost
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+local full = math.ceil((burst - tokens) / rate)
+if cost > 0 then
+	redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+	redis.call('PEXPIRE', KEYS[1], full + 1000)
+end
+return {allowed, math.floor(tokens), retry, full}
+`)
+
+// Result is the outcome of taking a token for a request
+type Result struct {
+	Allowed    bool
+	Limit      config.RateLimit
+	Remaining  int
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Limiter is a token bucket rate limiter shared by every instance of the
+// service through Redis. Each client has a bucket per route with a limit of
+// its own and one for all other routes.
+type Limiter struct {
+	rdb    *redis.Client
+	cfg    config.RateLimitConfig
+	now    func() time.Time
+	prefix string
+}
+
+// NewLimiter returns a limiter enforcing the configured limits
+func NewLimiter(rdb *redis.Client, cfg config.RateLimitConfig) *Limiter {
+	return &Limiter{rdb: rdb, cfg: cfg, now: time.Now, prefix: "ratelimit:"}
+}
+
+// limitFor returns the limit of the route and the name of its bucket
+func (l *Limiter) limitFor(route string) (config.RateLimit, string) {
+	if limit, ok := l.cfg.Routes[route]; ok {
+		return limit, route
+	}
+	return l.cfg.Default, "default"
+}
+
+// Allow takes a token from the client's bucket for the route, given as the
+// method and route template
+func (l *Limiter) Allow(ctx context.Context, route, client string) (Result, error) {
+	limit, bucket := l.limitFor(route)
+	return l.take(ctx, bucket+":"+client, limit, 1)
+}
+
+// failedAuthBucket is the bucket of each IP address's failed
+// authentications
+const failedAuthBucket = "failed_auth"
+
+// AllowAuthentication reports whether credentials from the IP address are
+// to be checked at all, without taking a token: once its failures have used
+// up the failed authentication limit, it is refused until they refill.
+func (l *Limiter) AllowAuthentication(ctx context.Context, ip string) (Result, error) {
+	return l.take(ctx, failedAuthBucket+":ip:"+ip, l.cfg.FailedAuth, 0)
+}
+
+// AuthenticationFailed takes a token from the IP address's failed
+// authentication bucket
+func (l *Limiter) AuthenticationFailed(ctx context.Context, ip string) error {
+	_, err := l.take(ctx, failedAuthBucket+":ip:"+ip, l.cfg.FailedAuth, 1)
+	return err
+}
+
+// take takes cost tokens from the bucket at key if it has any
+func (l *Limiter) take(ctx context.Context, key string, limit config.RateLimit, cost int) (Result, error) {
+	rate := float64(limit.Requests) / float64(limit.Per.Milliseconds())
+
+	values, err := tokenBucket.Run(ctx, l.rdb, []string{l.prefix + key},
+		strconv.FormatFloat(rate, 'g', -1, 64), limit.Burst, l.now().UnixMilli(), cost).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      limit,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		Reset:      time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
+
+// Client identifies the caller of the request: its principal once
+// authenticated, its IP address otherwise.
+func Client(r *http.Request) string {
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		return PrincipalClient(principal)
+	}
+	return "ip:" + IP(r.RemoteAddr)
+}
+
+// IP returns the IP address of a remote address, with or without its port
+func IP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// PrincipalClient identifies an authenticated caller, whichever API it
+// calls
+func PrincipalClient(principal *auth.Principal) string {
+	return principal.Method + ":" + principal.Subject
+}
+
+// routeOf returns the method and route template of the request, or its path
+// if it matched no route
+func routeOf(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return r.Method + " " + template
+		}
+	}
+	return r.Method + " " + r.URL.Path
+}
+
+// AuthenticationMiddleware goes before authentication. It rejects requests
+// from IP addresses over the failed authentication limit with 429, whatever
+// their credentials, and counts the 401s answered to the others. If Redis
+// is unavailable requests are let through.
+func (l *Limiter) AuthenticationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := IP(r.RemoteAddr)
+		result, err := l.AllowAuthentication(r.Context(), ip)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Rate limiter unavailable, allowing request", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !result.Allowed {
+			metrics.RateLimited.WithLabelValues(routeOf(r)).Inc()
+			slog.InfoContext(r.Context(), "Too many failed authentications", "ip", ip)
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			problem.Write(w, r, http.StatusTooManyRequests, models.CodeRateLimited, "Too many failed authentications")
+			return
+		}
+
+		unauthorized := false
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					unauthorized = code == http.StatusUnauthorized
+					next(code)
+				}
+			},
+		})
+		next.ServeHTTP(w, r)
+		if unauthorized {
+			if err := l.AuthenticationFailed(r.Context(), ip); err != nil {
+				slog.WarnContext(r.Context(), "Unable to count failed authentication", "error", err)
+			}
+		}
+	})
+}
+
+// Middleware rejects requests over the client's limit with 429. Every
+// response carries the RateLimit headers of the bucket used. If Redis is
+// unavailable requests are let through rather than failing the API.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeOf(r)
+		result, err := l.Allow(r.Context(), route, Client(r))
+		if err != nil {
+			slog.WarnContext(r.Context(), "Rate limiter unavailable, allowing request", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		setHeaders(w.Header(), result)
+		if !result.Allowed {
+			metrics.RateLimited.WithLabelValues(route).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			problem.Write(w, r, http.StatusTooManyRequests, models.CodeRateLimited, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setHeaders sets the RateLimit headers of the IETF httpapi draft
+func setHeaders(h http.Header, result Result) {
+	h.Set("RateLimit-Limit", strconv.Itoa(result.Limit.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", result.Limit.Requests, seconds(result.Limit.Per), result.Limit.Burst))
+}
+
+// seconds rounds d up to whole seconds, as the headers require
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// reserveQuota takes up to ARGV[1] from the quota at KEYS[1], which allows
+// ARGV[2] in total, and returns how many were granted and the total used.
+// The counter expires after ARGV[3] seconds.
+var reserveQuota = redis.NewScript(`
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+local granted = math.max(0, math.min(tonumber(ARGV[1]), tonumber(ARGV[2]) - used))
+if granted > 0 then
+	redis.call('INCRBY', KEYS[1], granted)
+	redis.call('EXPIRE', KEYS[1], ARGV[3])
+end
+return {granted, used + granted}
+`)
+
+// OrderQuota caps the orders each customer can place per UTC day. A nil
+// quota places no cap.
+type OrderQuota struct {
+	rdb   *redis.Client
+	limit int
+	now   func() time.Time
+}
+
+// NewOrderQuota returns a quota of limit orders per customer and day, or
+// nil if limit is 0.
+func NewOrderQuota(rdb *redis.Client, limit int) *OrderQuota {
+	if limit == 0 {
+		return nil
+	}
+	return &OrderQuota{rdb: rdb, limit: limit, now: time.Now}
+}
+
+// Reservation is the part of a customer's quota taken for a request
+type Reservation struct {
+	// Granted is how many of the requested orders may be placed
+	Granted int
+	// Limit is the daily quota, and ResetIn how long until it is restored
+	Limit   int
+	ResetIn time.Duration
+	key     string
+}
+
+// Reserve takes up to n orders from the customer's quota for today. Orders
+// that are then not created are given back with Release.
+func (q *OrderQuota) Reserve(ctx context.Context, customerID uuid.UUID, n int) (Reservation, error) {
+	if q == nil {
+		return Reservation{Granted: n}, nil
+	}
+	now := q.now().UTC()
+	day := now.Format("2006-01-02")
+	midnight := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+
+	reservation := Reservation{Limit: q.limit, ResetIn: midnight.Sub(now), key: "quota:orders:" + customerID.String() + ":" + day}
+	// The counter outlives the day a little so late releases find it
+	values, err := reserveQuota.Run(ctx, q.rdb, []string{reservation.key}, n, q.limit, 48*60*60).Int64Slice()
+	if err != nil {
+		return Reservation{}, err
+	}
+	reservation.Granted = int(values[0])
+	return reservation, nil
+}
+
+// Release gives back n orders of the reservation that weren't created
+func (q *OrderQuota) Release(ctx context.Context, reservation Reservation, n int) error {
+	if q == nil || reservation.key == "" || n <= 0 {
+		return nil
+	}
+	return q.rdb.DecrBy(ctx, reservation.key, int64(n)).Err()
+}
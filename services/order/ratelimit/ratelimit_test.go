@@ -0,0 +1,204 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"order/auth"
+	"order/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when starting miniredis", err)
+	}
+	t.Cleanup(mr.Close)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	_, rdb := newRedis(t)
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(rdb, config.RateLimitConfig{
+		Enabled: true,
+		Default: config.RateLimit{Requests: 100, Per: time.Minute, Burst: 100},
+		Routes: map[string]config.RateLimit{
+			"POST /order": {Requests: 60, Per: time.Minute, Burst: 2},
+		},
+	})
+	limiter.now = func() time.Time { return now }
+
+	router := mux.NewRouter()
+	router.Use(limiter.Middleware)
+	router.HandleFunc("/order", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodPost)
+	router.HandleFunc("/order/{id}", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet)
+
+	request := func(method, path, subject string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Subject: subject, Method: auth.MethodAPIKey}))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("burst then rejected", func(t *testing.T) {
+		rr := request("POST", "/order", "key-1")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", rr.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "60;w=60;burst=2", rr.Header().Get("RateLimit-Policy"))
+
+		assert.Equal(t, http.StatusOK, request("POST", "/order", "key-1").Code)
+
+		rr = request("POST", "/order", "key-1")
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+		assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "2", rr.Header().Get("RateLimit-Reset"))
+	})
+
+	t.Run("buckets are per client and route", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request("POST", "/order", "key-2").Code)
+		assert.Equal(t, http.StatusOK, request("GET", "/order/"+uuid.NewString(), "key-1").Code)
+	})
+
+	t.Run("refills over time", func(t *testing.T) {
+		now = now.Add(time.Second)
+		assert.Equal(t, http.StatusOK, request("POST", "/order", "key-1").Code)
+		assert.Equal(t, http.StatusTooManyRequests, request("POST", "/order", "key-1").Code)
+	})
+}
+
+func TestAuthenticationMiddleware(t *testing.T) {
+	_, rdb := newRedis(t)
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(rdb, config.RateLimitConfig{
+		Enabled:    true,
+		Default:    config.RateLimit{Requests: 100, Per: time.Minute, Burst: 100},
+		FailedAuth: config.RateLimit{Requests: 6, Per: time.Minute, Burst: 3},
+	})
+	limiter.now = func() time.Time { return now }
+
+	// Stands in for the authenticator: only "valid" is accepted
+	handler := limiter.AuthenticationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	request := func(ip, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/orders", nil)
+		req.RemoteAddr = ip + ":4321"
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("repeated 401s are rejected", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusUnauthorized, request("192.0.2.1", "guess").Code)
+		}
+		rr := request("192.0.2.1", "guess")
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Equal(t, "10", rr.Header().Get("Retry-After"))
+	})
+
+	t.Run("credentials aren't checked while rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusTooManyRequests, request("192.0.2.1", "valid").Code)
+	})
+
+	t.Run("other addresses are unaffected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request("192.0.2.2", "guess").Code)
+		assert.Equal(t, http.StatusOK, request("192.0.2.2", "valid").Code)
+	})
+
+	t.Run("successful requests don't count", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, request("192.0.2.3", "valid").Code)
+		}
+	})
+
+	t.Run("refills over time", func(t *testing.T) {
+		now = now.Add(10 * time.Second)
+		assert.Equal(t, http.StatusUnauthorized, request("192.0.2.1", "guess").Code)
+		assert.Equal(t, http.StatusTooManyRequests, request("192.0.2.1", "guess").Code)
+	})
+}
+
+func TestLimiterFailsOpen(t *testing.T) {
+	mr, rdb := newRedis(t)
+	limiter := NewLimiter(rdb, config.RateLimitConfig{Default: config.RateLimit{Requests: 1, Per: time.Minute, Burst: 1}})
+	mr.Close()
+
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/product/1", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestClient(t *testing.T) {
+	req := httptest.NewRequest("GET", "/product/1", nil)
+	req.RemoteAddr = "192.0.2.1:4321"
+	assert.Equal(t, "ip:192.0.2.1", Client(req))
+
+	req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Subject: "user-1", Method: auth.MethodJWT}))
+	assert.Equal(t, "jwt:user-1", Client(req))
+
+	assert.Equal(t, "2001:db8::1", IP("[2001:db8::1]:4321"))
+	assert.Equal(t, "192.0.2.1", IP("192.0.2.1"))
+}
+
+func TestOrderQuota(t *testing.T) {
+	_, rdb := newRedis(t)
+	ctx := context.Background()
+
+	now := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	quota := NewOrderQuota(rdb, 3)
+	quota.now = func() time.Time { return now }
+	customerID := uuid.New()
+
+	reservation, err := quota.Reserve(ctx, customerID, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, reservation.Granted)
+	assert.Equal(t, time.Hour, reservation.ResetIn)
+
+	// Only one order is left today
+	reservation, err = quota.Reserve(ctx, customerID, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reservation.Granted)
+
+	// Orders that weren't created are given back
+	assert.NoError(t, quota.Release(ctx, reservation, 1))
+	reservation, err = quota.Reserve(ctx, customerID, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reservation.Granted)
+
+	reservation, err = quota.Reserve(ctx, uuid.New(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reservation.Granted, "quotas are per customer")
+
+	now = now.Add(2 * time.Hour)
+	reservation, err = quota.Reserve(ctx, customerID, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reservation.Granted, "quotas reset daily")
+
+	var unlimited *OrderQuota
+	reservation, err = unlimited.Reserve(ctx, customerID, 5000)
+	assert.NoError(t, err)
+	assert.Equal(t, 5000, reservation.Granted)
+}
@@ -2,28 +2,45 @@ package redisconn
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"os"
+	"order/config"
+	"order/retry"
 
 	"github.com/go-redis/redis/v8"
 )
 
-func Connect() (*redis.Client, error) {
-	redisPort := os.Getenv("REDIS_PORT")
+// Options converts the configuration into go-redis client options
+func Options(cfg config.RedisConfig) *redis.Options {
+	opts := &redis.Options{
+		Addr:         cfg.Addr(),
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		DialTimeout:  cfg.DialTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		PoolSize:     cfg.PoolSize,
+		MinIdleConns: cfg.MinIdleConns,
+		MaxConnAge:   cfg.MaxConnAge,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: cfg.Host}
+	}
+	return opts
+}
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr: "redis:" + redisPort,
-	})
+// Connect opens the client and waits for Redis to answer, for up to
+// cfg.ConnectRetryTimeout, so the service can start before Redis is up.
+func Connect(ctx context.Context, cfg config.RedisConfig) (*redis.Client, error) {
+	rdb := redis.NewClient(Options(cfg))
 
-	_, err := rdb.Ping(context.Background()).Result()
+	err := retry.Do(ctx, "redis", cfg.ConnectRetryTimeout, retry.Backoff{}, func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	})
 	if err != nil {
+		rdb.Close()
 		return nil, fmt.Errorf("unable to connect to Redis: %v", err)
 	}
 
 	return rdb, nil
 }
-
-func Subscribe(rdb *redis.Client, channelName string) <-chan *redis.Message {
-	subscriber := rdb.Subscribe(context.Background(), channelName)
-	return subscriber.Channel()
-}
@@ -0,0 +1,46 @@
+package redisconn
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"order/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnect(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when starting miniredis", err)
+	}
+	defer mr.Close()
+	host, port, _ := net.SplitHostPort(mr.Addr())
+	cfg := config.RedisConfig{Host: host, DialTimeout: 100 * time.Millisecond}
+	cfg.Port, _ = strconv.Atoi(port)
+	mr.Close()
+
+	t.Run("gives up after the retry timeout", func(t *testing.T) {
+		cfg := cfg
+		cfg.ConnectRetryTimeout = 100 * time.Millisecond
+		_, err := Connect(context.Background(), cfg)
+		assert.Error(t, err)
+	})
+
+	t.Run("waits for Redis to come up", func(t *testing.T) {
+		cfg := cfg
+		cfg.ConnectRetryTimeout = 10 * time.Second
+		go func() {
+			time.Sleep(300 * time.Millisecond)
+			mr.Restart()
+		}()
+		rdb, err := Connect(context.Background(), cfg)
+		if assert.NoError(t, err) {
+			rdb.Close()
+		}
+	})
+}
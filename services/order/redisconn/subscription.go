@@ -0,0 +1,188 @@
+package redisconn
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"order/metrics"
+	"order/retry"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// pingInterval is how long a subscription may go without hearing from Redis
+// before it is pinged. If the ping isn't answered within another interval
+// the connection is taken to be lost.
+const pingInterval = 5 * time.Second
+
+var errNotSubscribed = errors.New("not subscribed, reconnecting to Redis")
+
+// Subscription is a subscription to a channel that outlives its connection:
+// when the connection drops it is subscribed again on a new one, with
+// backoff, and messages keep coming on the same Go channel. Messages
+// published while it is down are lost, as with any Redis subscription.
+type Subscription struct {
+	rdb      *redis.Client
+	channel  string
+	backoff  retry.Backoff
+	messages chan *redis.Message
+	done     chan struct{}
+	stopped  chan struct{}
+	close    sync.Once
+
+	mu         sync.Mutex
+	pubsub     *redis.PubSub // the current connection, to be closed by Close
+	subscribed bool
+}
+
+// Subscribe subscribes to the channel, in the background, until the
+// subscription is closed
+func Subscribe(rdb *redis.Client, channel string) *Subscription {
+	return subscribe(rdb, channel, retry.Backoff{})
+}
+
+func subscribe(rdb *redis.Client, channel string, backoff retry.Backoff) *Subscription {
+	s := &Subscription{
+		rdb:      rdb,
+		channel:  channel,
+		backoff:  backoff,
+		messages: make(chan *redis.Message, 100),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Channel returns the messages of the subscription; it is closed once the
+// subscription is
+func (s *Subscription) Channel() <-chan *redis.Message {
+	return s.messages
+}
+
+// Ping pings Redis over the subscription's connection. It fails while the
+// subscription is reconnecting, and once it has been closed.
+func (s *Subscription) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	pubsub, subscribed := s.pubsub, s.subscribed
+	s.mu.Unlock()
+	if !subscribed {
+		return errNotSubscribed
+	}
+	return pubsub.Ping(ctx)
+}
+
+// Close ends the subscription and waits for its channel to be closed
+func (s *Subscription) Close() error {
+	var err error
+	s.close.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		if s.pubsub != nil {
+			err = s.pubsub.Close()
+		}
+		s.mu.Unlock()
+	})
+	<-s.stopped
+	return err
+}
+
+func (s *Subscription) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run subscribes, and subscribes again whenever the connection is lost,
+// until the subscription is closed
+func (s *Subscription) run() {
+	defer close(s.stopped)
+	defer close(s.messages)
+	ctx := context.Background()
+
+	for lost := false; ; lost = true {
+		s.mu.Lock()
+		if s.closed() {
+			s.mu.Unlock()
+			return
+		}
+		pubsub := s.rdb.Subscribe(ctx)
+		s.pubsub = pubsub
+		s.mu.Unlock()
+
+		// The confirmation of the subscription is its first message
+		err := pubsub.Subscribe(ctx, s.channel)
+		if err == nil {
+			_, err = pubsub.ReceiveTimeout(ctx, pingInterval)
+		}
+		if err == nil {
+			s.setSubscribed(true)
+			if lost {
+				slog.Info("Resubscribed to Redis channel", "channel", s.channel)
+			}
+			s.backoff.Reset()
+			err = s.receive(ctx, pubsub)
+			s.setSubscribed(false)
+		}
+		pubsub.Close()
+		if s.closed() {
+			return
+		}
+
+		delay := s.backoff.Next()
+		metrics.Resubscriptions.WithLabelValues(s.channel).Inc()
+		slog.Warn("Redis subscription lost, resubscribing", "channel", s.channel, "retry_in", delay.String(), "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (s *Subscription) setSubscribed(subscribed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribed = subscribed
+}
+
+// receive delivers the messages of the subscription until its connection
+// fails or is closed
+func (s *Subscription) receive(ctx context.Context, pubsub *redis.PubSub) error {
+	pinged := false
+	for {
+		msg, err := pubsub.ReceiveTimeout(ctx, pingInterval)
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout():
+			if pinged {
+				return errors.New("no reply to ping")
+			}
+			if err := pubsub.Ping(ctx); err != nil {
+				return err
+			}
+			pinged = true
+			continue
+		case err != nil:
+			return err
+		}
+
+		pinged = false
+		if msg, ok := msg.(*redis.Message); ok {
+			select {
+			case s.messages <- msg:
+			case <-s.done:
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package redisconn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"order/retry"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+// receive publishes until the subscription gets the message, since it may
+// be resubscribing
+func receive(t *testing.T, mr *miniredis.Miniredis, s *Subscription, payload string) {
+	deadline := time.After(5 * time.Second)
+	for {
+		mr.Publish("results", payload)
+		select {
+		case msg := <-s.Channel():
+			assert.Equal(t, payload, msg.Payload)
+			return
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("no message received")
+		}
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when starting miniredis", err)
+	}
+	defer mr.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer rdb.Close()
+	ctx := context.Background()
+
+	s := subscribe(rdb, "results", retry.Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond})
+
+	t.Run("messages are delivered", func(t *testing.T) {
+		receive(t, mr, s, "first")
+		assert.NoError(t, s.Ping(ctx))
+	})
+
+	t.Run("dropped subscriptions are resubscribed", func(t *testing.T) {
+		mr.Close()
+		assert.Eventually(t, func() bool { return s.Ping(ctx) != nil }, time.Second, 10*time.Millisecond)
+
+		assert.NoError(t, mr.Restart())
+		receive(t, mr, s, "second")
+		assert.Eventually(t, func() bool { return s.Ping(ctx) == nil }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("closing closes the channel", func(t *testing.T) {
+		s.Close()
+		_, open := <-s.Channel()
+		assert.False(t, open)
+		assert.Error(t, s.Ping(ctx))
+	})
+}
@@ -0,0 +1,293 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"order/models"
+
+	"github.com/google/uuid"
+)
+
+// Errors of the in-memory repositories, standing in for the foreign key
+// violations Postgres reports
+var (
+	ErrUnknownCustomer = errors.New("customer does not exist")
+	ErrUnknownProduct  = errors.New("product does not exist")
+)
+
+// Memory holds orders, products and customers in memory. It enforces the
+// same references as the database, so that tests can exercise failures.
+type Memory struct {
+	mu        sync.Mutex
+	orders    map[uuid.UUID]models.OrderRead
+	products  map[uuid.UUID]models.ProductRead
+	customers map[uuid.UUID]models.Customer
+	addresses map[uuid.UUID]memoryAddress
+	events    []models.OrderEvent
+	now       func() time.Time
+}
+
+type memoryAddress struct {
+	customerID  uuid.UUID
+	addressType models.AddressType
+	snapshot    models.AddressSnapshot
+}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		orders:    make(map[uuid.UUID]models.OrderRead),
+		products:  make(map[uuid.UUID]models.ProductRead),
+		customers: make(map[uuid.UUID]models.Customer),
+		addresses: make(map[uuid.UUID]memoryAddress),
+		now:       time.Now,
+	}
+}
+
+// Repositories returns the repositories backed by m
+func (m *Memory) Repositories() Repositories {
+	return Repositories{
+		Orders:    (*memoryOrders)(m),
+		Products:  (*memoryProducts)(m),
+		Customers: (*memoryCustomers)(m),
+	}
+}
+
+// AddCustomer adds a customer
+func (m *Memory) AddCustomer(id uuid.UUID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.customers[id] = models.Customer{ID: id, CreatedAt: now, UpdatedAt: now}
+}
+
+// AddAddress adds an address to the customer's address book
+func (m *Memory) AddAddress(id, customerID uuid.UUID, addressType models.AddressType, snapshot models.AddressSnapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addresses[id] = memoryAddress{customerID: customerID, addressType: addressType, snapshot: snapshot}
+}
+
+// AddProduct adds a product as it is
+func (m *Memory) AddProduct(product models.ProductRead) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.products[product.ID] = product
+}
+
+// AddOrder adds an order as it is, without checking its references
+func (m *Memory) AddOrder(order models.OrderRead) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.orders[order.ID] = order
+}
+
+// AddEvent appends an event to the status history
+func (m *Memory) AddEvent(event models.OrderEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+}
+
+// newOrder checks the order's references and builds it, without storing it
+func (m *Memory) newOrder(order NewOrder, now time.Time) (models.OrderRead, error) {
+	if _, ok := m.customers[order.CustomerID]; !ok {
+		return models.OrderRead{}, ErrUnknownCustomer
+	}
+	if _, ok := m.products[order.ProductID]; !ok {
+		return models.OrderRead{}, ErrUnknownProduct
+	}
+	return models.OrderRead{
+		ID:                newID(),
+		CustomerID:        order.CustomerID,
+		ProductID:         order.ProductID,
+		Status:            models.Pending,
+		Amount:            order.Amount,
+		ShippingAddressID: order.ShippingAddressID,
+		ShippingAddress:   order.ShippingAddress,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}, nil
+}
+
+type memoryOrders Memory
+
+func (r *memoryOrders) Create(ctx context.Context, order NewOrder) (models.OrderRead, error) {
+	created, err := r.CreateBatch(ctx, []NewOrder{order})
+	if err != nil {
+		return models.OrderRead{}, err
+	}
+	return created[0], nil
+}
+
+func (r *memoryOrders) CreateBatch(ctx context.Context, orders []NewOrder) ([]models.OrderRead, error) {
+	m := (*Memory)(r)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	created := make([]models.OrderRead, len(orders))
+	for i, order := range orders {
+		orderRead, err := m.newOrder(order, now)
+		if err != nil {
+			return nil, err
+		}
+		created[i] = orderRead
+	}
+	for _, orderRead := range created {
+		m.orders[orderRead.ID] = orderRead
+	}
+	return created, nil
+}
+
+func (r *memoryOrders) Get(ctx context.Context, id uuid.UUID) (models.OrderRead, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	orderRead, ok := r.orders[id]
+	if !ok {
+		return models.OrderRead{}, ErrNotFound
+	}
+	return orderRead, nil
+}
+
+func (r *memoryOrders) List(ctx context.Context, filter OrderFilter) ([]models.OrderRead, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	orders := []models.OrderRead{}
+	for _, orderRead := range r.orders {
+		if filter.CustomerID != nil && orderRead.CustomerID != *filter.CustomerID {
+			continue
+		}
+		if filter.Status != "" && orderRead.Status != filter.Status {
+			continue
+		}
+		orders = append(orders, orderRead)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+			return orders[i].CreatedAt.After(orders[j].CreatedAt)
+		}
+		return orders[i].ID.String() < orders[j].ID.String()
+	})
+
+	if filter.Offset >= len(orders) {
+		return []models.OrderRead{}, nil
+	}
+	orders = orders[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(orders) {
+		orders = orders[:filter.Limit]
+	}
+	return orders, nil
+}
+
+func (r *memoryOrders) ListByCustomers(ctx context.Context, customerIDs []uuid.UUID, filter OrderFilter) ([]models.OrderRead, error) {
+	orders := []models.OrderRead{}
+	for _, customerID := range customerIDs {
+		customerID := customerID
+		filter.CustomerID = &customerID
+		page, err := r.List(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, page...)
+	}
+	return orders, nil
+}
+
+func (r *memoryOrders) History(ctx context.Context, orderID uuid.UUID, afterID int64) ([]models.OrderEvent, error) {
+	return r.history(func(event models.OrderEvent) bool { return event.OrderID == orderID }, afterID), nil
+}
+
+func (r *memoryOrders) CustomerHistory(ctx context.Context, customerID uuid.UUID, afterID int64) ([]models.OrderEvent, error) {
+	return r.history(func(event models.OrderEvent) bool { return event.CustomerID == customerID }, afterID), nil
+}
+
+// history returns the matching events after afterID, by ID
+func (r *memoryOrders) history(match func(models.OrderEvent) bool, afterID int64) []models.OrderEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var history []models.OrderEvent
+	for _, event := range r.events {
+		if match(event) && event.ID > afterID {
+			history = append(history, event)
+		}
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].ID < history[j].ID })
+	return history
+}
+
+type memoryProducts Memory
+
+func (r *memoryProducts) Create(ctx context.Context, product models.ProductWrite) (models.ProductRead, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	productRead := models.ProductRead{ID: newID(), Name: product.Name, Price: product.Price, CreatedAt: now, UpdatedAt: now}
+	r.products[productRead.ID] = productRead
+	return productRead, nil
+}
+
+func (r *memoryProducts) Get(ctx context.Context, id uuid.UUID) (models.ProductRead, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	productRead, ok := r.products[id]
+	if !ok {
+		return models.ProductRead{}, ErrNotFound
+	}
+	return productRead, nil
+}
+
+func (r *memoryProducts) Update(ctx context.Context, id uuid.UUID, product models.ProductWrite) (models.ProductRead, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	productRead, ok := r.products[id]
+	if !ok {
+		return models.ProductRead{}, ErrNotFound
+	}
+	productRead.Name, productRead.Price, productRead.UpdatedAt = product.Name, product.Price, r.now()
+	r.products[id] = productRead
+	return productRead, nil
+}
+
+func (r *memoryProducts) GetMany(ctx context.Context, ids []uuid.UUID) ([]models.ProductRead, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	products := []models.ProductRead{}
+	for _, id := range ids {
+		if productRead, ok := r.products[id]; ok {
+			products = append(products, productRead)
+		}
+	}
+	return products, nil
+}
+
+type memoryCustomers Memory
+
+func (r *memoryCustomers) GetMany(ctx context.Context, ids []uuid.UUID) ([]models.Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	customers := []models.Customer{}
+	for _, id := range ids {
+		if customer, ok := r.customers[id]; ok {
+			customers = append(customers, customer)
+		}
+	}
+	return customers, nil
+}
+
+func (r *memoryCustomers) ShippingAddress(ctx context.Context, customerID, addressID uuid.UUID) (*models.AddressSnapshot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	address, ok := r.addresses[addressID]
+	if !ok || address.customerID != customerID || address.addressType != models.Shipping {
+		return nil, ErrNotFound
+	}
+	snapshot := address.snapshot
+	return &snapshot, nil
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"order/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	store := NewMemory()
+	repos := store.Repositories()
+	ctx := context.Background()
+
+	customerID := uuid.New()
+	store.AddCustomer(customerID)
+	product, err := repos.Products.Create(ctx, models.ProductWrite{Name: "Widget", Price: 9.99})
+	assert.NoError(t, err)
+
+	t.Run("batches are all or nothing", func(t *testing.T) {
+		_, err := repos.Orders.CreateBatch(ctx, []NewOrder{
+			{OrderWrite: models.OrderWrite{CustomerID: customerID, ProductID: product.ID, Amount: 1}},
+			{OrderWrite: models.OrderWrite{CustomerID: uuid.New(), ProductID: product.ID, Amount: 2}},
+		})
+		assert.Equal(t, ErrUnknownCustomer, err)
+
+		list, err := repos.Orders.List(ctx, OrderFilter{})
+		assert.NoError(t, err)
+		assert.Empty(t, list)
+	})
+
+	t.Run("created orders can be read back", func(t *testing.T) {
+		created, err := repos.Orders.Create(ctx, NewOrder{OrderWrite: models.OrderWrite{CustomerID: customerID, ProductID: product.ID, Amount: 5}})
+		assert.NoError(t, err)
+		assert.Equal(t, models.Pending, created.Status)
+
+		orderRead, err := repos.Orders.Get(ctx, created.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, created, orderRead)
+
+		_, err = repos.Orders.Get(ctx, uuid.New())
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("pages of several customers", func(t *testing.T) {
+		otherID := uuid.New()
+		store.AddCustomer(otherID)
+		for _, id := range []uuid.UUID{customerID, customerID, otherID} {
+			_, err := repos.Orders.Create(ctx, NewOrder{OrderWrite: models.OrderWrite{CustomerID: id, ProductID: product.ID, Amount: 1}})
+			assert.NoError(t, err)
+		}
+
+		list, err := repos.Orders.ListByCustomers(ctx, []uuid.UUID{customerID, otherID}, OrderFilter{Limit: 1})
+		assert.NoError(t, err)
+		if assert.Len(t, list, 2) {
+			assert.Equal(t, customerID, list[0].CustomerID)
+			assert.Equal(t, otherID, list[1].CustomerID)
+		}
+
+		customers, err := repos.Customers.GetMany(ctx, []uuid.UUID{otherID, uuid.New()})
+		assert.NoError(t, err)
+		if assert.Len(t, customers, 1) {
+			assert.Equal(t, otherID, customers[0].ID)
+		}
+	})
+
+	t.Run("history after an event", func(t *testing.T) {
+		orderID := uuid.New()
+		store.AddEvent(models.OrderEvent{ID: 3, OrderID: orderID, CustomerID: customerID, Status: models.Completed})
+		store.AddEvent(models.OrderEvent{ID: 1, OrderID: orderID, CustomerID: customerID, Status: models.Pending})
+		store.AddEvent(models.OrderEvent{ID: 2, OrderID: uuid.New(), CustomerID: customerID, Status: models.Pending})
+
+		history, err := repos.Orders.History(ctx, orderID, 0)
+		assert.NoError(t, err)
+		if assert.Len(t, history, 2) {
+			assert.Equal(t, int64(1), history[0].ID)
+			assert.Equal(t, int64(3), history[1].ID)
+		}
+
+		history, err = repos.Orders.History(ctx, orderID, 1)
+		assert.NoError(t, err)
+		assert.Len(t, history, 1)
+
+		history, err = repos.Orders.CustomerHistory(ctx, customerID, 1)
+		assert.NoError(t, err)
+		if assert.Len(t, history, 2) {
+			assert.Equal(t, int64(2), history[0].ID)
+			assert.Equal(t, int64(3), history[1].ID)
+		}
+	})
+
+	t.Run("shipping addresses belong to their customer", func(t *testing.T) {
+		addressID, billingID := uuid.New(), uuid.New()
+		store.AddAddress(addressID, customerID, models.Shipping, models.AddressSnapshot{Line1: "96 Mowat Ave"})
+		store.AddAddress(billingID, customerID, models.Billing, models.AddressSnapshot{Line1: "1 Yonge St"})
+
+		snapshot, err := repos.Customers.ShippingAddress(ctx, customerID, addressID)
+		assert.NoError(t, err)
+		assert.Equal(t, "96 Mowat Ave", snapshot.Line1)
+
+		_, err = repos.Customers.ShippingAddress(ctx, uuid.New(), addressID)
+		assert.Equal(t, ErrNotFound, err)
+		_, err = repos.Customers.ShippingAddress(ctx, customerID, billingID)
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
@@ -0,0 +1,374 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"order/database"
+	"order/events"
+	"order/models"
+
+	"github.com/google/uuid"
+)
+
+// newID generates the IDs of orders created in batches
+var newID = uuid.New
+
+const orderColumns = "id, customer_id, product_id, status, amount, shipping_address_id, shipping_address, created_at, updated_at"
+
+func scanOrder(row interface{ Scan(...interface{}) error }, o *models.OrderRead) error {
+	return row.Scan(&o.ID, &o.CustomerID, &o.ProductID, &o.Status, &o.Amount, &o.ShippingAddressID, &o.ShippingAddress, &o.CreatedAt, &o.UpdatedAt)
+}
+
+// placeholders returns the placeholders of n arguments, e.g. "$1, $2"
+func placeholders(n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return strings.Join(list, ", ")
+}
+
+// uuidArgs returns ids as query arguments
+func uuidArgs(ids []uuid.UUID) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+const productColumns = "id, name, price, created_at, updated_at"
+
+func scanProduct(row interface{ Scan(...interface{}) error }, p *models.ProductRead) error {
+	return row.Scan(&p.ID, &p.Name, &p.Price, &p.CreatedAt, &p.UpdatedAt)
+}
+
+// NewPostgres returns the repositories stored in db
+func NewPostgres(db *sql.DB) Repositories {
+	return NewPostgresCluster(database.NewCluster(db, nil, nil, 0))
+}
+
+// NewPostgresCluster returns the repositories stored in the cluster, which
+// are read from its replicas
+func NewPostgresCluster(cluster *database.Cluster) Repositories {
+	return Repositories{
+		Orders:    &PostgresOrders{cluster: cluster},
+		Products:  &PostgresProducts{cluster: cluster},
+		Customers: &PostgresCustomers{cluster: cluster},
+	}
+}
+
+// OrderKey, CustomerKey and ProductKey name records for
+// database.Cluster.Written. A customer is written when one of its orders is.
+func OrderKey(id uuid.UUID) string    { return "order:" + id.String() }
+func CustomerKey(id uuid.UUID) string { return "customer:" + id.String() }
+func ProductKey(id uuid.UUID) string  { return "product:" + id.String() }
+
+// read runs query on the database the cluster picks to read the records
+// named by keys from, and again on the primary if a replica couldn't serve
+// it
+func read(ctx context.Context, cluster *database.Cluster, keys []string, query func(db *sql.DB) error) error {
+	db := cluster.Reader(ctx, keys...)
+	err := query(db)
+	if err != nil && db != cluster.Primary() && database.IsUnavailable(err) {
+		cluster.Failed(db, err)
+		return query(cluster.Primary())
+	}
+	return err
+}
+
+// PostgresOrders stores orders in the orders table
+type PostgresOrders struct {
+	cluster *database.Cluster
+}
+
+func (r *PostgresOrders) Create(ctx context.Context, order NewOrder) (models.OrderRead, error) {
+	var orderRead models.OrderRead
+	err := scanOrder(r.cluster.Primary().QueryRowContext(ctx,
+		"INSERT INTO orders (customer_id, product_id, status, amount, shipping_address_id, shipping_address, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW()) RETURNING "+orderColumns,
+		order.CustomerID, order.ProductID, models.Pending, order.Amount, order.ShippingAddressID, order.ShippingAddress), &orderRead)
+	if err == nil {
+		r.cluster.Written(ctx, OrderKey(orderRead.ID), CustomerKey(orderRead.CustomerID))
+	}
+	return orderRead, err
+}
+
+// CreateBatch inserts the orders in a single statement
+func (r *PostgresOrders) CreateBatch(ctx context.Context, orders []NewOrder) ([]models.OrderRead, error) {
+	// IDs are assigned up front so returned orders can be matched to the
+	// input regardless of the order RETURNING yields them in.
+	index := make(map[uuid.UUID]int, len(orders))
+	values := make([]string, 0, len(orders))
+	args := make([]interface{}, 0, len(orders)*7)
+	for i, order := range orders {
+		id := newID()
+		index[id] = i
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, NOW(), NOW())", n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+		args = append(args, id, order.CustomerID, order.ProductID, models.Pending, order.Amount, order.ShippingAddressID, order.ShippingAddress)
+	}
+
+	rows, err := r.cluster.Primary().QueryContext(ctx,
+		"INSERT INTO orders (id, customer_id, product_id, status, amount, shipping_address_id, shipping_address, created_at, updated_at) VALUES "+strings.Join(values, ", ")+" RETURNING "+orderColumns,
+		args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	created := make([]models.OrderRead, len(orders))
+	for rows.Next() {
+		var orderRead models.OrderRead
+		if err := scanOrder(rows, &orderRead); err != nil {
+			return nil, err
+		}
+		created[index[orderRead.ID]] = orderRead
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, 2*len(created))
+	for _, orderRead := range created {
+		keys = append(keys, OrderKey(orderRead.ID), CustomerKey(orderRead.CustomerID))
+	}
+	r.cluster.Written(ctx, keys...)
+	return created, nil
+}
+
+func (r *PostgresOrders) Get(ctx context.Context, id uuid.UUID) (models.OrderRead, error) {
+	var orderRead models.OrderRead
+	err := read(ctx, r.cluster, []string{OrderKey(id)}, func(db *sql.DB) error {
+		return scanOrder(db.QueryRowContext(ctx, "SELECT "+orderColumns+" FROM orders WHERE id = $1", id), &orderRead)
+	})
+	if err == sql.ErrNoRows {
+		return models.OrderRead{}, ErrNotFound
+	}
+	return orderRead, err
+}
+
+func (r *PostgresOrders) List(ctx context.Context, filter OrderFilter) ([]models.OrderRead, error) {
+	var conditions []string
+	var args []interface{}
+	var keys []string
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.CustomerID != nil {
+		where("customer_id = $%d", *filter.CustomerID)
+		keys = append(keys, CustomerKey(*filter.CustomerID))
+	}
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+
+	query := "SELECT " + orderColumns + " FROM orders"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query += fmt.Sprintf(" OFFSET %d", filter.Offset)
+	}
+
+	return r.query(ctx, keys, query, args...)
+}
+
+// ListByCustomers numbers each customer's orders to page them all in a
+// single statement
+func (r *PostgresOrders) ListByCustomers(ctx context.Context, customerIDs []uuid.UUID, filter OrderFilter) ([]models.OrderRead, error) {
+	if len(customerIDs) == 0 {
+		return []models.OrderRead{}, nil
+	}
+	args := uuidArgs(customerIDs)
+	query := "SELECT " + orderColumns + ", ROW_NUMBER() OVER (PARTITION BY customer_id ORDER BY created_at DESC, id) AS n FROM orders WHERE customer_id IN (" + placeholders(len(args)) + ")"
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	query = "SELECT " + orderColumns + " FROM (" + query + ") numbered WHERE n > " + strconv.Itoa(filter.Offset)
+	if filter.Limit > 0 {
+		query += " AND n <= " + strconv.Itoa(filter.Offset+filter.Limit)
+	}
+	query += " ORDER BY customer_id, n"
+	keys := make([]string, len(customerIDs))
+	for i, id := range customerIDs {
+		keys[i] = CustomerKey(id)
+	}
+	return r.query(ctx, keys, query, args...)
+}
+
+// query reads orders, from the primary if any of the records named by keys
+// was written recently
+func (r *PostgresOrders) query(ctx context.Context, keys []string, query string, args ...interface{}) ([]models.OrderRead, error) {
+	var orders []models.OrderRead
+	err := read(ctx, r.cluster, keys, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		orders = []models.OrderRead{}
+		for rows.Next() {
+			var orderRead models.OrderRead
+			if err := scanOrder(rows, &orderRead); err != nil {
+				return err
+			}
+			orders = append(orders, orderRead)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (r *PostgresOrders) History(ctx context.Context, orderID uuid.UUID, afterID int64) ([]models.OrderEvent, error) {
+	var history []models.OrderEvent
+	err := read(ctx, r.cluster, []string{OrderKey(orderID)}, func(db *sql.DB) error {
+		var err error
+		history, err = events.OrderHistory(ctx, db, orderID, afterID)
+		return err
+	})
+	return history, err
+}
+
+func (r *PostgresOrders) CustomerHistory(ctx context.Context, customerID uuid.UUID, afterID int64) ([]models.OrderEvent, error) {
+	var history []models.OrderEvent
+	err := read(ctx, r.cluster, []string{CustomerKey(customerID)}, func(db *sql.DB) error {
+		var err error
+		history, err = events.CustomerHistory(ctx, db, customerID, afterID)
+		return err
+	})
+	return history, err
+}
+
+// PostgresProducts stores products in the products table
+type PostgresProducts struct {
+	cluster *database.Cluster
+}
+
+func (r *PostgresProducts) Create(ctx context.Context, product models.ProductWrite) (models.ProductRead, error) {
+	var productRead models.ProductRead
+	err := scanProduct(r.cluster.Primary().QueryRowContext(ctx, "INSERT INTO products (name, price) VALUES ($1, $2) RETURNING "+productColumns, product.Name, product.Price), &productRead)
+	if err == nil {
+		r.cluster.Written(ctx, ProductKey(productRead.ID))
+	}
+	return productRead, err
+}
+
+func (r *PostgresProducts) Get(ctx context.Context, id uuid.UUID) (models.ProductRead, error) {
+	var productRead models.ProductRead
+	err := read(ctx, r.cluster, []string{ProductKey(id)}, func(db *sql.DB) error {
+		return scanProduct(db.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = $1", id), &productRead)
+	})
+	if err == sql.ErrNoRows {
+		return models.ProductRead{}, ErrNotFound
+	}
+	return productRead, err
+}
+
+func (r *PostgresProducts) Update(ctx context.Context, id uuid.UUID, product models.ProductWrite) (models.ProductRead, error) {
+	var productRead models.ProductRead
+	err := scanProduct(r.cluster.Primary().QueryRowContext(ctx, "UPDATE products SET name = $2, price = $3, updated_at = NOW() WHERE id = $1 RETURNING "+productColumns, id, product.Name, product.Price), &productRead)
+	if err == sql.ErrNoRows {
+		return models.ProductRead{}, ErrNotFound
+	}
+	if err == nil {
+		r.cluster.Written(ctx, ProductKey(id))
+	}
+	return productRead, err
+}
+
+func (r *PostgresProducts) GetMany(ctx context.Context, ids []uuid.UUID) ([]models.ProductRead, error) {
+	products := []models.ProductRead{}
+	if len(ids) == 0 {
+		return products, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = ProductKey(id)
+	}
+	err := read(ctx, r.cluster, keys, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, "SELECT "+productColumns+" FROM products WHERE id IN ("+placeholders(len(ids))+")", uuidArgs(ids)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		products = products[:0]
+		for rows.Next() {
+			var productRead models.ProductRead
+			if err := scanProduct(rows, &productRead); err != nil {
+				return err
+			}
+			products = append(products, productRead)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// PostgresCustomers reads the customers and customer_addresses tables.
+// Customers aren't written by the service, so they are always read from
+// replicas; addresses are read from the primary, as they are checked when
+// orders are placed right after they were added.
+type PostgresCustomers struct {
+	cluster *database.Cluster
+}
+
+func (r *PostgresCustomers) GetMany(ctx context.Context, ids []uuid.UUID) ([]models.Customer, error) {
+	customers := []models.Customer{}
+	if len(ids) == 0 {
+		return customers, nil
+	}
+	err := read(ctx, r.cluster, nil, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, "SELECT id, name, created_at, updated_at FROM customers WHERE id IN ("+placeholders(len(ids))+")", uuidArgs(ids)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		customers = customers[:0]
+		for rows.Next() {
+			var customer models.Customer
+			if err := rows.Scan(&customer.ID, &customer.Name, &customer.CreatedAt, &customer.UpdatedAt); err != nil {
+				return err
+			}
+			customers = append(customers, customer)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return customers, nil
+}
+
+func (r *PostgresCustomers) ShippingAddress(ctx context.Context, customerID, addressID uuid.UUID) (*models.AddressSnapshot, error) {
+	var snapshot models.AddressSnapshot
+	err := r.cluster.Primary().QueryRowContext(ctx,
+		"SELECT line1, line2, city, region, postal_code, country FROM customer_addresses WHERE id = $1 AND customer_id = $2 AND type = $3",
+		addressID, customerID, models.Shipping).
+		Scan(&snapshot.Line1, &snapshot.Line2, &snapshot.City, &snapshot.Region, &snapshot.PostalCode, &snapshot.Country)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,325 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"order/database"
+	"order/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+var orderRowColumns = []string{"id", "customer_id", "product_id", "status", "amount", "shipping_address_id", "shipping_address", "created_at", "updated_at"}
+
+// sequentialIDs makes batches assign the given IDs in order
+func sequentialIDs(t *testing.T, ids ...uuid.UUID) {
+	original := newID
+	t.Cleanup(func() { newID = original })
+	newID = func() uuid.UUID {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+}
+
+func TestPostgresOrders(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orders := NewPostgres(db).Orders
+	ctx := context.Background()
+
+	t.Run("create with shipping address", func(t *testing.T) {
+		addressID := uuid.New()
+		snapshot := &models.AddressSnapshot{Line1: "96 Mowat Ave", City: "Toronto", Country: "CA"}
+		snapshotJSON, _ := json.Marshal(snapshot)
+		order := NewOrder{OrderWrite: models.OrderWrite{CustomerID: uuid.New(), ProductID: uuid.New(), Amount: 10, ShippingAddressID: &addressID}, ShippingAddress: snapshot}
+		orderID := uuid.New()
+		now := time.Now()
+
+		mock.ExpectQuery("INSERT INTO orders \\(customer_id, product_id, status, amount, shipping_address_id, shipping_address, created_at, updated_at\\) VALUES").
+			WithArgs(order.CustomerID, order.ProductID, models.Pending, order.Amount, &addressID, snapshotJSON).
+			WillReturnRows(sqlmock.NewRows(orderRowColumns).
+				AddRow(orderID, order.CustomerID, order.ProductID, models.Pending, order.Amount, addressID, snapshotJSON, now, now))
+
+		orderRead, err := orders.Create(ctx, order)
+		assert.NoError(t, err)
+		assert.Equal(t, orderID, orderRead.ID)
+		assert.Equal(t, snapshot, orderRead.ShippingAddress)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("create batch keeps the input order", func(t *testing.T) {
+		first, second := uuid.New(), uuid.New()
+		sequentialIDs(t, first, second)
+		customerID, productID := uuid.New(), uuid.New()
+		now := time.Now()
+
+		// RETURNING yields the rows in reverse
+		mock.ExpectQuery("INSERT INTO orders \\(id, (.+)\\) VALUES \\(\\$1, (.+)\\), \\(\\$8, (.+)\\) RETURNING").
+			WithArgs(first, customerID, productID, models.Pending, 1.0, nil, nil, second, customerID, productID, models.Pending, 2.0, nil, nil).
+			WillReturnRows(sqlmock.NewRows(orderRowColumns).
+				AddRow(second, customerID, productID, models.Pending, 2.0, nil, nil, now, now).
+				AddRow(first, customerID, productID, models.Pending, 1.0, nil, nil, now, now))
+
+		created, err := orders.CreateBatch(ctx, []NewOrder{
+			{OrderWrite: models.OrderWrite{CustomerID: customerID, ProductID: productID, Amount: 1}},
+			{OrderWrite: models.OrderWrite{CustomerID: customerID, ProductID: productID, Amount: 2}},
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, created, 2) {
+			assert.Equal(t, first, created[0].ID)
+			assert.Equal(t, second, created[1].ID)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("get missing order", func(t *testing.T) {
+		orderID := uuid.New()
+
+		mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1").
+			WithArgs(orderID).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := orders.Get(ctx, orderID)
+		assert.Equal(t, ErrNotFound, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("list with filters", func(t *testing.T) {
+		customerID := uuid.New()
+		now := time.Now()
+
+		mock.ExpectQuery("SELECT (.+) FROM orders WHERE customer_id = \\$1 AND status = \\$2 ORDER BY created_at DESC, id LIMIT 10 OFFSET 20").
+			WithArgs(customerID, models.Completed).
+			WillReturnRows(sqlmock.NewRows(orderRowColumns).
+				AddRow(uuid.New(), customerID, uuid.New(), models.Completed, 10.0, nil, nil, now, now))
+
+		list, err := orders.List(ctx, OrderFilter{CustomerID: &customerID, Status: models.Completed, Limit: 10, Offset: 20})
+		assert.NoError(t, err)
+		assert.Len(t, list, 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("list everything", func(t *testing.T) {
+		mock.ExpectQuery("SELECT (.+) FROM orders ORDER BY created_at DESC, id$").
+			WillReturnRows(sqlmock.NewRows(orderRowColumns))
+
+		list, err := orders.List(ctx, OrderFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, []models.OrderRead{}, list)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("list pages of several customers", func(t *testing.T) {
+		first, second := uuid.New(), uuid.New()
+		now := time.Now()
+
+		mock.ExpectQuery("SELECT (.+) FROM \\(SELECT (.+) OVER \\(PARTITION BY customer_id ORDER BY created_at DESC, id\\) AS n FROM orders WHERE customer_id IN \\(\\$1, \\$2\\) AND status = \\$3\\) numbered WHERE n > 5 AND n <= 15 ORDER BY customer_id, n").
+			WithArgs(first, second, models.Completed).
+			WillReturnRows(sqlmock.NewRows(orderRowColumns).
+				AddRow(uuid.New(), first, uuid.New(), models.Completed, 10.0, nil, nil, now, now).
+				AddRow(uuid.New(), second, uuid.New(), models.Completed, 20.0, nil, nil, now, now))
+
+		list, err := orders.ListByCustomers(ctx, []uuid.UUID{first, second}, OrderFilter{Status: models.Completed, Limit: 10, Offset: 5})
+		assert.NoError(t, err)
+		assert.Len(t, list, 2)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("list pages of no customers", func(t *testing.T) {
+		list, err := orders.ListByCustomers(ctx, nil, OrderFilter{Limit: 10})
+		assert.NoError(t, err)
+		assert.Empty(t, list)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresProducts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	products := NewPostgres(db).Products
+	productColumns := []string{"id", "name", "price", "created_at", "updated_at"}
+
+	t.Run("create", func(t *testing.T) {
+		productID := uuid.New()
+
+		mock.ExpectQuery("INSERT INTO products \\(name, price\\) VALUES \\(\\$1, \\$2\\)").
+			WithArgs("Widget", 9.99).
+			WillReturnRows(sqlmock.NewRows(productColumns).AddRow(productID, "Widget", 9.99, time.Now(), time.Now()))
+
+		productRead, err := products.Create(context.Background(), models.ProductWrite{Name: "Widget", Price: 9.99})
+		assert.NoError(t, err)
+		assert.Equal(t, productID, productRead.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("get missing product", func(t *testing.T) {
+		productID := uuid.New()
+
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = \\$1").
+			WithArgs(productID).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := products.Get(context.Background(), productID)
+		assert.Equal(t, ErrNotFound, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("get many", func(t *testing.T) {
+		first, second := uuid.New(), uuid.New()
+
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id IN \\(\\$1, \\$2\\)").
+			WithArgs(first, second).
+			WillReturnRows(sqlmock.NewRows(productColumns).AddRow(second, "Gadget", 19.99, time.Now(), time.Now()))
+
+		list, err := products.GetMany(context.Background(), []uuid.UUID{first, second})
+		assert.NoError(t, err)
+		if assert.Len(t, list, 1) {
+			assert.Equal(t, second, list[0].ID)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresCustomers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	customers := NewPostgres(db).Customers
+	customerID, addressID := uuid.New(), uuid.New()
+
+	t.Run("get many", func(t *testing.T) {
+		now := time.Now()
+
+		mock.ExpectQuery("SELECT id, name, created_at, updated_at FROM customers WHERE id IN \\(\\$1\\)").
+			WithArgs(customerID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).AddRow(customerID, "Ada", now, now))
+
+		list, err := customers.GetMany(context.Background(), []uuid.UUID{customerID})
+		assert.NoError(t, err)
+		assert.Equal(t, []models.Customer{{ID: customerID, Name: "Ada", CreatedAt: now, UpdatedAt: now}}, list)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("shipping address", func(t *testing.T) {
+		mock.ExpectQuery("SELECT line1, line2, city, region, postal_code, country FROM customer_addresses WHERE id = \\$1 AND customer_id = \\$2 AND type = \\$3").
+			WithArgs(addressID, customerID, models.Shipping).
+			WillReturnRows(sqlmock.NewRows([]string{"line1", "line2", "city", "region", "postal_code", "country"}).
+				AddRow("96 Mowat Ave", "", "Toronto", "ON", "M6K 3M1", "CA"))
+
+		snapshot, err := customers.ShippingAddress(context.Background(), customerID, addressID)
+		assert.NoError(t, err)
+		assert.Equal(t, &models.AddressSnapshot{Line1: "96 Mowat Ave", City: "Toronto", Region: "ON", PostalCode: "M6K 3M1", Country: "CA"}, snapshot)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("address of another customer", func(t *testing.T) {
+		mock.ExpectQuery("SELECT (.+) FROM customer_addresses").
+			WithArgs(addressID, customerID, models.Shipping).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := customers.ShippingAddress(context.Background(), customerID, addressID)
+		assert.Equal(t, ErrNotFound, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresReplicas(t *testing.T) {
+	primary, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer primary.Close()
+	replica, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer replica.Close()
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when starting miniredis", err)
+	}
+	defer mr.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	ctx := context.Background()
+	cluster := database.NewCluster(primary, []*database.Replica{{Name: "replica", DB: replica}}, database.NewRedisWrites(rdb, time.Minute), 5*time.Second)
+	checkReplica := func() {
+		replicaMock.ExpectQuery("SELECT CASE").WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0))
+		cluster.Check(ctx, time.Second)
+	}
+	checkReplica()
+	orders := NewPostgresCluster(cluster).Orders
+	orderID, customerID, productID := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+	orderRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows(orderRowColumns).AddRow(orderID, customerID, productID, models.Pending, 10.0, nil, nil, now, now)
+	}
+
+	t.Run("reads go to the replica", func(t *testing.T) {
+		replicaMock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1").WithArgs(orderID).WillReturnRows(orderRow())
+
+		_, err := orders.Get(ctx, orderID)
+		assert.NoError(t, err)
+		assert.NoError(t, replicaMock.ExpectationsWereMet())
+	})
+
+	t.Run("reads fail over to the primary", func(t *testing.T) {
+		replicaMock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1").WithArgs(orderID).WillReturnError(&pgconn.PgError{Code: "57P01"})
+		primaryMock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1").WithArgs(orderID).WillReturnRows(orderRow())
+
+		_, err := orders.Get(ctx, orderID)
+		assert.NoError(t, err)
+		assert.NoError(t, replicaMock.ExpectationsWereMet())
+		assert.NoError(t, primaryMock.ExpectationsWereMet())
+
+		// The replica is left out until it passes a check
+		primaryMock.ExpectQuery("SELECT (.+) FROM orders ORDER BY").WillReturnRows(orderRow())
+		_, err = orders.List(ctx, OrderFilter{})
+		assert.NoError(t, err)
+		assert.NoError(t, primaryMock.ExpectationsWereMet())
+		checkReplica()
+	})
+
+	t.Run("orders created are read from the primary", func(t *testing.T) {
+		primaryMock.ExpectQuery("INSERT INTO orders").WillReturnRows(orderRow())
+		_, err := orders.Create(ctx, NewOrder{OrderWrite: models.OrderWrite{CustomerID: customerID, ProductID: productID, Amount: 10}})
+		assert.NoError(t, err)
+
+		primaryMock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1").WithArgs(orderID).WillReturnRows(orderRow())
+		_, err = orders.Get(ctx, orderID)
+		assert.NoError(t, err)
+		primaryMock.ExpectQuery("SELECT (.+) FROM orders WHERE customer_id = \\$1").WithArgs(customerID).WillReturnRows(orderRow())
+		_, err = orders.List(ctx, OrderFilter{CustomerID: &customerID})
+		assert.NoError(t, err)
+		assert.NoError(t, primaryMock.ExpectationsWereMet())
+
+		// Other customers' orders are still read from the replica
+		otherID := uuid.New()
+		replicaMock.ExpectQuery("SELECT (.+) FROM orders WHERE customer_id = \\$1").WithArgs(otherID).WillReturnRows(sqlmock.NewRows(orderRowColumns))
+		_, err = orders.List(ctx, OrderFilter{CustomerID: &otherID})
+		assert.NoError(t, err)
+		assert.NoError(t, replicaMock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,80 @@
+// Package repository keeps the storage of orders, products and customers
+// behind interfaces, with a Postgres implementation for the service and an
+// in-memory one for tests.
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"order/models"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned when the requested record doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// NewOrder is an order to create, with the shipping address snapshot
+// copied onto it, if it has one
+type NewOrder struct {
+	models.OrderWrite
+	ShippingAddress *models.AddressSnapshot
+}
+
+// OrderFilter selects the orders to list. A zero Limit means no limit.
+type OrderFilter struct {
+	CustomerID *uuid.UUID
+	Status     models.OrderStatus
+	Limit      int
+	Offset     int
+}
+
+// OrderRepository stores orders. New orders are pending.
+type OrderRepository interface {
+	Create(ctx context.Context, order NewOrder) (models.OrderRead, error)
+	// CreateBatch creates all of the orders or none of them, and returns
+	// them in the same order
+	CreateBatch(ctx context.Context, orders []NewOrder) ([]models.OrderRead, error)
+	Get(ctx context.Context, id uuid.UUID) (models.OrderRead, error)
+	// List returns the matching orders, newest first
+	List(ctx context.Context, filter OrderFilter) ([]models.OrderRead, error)
+	// ListByCustomers returns the page of filter's Limit and Offset of each
+	// of the customers' matching orders, newest first. filter.CustomerID is
+	// ignored.
+	ListByCustomers(ctx context.Context, customerIDs []uuid.UUID, filter OrderFilter) ([]models.OrderRead, error)
+	// History returns the order's status history after the given event ID
+	History(ctx context.Context, orderID uuid.UUID, afterID int64) ([]models.OrderEvent, error)
+	// CustomerHistory returns the status history of all of the customer's
+	// orders after the given event ID
+	CustomerHistory(ctx context.Context, customerID uuid.UUID, afterID int64) ([]models.OrderEvent, error)
+}
+
+// ProductRepository stores products
+type ProductRepository interface {
+	Create(ctx context.Context, product models.ProductWrite) (models.ProductRead, error)
+	Get(ctx context.Context, id uuid.UUID) (models.ProductRead, error)
+	// Update replaces the product's name and price
+	Update(ctx context.Context, id uuid.UUID, product models.ProductWrite) (models.ProductRead, error)
+	// GetMany returns the products with the given IDs that exist, in no
+	// particular order
+	GetMany(ctx context.Context, ids []uuid.UUID) ([]models.ProductRead, error)
+}
+
+// CustomerRepository reads customers and their address books
+type CustomerRepository interface {
+	// GetMany returns the customers with the given IDs that exist, in no
+	// particular order
+	GetMany(ctx context.Context, ids []uuid.UUID) ([]models.Customer, error)
+	// ShippingAddress returns a snapshot of the customer's shipping address
+	// with the given ID, or ErrNotFound if the customer has no such
+	// shipping address.
+	ShippingAddress(ctx context.Context, customerID, addressID uuid.UUID) (*models.AddressSnapshot, error)
+}
+
+// Repositories are the repositories of one storage backend
+type Repositories struct {
+	Orders    OrderRepository
+	Products  ProductRepository
+	Customers CustomerRepository
+}
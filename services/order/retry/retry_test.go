@@ -0,0 +1,70 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	for _, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		delay := b.Next()
+		want *= time.Millisecond
+		assert.True(t, delay <= want && delay >= want*4/5, "got %s, want about %s", delay, want)
+	}
+
+	b.Reset()
+	assert.LessOrEqual(t, b.Next(), 100*time.Millisecond)
+}
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	backoff := Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond}
+	down := errors.New("connection refused")
+
+	t.Run("retries until it succeeds", func(t *testing.T) {
+		attempts := 0
+		err := Do(ctx, "test", time.Second, backoff, func(ctx context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return down
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("gives up after the timeout", func(t *testing.T) {
+		attempts := 0
+		err := Do(ctx, "test", 20*time.Millisecond, backoff, func(ctx context.Context) error {
+			attempts++
+			return down
+		})
+		assert.ErrorIs(t, err, down)
+		assert.Greater(t, attempts, 1)
+	})
+
+	t.Run("no timeout makes one attempt", func(t *testing.T) {
+		attempts := 0
+		err := Do(ctx, "test", 0, backoff, func(ctx context.Context) error {
+			attempts++
+			return down
+		})
+		assert.Equal(t, down, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("gives up when cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		err := Do(ctx, "test", time.Minute, Backoff{Initial: time.Minute}, func(ctx context.Context) error {
+			return down
+		})
+		assert.ErrorIs(t, err, down)
+	})
+}
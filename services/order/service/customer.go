@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+
+	"order/auth"
+	"order/models"
+	"order/repository"
+
+	"github.com/google/uuid"
+)
+
+// Customers reads customers on behalf of the principal of the context they
+// are given. Customer principals only see themselves.
+type Customers struct {
+	customers repository.CustomerRepository
+}
+
+// NewCustomers returns the customers stored in customers
+func NewCustomers(customers repository.CustomerRepository) *Customers {
+	return &Customers{customers: customers}
+}
+
+// GetMany returns the customers with the given IDs by ID. Missing customers
+// and those the principal may not see are left out.
+func (s *Customers) GetMany(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]models.Customer, error) {
+	principal := auth.FromContext(ctx)
+	var visible []uuid.UUID
+	for _, id := range ids {
+		if principal.MayAccessCustomer(id) {
+			visible = append(visible, id)
+		}
+	}
+
+	customerList, err := s.customers.GetMany(ctx, visible)
+	if err != nil {
+		return nil, storage(err, "Failed to retrieve customers")
+	}
+	customers := make(map[uuid.UUID]models.Customer, len(customerList))
+	for _, customer := range customerList {
+		customers[customer.ID] = customer
+	}
+	return customers, nil
+}
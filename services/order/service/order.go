@@ -0,0 +1,441 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"order/auth"
+	"order/events"
+	"order/logging"
+	"order/metrics"
+	"order/models"
+	"order/ratelimit"
+	"order/repository"
+	"order/tracing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Page sizes of Orders.List
+const (
+	DefaultOrderListLimit = 50
+	MaxOrderListLimit     = 200
+)
+
+// createBatchSize is how many orders CreateMany inserts per statement
+const createBatchSize = 100
+
+// ParseID parses the ID of the named kind of record, e.g. "order", failing
+// with invalid_parameter
+func ParseID(raw, kind string) (uuid.UUID, error) {
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, fail(models.CodeInvalidParameter, "Invalid "+kind+" ID")
+	}
+	return id, nil
+}
+
+// Orders places, reads and watches orders on behalf of the principal of
+// the context they are given. Customer principals are limited to their own
+// orders.
+type Orders struct {
+	orders    repository.OrderRepository
+	customers repository.CustomerRepository
+	broker    *events.Broker
+	rdb       *redis.Client
+	quota     *ratelimit.OrderQuota
+}
+
+// NewOrders returns the orders stored in orders. Payment requests for new
+// orders are published on rdb, and their status changes watched on broker.
+func NewOrders(orders repository.OrderRepository, customers repository.CustomerRepository, broker *events.Broker, rdb *redis.Client, quota *ratelimit.OrderQuota) *Orders {
+	return &Orders{orders: orders, customers: customers, broker: broker, rdb: rdb, quota: quota}
+}
+
+// Create places a pending order and publishes its payment request
+func (s *Orders) Create(ctx context.Context, orderWrite models.OrderWrite) (models.OrderRead, error) {
+	newOrder, err := s.prepare(ctx, orderWrite)
+	if err != nil {
+		return models.OrderRead{}, err
+	}
+
+	reservation := reserveOrders(ctx, s.quota, orderWrite.CustomerID, 1)
+	if reservation.Granted == 0 {
+		return models.OrderRead{}, quotaExceeded(reservation)
+	}
+
+	orderRead, err := s.orders.Create(ctx, newOrder)
+	if err != nil {
+		releaseOrders(ctx, s.quota, reservation, 1)
+		return models.OrderRead{}, storage(err, "Failed to create order")
+	}
+
+	orderCreated(ctx, orderRead, s.rdb)
+	return orderRead, nil
+}
+
+// CreateResult is the outcome of one of the orders of CreateMany: the order
+// created, or the *Error or *StorageError it failed with
+type CreateResult struct {
+	Order models.OrderRead
+	Err   error
+}
+
+// CreateMany places each of the orders as Create would, and publishes the
+// payment requests of those created. Orders succeed or fail on their own;
+// those over their customer's daily quota fail, leaving the earlier ones to
+// be created. The results are in the order of orderWrites.
+func (s *Orders) CreateMany(ctx context.Context, orderWrites []models.OrderWrite) []CreateResult {
+	results := make([]CreateResult, len(orderWrites))
+	newOrders := make([]repository.NewOrder, len(orderWrites))
+	var valid []int
+	for i, orderWrite := range orderWrites {
+		newOrder, err := s.prepare(ctx, orderWrite)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		newOrders[i] = newOrder
+		valid = append(valid, i)
+	}
+
+	// Each customer's orders are reserved at once
+	counts := make(map[uuid.UUID]int)
+	for _, i := range valid {
+		counts[orderWrites[i].CustomerID]++
+	}
+	reservations := make(map[uuid.UUID]ratelimit.Reservation, len(counts))
+	for customerID, n := range counts {
+		reservations[customerID] = reserveOrders(ctx, s.quota, customerID, n)
+	}
+	var reserved []int
+	taken := make(map[uuid.UUID]int)
+	for _, i := range valid {
+		reservation := reservations[orderWrites[i].CustomerID]
+		if taken[orderWrites[i].CustomerID] == reservation.Granted {
+			results[i].Err = quotaExceeded(reservation)
+			continue
+		}
+		taken[orderWrites[i].CustomerID]++
+		reserved = append(reserved, i)
+	}
+
+	for start := 0; start < len(reserved); start += createBatchSize {
+		end := start + createBatchSize
+		if end > len(reserved) {
+			end = len(reserved)
+		}
+		s.createBatch(ctx, newOrders, reserved[start:end], results)
+	}
+
+	// Give back the quota of orders that weren't created
+	notCreated := make(map[uuid.UUID]int)
+	for _, i := range reserved {
+		if results[i].Err != nil {
+			notCreated[orderWrites[i].CustomerID]++
+			continue
+		}
+		orderCreated(ctx, results[i].Order, s.rdb)
+	}
+	for customerID, n := range notCreated {
+		releaseOrders(ctx, s.quota, reservations[customerID], n)
+	}
+	return results
+}
+
+// createBatch creates the orders of newOrders at indexes at once, recording
+// the outcome in results. If that fails, e.g. because one references a
+// missing product, they are retried one by one so the failure is attributed
+// to the right order.
+func (s *Orders) createBatch(ctx context.Context, newOrders []repository.NewOrder, indexes []int, results []CreateResult) {
+	batch := make([]repository.NewOrder, len(indexes))
+	for j, i := range indexes {
+		batch[j] = newOrders[i]
+	}
+
+	created, err := s.orders.CreateBatch(ctx, batch)
+	if err == nil {
+		for j, i := range indexes {
+			results[i].Order = created[j]
+		}
+		return
+	}
+
+	// A single order failed, or time ran out and retrying one by one would
+	// only fail the same way
+	if len(indexes) == 1 || ctx.Err() != nil {
+		for _, i := range indexes {
+			results[i].Err = storage(err, "Failed to create order")
+		}
+		return
+	}
+	for _, i := range indexes {
+		s.createBatch(ctx, newOrders, []int{i}, results)
+	}
+}
+
+// prepare checks that the order may be created, and snapshots its shipping
+// address
+func (s *Orders) prepare(ctx context.Context, orderWrite models.OrderWrite) (repository.NewOrder, error) {
+	if err := invalid(orderWrite); err != nil {
+		return repository.NewOrder{}, err
+	}
+	if !auth.FromContext(ctx).MayAccessCustomer(orderWrite.CustomerID) {
+		return repository.NewOrder{}, fail(models.CodeForbidden, "Orders can only be created for the authenticated customer")
+	}
+
+	newOrder := repository.NewOrder{OrderWrite: orderWrite}
+	if orderWrite.ShippingAddressID != nil {
+		var err error
+		newOrder.ShippingAddress, err = s.customers.ShippingAddress(ctx, orderWrite.CustomerID, *orderWrite.ShippingAddressID)
+		if err == repository.ErrNotFound {
+			return repository.NewOrder{}, fail(models.CodeInvalidShippingAddress, "Invalid shipping address")
+		} else if err != nil {
+			return repository.NewOrder{}, storage(err, "Failed to retrieve shipping address")
+		}
+	}
+	return newOrder, nil
+}
+
+// quotaExceeded is the error of an order over the customer's daily quota
+func quotaExceeded(reservation ratelimit.Reservation) error {
+	return &Error{
+		Code:       models.CodeQuotaExceeded,
+		Detail:     fmt.Sprintf("Daily order quota exceeded, at most %d orders can be placed per day", reservation.Limit),
+		RetryAfter: reservation.ResetIn,
+	}
+}
+
+// Get returns the order. Customers only find their own orders; anyone
+// else's are reported missing rather than forbidden so their IDs can't be
+// probed.
+func (s *Orders) Get(ctx context.Context, id uuid.UUID) (models.OrderRead, error) {
+	orderRead, err := s.orders.Get(ctx, id)
+	if customerID := auth.FromContext(ctx).CustomerScope(); err == nil && customerID != nil && orderRead.CustomerID != *customerID {
+		err = repository.ErrNotFound
+	}
+	if err == repository.ErrNotFound {
+		return models.OrderRead{}, fail(models.CodeNotFound, "Order not found")
+	} else if err != nil {
+		return models.OrderRead{}, storage(err, "Failed to retrieve order")
+	}
+	return orderRead, nil
+}
+
+// List lists the orders matching filter, newest first. A zero Limit is
+// DefaultOrderListLimit. Customers may only list their own orders, and
+// without a CustomerID get just those.
+func (s *Orders) List(ctx context.Context, filter repository.OrderFilter) ([]models.OrderRead, error) {
+	scope := auth.FromContext(ctx).CustomerScope()
+	if filter.CustomerID == nil {
+		filter.CustomerID = scope
+	} else if scope != nil && *scope != *filter.CustomerID {
+		return nil, fail(models.CodeForbidden, "Orders of other customers can't be listed")
+	}
+
+	filter, err := page(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	orderList, err := s.orders.List(ctx, filter)
+	if err != nil {
+		return nil, storage(err, "Failed to retrieve orders")
+	}
+	return orderList, nil
+}
+
+// ListByCustomers lists the same page of each customer's orders matching
+// filter, newest first, as List would. filter.CustomerID is ignored, and
+// customers the principal may not see are left out.
+func (s *Orders) ListByCustomers(ctx context.Context, customerIDs []uuid.UUID, filter repository.OrderFilter) (map[uuid.UUID][]models.OrderRead, error) {
+	filter, err := page(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := auth.FromContext(ctx)
+	var visible []uuid.UUID
+	for _, customerID := range customerIDs {
+		if principal.MayAccessCustomer(customerID) {
+			visible = append(visible, customerID)
+		}
+	}
+
+	orderList, err := s.orders.ListByCustomers(ctx, visible, filter)
+	if err != nil {
+		return nil, storage(err, "Failed to retrieve orders")
+	}
+	byCustomer := make(map[uuid.UUID][]models.OrderRead, len(visible))
+	for _, orderRead := range orderList {
+		byCustomer[orderRead.CustomerID] = append(byCustomer[orderRead.CustomerID], orderRead)
+	}
+	return byCustomer, nil
+}
+
+// page applies the default limit to filter and checks its page is in range
+func page(filter repository.OrderFilter) (repository.OrderFilter, error) {
+	if filter.Limit == 0 {
+		filter.Limit = DefaultOrderListLimit
+	}
+	if filter.Limit < 1 || filter.Limit > MaxOrderListLimit {
+		return filter, fail(models.CodeInvalidParameter, fmt.Sprintf("limit must be between 1 and %d", MaxOrderListLimit))
+	}
+	if filter.Offset < 0 {
+		return filter, fail(models.CodeInvalidParameter, "offset must not be negative")
+	}
+	return filter, nil
+}
+
+// Subscription is an order's status history followed by its live events.
+// Live is closed if the subscriber falls too far behind; it is expected to
+// watch again from the last event it received.
+type Subscription struct {
+	History []models.OrderEvent
+	Live    <-chan models.OrderEvent
+	// Close ends the subscription
+	Close func()
+}
+
+// Watch subscribes to the order's status events after afterID, along with
+// those shortly before it that may have committed since; see
+// events.CommitWindow. Events may be both in History and on Live; those
+// already seen are to be skipped by ID.
+func (s *Orders) Watch(ctx context.Context, id uuid.UUID, afterID int64) (*Subscription, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return nil, err
+	}
+
+	// Subscribe before reading the history so nothing applied in between
+	// is missed
+	live, cancel := s.broker.Subscribe(func(e models.OrderEvent) bool { return e.OrderID == id })
+
+	history, err := s.orders.History(ctx, id, afterID)
+	if err != nil {
+		cancel()
+		return nil, storage(err, "Failed to retrieve order history")
+	}
+	return &Subscription{History: history, Live: live, Close: cancel}, nil
+}
+
+// WatchCustomer subscribes to the status events of all of the customer's
+// orders. A customer's full history is unbounded, so History is only read
+// when resuming after afterID, as Watch reads it; otherwise it is empty.
+func (s *Orders) WatchCustomer(ctx context.Context, customerID uuid.UUID, afterID int64, resume bool) (*Subscription, error) {
+	if !auth.FromContext(ctx).MayAccessCustomer(customerID) {
+		return nil, fail(models.CodeForbidden, "Access to other customers is not allowed")
+	}
+
+	live, cancel := s.broker.Subscribe(func(e models.OrderEvent) bool { return e.CustomerID == customerID })
+	if !resume {
+		return &Subscription{Live: live, Close: cancel}, nil
+	}
+
+	history, err := s.orders.CustomerHistory(ctx, customerID, afterID)
+	if err != nil {
+		cancel()
+		return nil, storage(err, "Failed to retrieve order history")
+	}
+	return &Subscription{History: history, Live: live, Close: cancel}, nil
+}
+
+// reserveOrders takes n orders from the customer's daily quota. If Redis is
+// unavailable the orders are allowed rather than failing the request.
+func reserveOrders(ctx context.Context, quota *ratelimit.OrderQuota, customerID uuid.UUID, n int) ratelimit.Reservation {
+	reservation, err := quota.Reserve(ctx, customerID, n)
+	if err != nil {
+		slog.WarnContext(ctx, "Order quota unavailable, allowing orders", logging.CustomerIDKey, customerID.String(), "error", err)
+		return ratelimit.Reservation{Granted: n}
+	}
+	if refused := n - reservation.Granted; refused > 0 {
+		metrics.OrderQuotaExceeded.Add(float64(refused))
+		slog.InfoContext(ctx, "Daily order quota exceeded", logging.CustomerIDKey, customerID.String(), "refused", refused, "quota", reservation.Limit)
+	}
+	return reservation
+}
+
+// releaseOrders gives back reserved orders that weren't created. It goes
+// ahead even if the request has timed out, which is often why they weren't.
+func releaseOrders(ctx context.Context, quota *ratelimit.OrderQuota, reservation ratelimit.Reservation, n int) {
+	if err := quota.Release(context.WithoutCancel(ctx), reservation, n); err != nil {
+		slog.WarnContext(ctx, "Unable to release order quota", "error", err)
+	}
+}
+
+// orderCreated records a newly created order in the logs and metrics and
+// publishes its payment request in the background
+func orderCreated(ctx context.Context, orderRead models.OrderRead, rdb *redis.Client) {
+	metrics.OrdersCreated.WithLabelValues(string(orderRead.Status)).Inc()
+	slog.InfoContext(ctx, "Order created", append(logging.Order(orderRead.ID, orderRead.CustomerID), "status", orderRead.Status, "amount", orderRead.Amount)...)
+	dispatchPayment(ctx, orderRead, rdb)
+}
+
+// pendingPublishes tracks payment requests still being published, so that
+// shutdown can wait for them.
+var pendingPublishes sync.WaitGroup
+
+// dispatchPayment publishes the payment request in the background, in the
+// trace and request of ctx but outliving its cancellation.
+func dispatchPayment(ctx context.Context, orderRead models.OrderRead, rdb *redis.Client) {
+	ctx = logging.WithRequestID(tracing.Detach(ctx), logging.RequestID(ctx))
+	pendingPublishes.Add(1)
+	go func() {
+		defer pendingPublishes.Done()
+		sendOrderForPayment(ctx, orderRead, rdb)
+	}()
+}
+
+// WaitForPublishes blocks until every dispatched payment request has been
+// published, or ctx is done.
+func WaitForPublishes(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		pendingPublishes.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func sendOrderForPayment(ctx context.Context, orderRead models.OrderRead, rdb *redis.Client) {
+	ctx, span := tracing.StartPublish(ctx, "payment_requests")
+	span.SetAttributes(attribute.String("order_id", orderRead.ID.String()))
+
+	// Send payment request to payment service
+	messageID := uuid.NewString()
+	paymentRequest := map[string]interface{}{
+		"message_id":  messageID,
+		"request_id":  logging.RequestID(ctx),
+		"order_id":    orderRead.ID,
+		"amount":      orderRead.Amount,
+		"customer_id": orderRead.CustomerID,
+		"product_id":  orderRead.ProductID,
+		"created_at":  orderRead.CreatedAt,
+		"updated_at":  orderRead.UpdatedAt,
+		"sent_at":     time.Now(),
+		// The payment service continues this trace when processing it
+		"trace_context": tracing.Inject(ctx),
+	}
+	paymentRequestJSON, _ := json.Marshal(paymentRequest)
+	err := rdb.Publish(ctx, "payment_requests", paymentRequestJSON).Err()
+	metrics.Publish("payment_requests", err)
+	tracing.End(span, err)
+
+	attrs := append(logging.Order(orderRead.ID, orderRead.CustomerID), logging.MessageIDKey, messageID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to publish payment request", append(attrs, "error", err)...)
+		return
+	}
+	slog.InfoContext(ctx, "Payment request published", attrs...)
+}
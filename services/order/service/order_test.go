@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"order/auth"
+	"order/events"
+	"order/models"
+	"order/ratelimit"
+	"order/repository"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrdersList(t *testing.T) {
+	store := repository.NewMemory()
+	orders := NewOrders(store.Repositories().Orders, nil, nil, nil, nil)
+
+	customerID := uuid.New()
+	for i := 0; i < DefaultOrderListLimit+1; i++ {
+		store.AddOrder(models.OrderRead{ID: uuid.New(), CustomerID: customerID, Status: models.Pending})
+	}
+	store.AddOrder(models.OrderRead{ID: uuid.New(), CustomerID: uuid.New(), Status: models.Pending})
+
+	t.Run("default limit", func(t *testing.T) {
+		orderList, err := orders.List(context.Background(), repository.OrderFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, orderList, DefaultOrderListLimit)
+	})
+
+	t.Run("limit out of range", func(t *testing.T) {
+		_, err := orders.List(context.Background(), repository.OrderFilter{Limit: MaxOrderListLimit + 1})
+
+		var serviceErr *Error
+		if assert.ErrorAs(t, err, &serviceErr) {
+			assert.Equal(t, models.CodeInvalidParameter, serviceErr.Code)
+			assert.Equal(t, "limit must be between 1 and 200", serviceErr.Detail)
+		}
+	})
+
+	t.Run("customers are scoped to their own orders", func(t *testing.T) {
+		otherID := uuid.New()
+		ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Role: models.RoleCustomer, CustomerID: &otherID})
+
+		orderList, err := orders.List(ctx, repository.OrderFilter{})
+		assert.NoError(t, err)
+		assert.Empty(t, orderList)
+
+		_, err = orders.List(ctx, repository.OrderFilter{CustomerID: &customerID})
+
+		var serviceErr *Error
+		if assert.ErrorAs(t, err, &serviceErr) {
+			assert.Equal(t, models.CodeForbidden, serviceErr.Code)
+		}
+	})
+
+	t.Run("pages of several customers", func(t *testing.T) {
+		otherID := uuid.New()
+		ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Role: models.RoleCustomer, CustomerID: &otherID})
+
+		byCustomer, err := orders.ListByCustomers(context.Background(), []uuid.UUID{customerID, otherID}, repository.OrderFilter{Limit: 2})
+		assert.NoError(t, err)
+		assert.Len(t, byCustomer[customerID], 2)
+		assert.Empty(t, byCustomer[otherID])
+
+		byCustomer, err = orders.ListByCustomers(ctx, []uuid.UUID{customerID, otherID}, repository.OrderFilter{})
+		assert.NoError(t, err)
+		assert.Empty(t, byCustomer)
+	})
+}
+
+func TestOrdersCreateMany(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when starting miniredis", err)
+	}
+	defer mr.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	defer WaitForPublishes(context.Background())
+
+	store := repository.NewMemory()
+	repos := store.Repositories()
+	orders := NewOrders(repos.Orders, repos.Customers, nil, rdb, ratelimit.NewOrderQuota(rdb, 2))
+
+	customerID, productID := uuid.New(), uuid.New()
+	store.AddCustomer(customerID)
+	store.AddProduct(models.ProductRead{ID: productID, Name: "Widget", Price: 10})
+	valid := models.OrderWrite{CustomerID: customerID, ProductID: productID, Amount: 10}
+
+	t.Run("each order succeeds or fails on its own", func(t *testing.T) {
+		missingProduct := valid
+		missingProduct.ProductID = uuid.New()
+
+		results := orders.CreateMany(context.Background(), []models.OrderWrite{valid, {CustomerID: customerID}, missingProduct, valid})
+		if !assert.Len(t, results, 4) {
+			return
+		}
+
+		assert.NoError(t, results[0].Err)
+		assert.Equal(t, customerID, results[0].Order.CustomerID)
+
+		var serviceErr *Error
+		if assert.ErrorAs(t, results[1].Err, &serviceErr) {
+			assert.Equal(t, models.CodeValidationFailed, serviceErr.Code)
+			assert.Len(t, serviceErr.Fields, 2)
+		}
+
+		// The batch fails on the missing product, and is retried one by one
+		var storageErr *StorageError
+		if assert.ErrorAs(t, results[2].Err, &storageErr) {
+			assert.ErrorIs(t, storageErr.Err, repository.ErrUnknownProduct)
+		}
+
+		// Past the daily quota of 2
+		if assert.ErrorAs(t, results[3].Err, &serviceErr) {
+			assert.Equal(t, models.CodeQuotaExceeded, serviceErr.Code)
+			assert.Greater(t, serviceErr.RetryAfter, time.Duration(0))
+		}
+
+		// The order that failed to be created gave its quota back
+		results = orders.CreateMany(context.Background(), []models.OrderWrite{valid, valid})
+		assert.NoError(t, results[0].Err)
+		assert.ErrorAs(t, results[1].Err, &serviceErr)
+	})
+
+	t.Run("customers only place their own orders", func(t *testing.T) {
+		otherID := uuid.New()
+		ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Role: models.RoleCustomer, CustomerID: &otherID})
+
+		results := orders.CreateMany(ctx, []models.OrderWrite{valid})
+
+		var serviceErr *Error
+		if assert.ErrorAs(t, results[0].Err, &serviceErr) {
+			assert.Equal(t, models.CodeForbidden, serviceErr.Code)
+		}
+	})
+}
+
+func TestOrdersWatch(t *testing.T) {
+	store := repository.NewMemory()
+	broker := events.NewBroker()
+	orders := NewOrders(store.Repositories().Orders, nil, broker, nil, nil)
+
+	orderID, customerID := uuid.New(), uuid.New()
+	store.AddOrder(models.OrderRead{ID: orderID, CustomerID: customerID, Status: models.Pending})
+	store.AddEvent(models.OrderEvent{ID: 1, OrderID: orderID, CustomerID: customerID, Status: models.Pending})
+
+	t.Run("history then live events", func(t *testing.T) {
+		subscription, err := orders.Watch(context.Background(), orderID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer subscription.Close()
+
+		assert.Len(t, subscription.History, 1)
+
+		broker.Publish(models.OrderEvent{ID: 2, OrderID: orderID, CustomerID: customerID, Status: models.Completed})
+		select {
+		case event := <-subscription.Live:
+			assert.Equal(t, int64(2), event.ID)
+		case <-time.After(time.Second):
+			t.Fatal("no live event")
+		}
+	})
+
+	t.Run("orders of other customers are not found", func(t *testing.T) {
+		otherID := uuid.New()
+		ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Role: models.RoleCustomer, CustomerID: &otherID})
+
+		_, err := orders.Watch(ctx, orderID, 0)
+
+		var serviceErr *Error
+		if assert.ErrorAs(t, err, &serviceErr) {
+			assert.Equal(t, models.CodeNotFound, serviceErr.Code)
+		}
+	})
+}
+
+func TestOrdersWatchCustomer(t *testing.T) {
+	store := repository.NewMemory()
+	broker := events.NewBroker()
+	orders := NewOrders(store.Repositories().Orders, nil, broker, nil, nil)
+
+	customerID := uuid.New()
+	store.AddEvent(models.OrderEvent{ID: 1, OrderID: uuid.New(), CustomerID: customerID, Status: models.Pending})
+	store.AddEvent(models.OrderEvent{ID: 2, OrderID: uuid.New(), CustomerID: customerID, Status: models.Pending})
+
+	t.Run("history only when resuming", func(t *testing.T) {
+		subscription, err := orders.WatchCustomer(context.Background(), customerID, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer subscription.Close()
+		assert.Empty(t, subscription.History)
+
+		resumed, err := orders.WatchCustomer(context.Background(), customerID, 1, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resumed.Close()
+		if assert.Len(t, resumed.History, 1) {
+			assert.Equal(t, int64(2), resumed.History[0].ID)
+		}
+
+		broker.Publish(models.OrderEvent{ID: 3, OrderID: uuid.New(), CustomerID: customerID, Status: models.Completed})
+		select {
+		case event := <-subscription.Live:
+			assert.Equal(t, int64(3), event.ID)
+		case <-time.After(time.Second):
+			t.Fatal("no live event")
+		}
+	})
+
+	t.Run("other customers are forbidden", func(t *testing.T) {
+		otherID := uuid.New()
+		ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Role: models.RoleCustomer, CustomerID: &otherID})
+
+		_, err := orders.WatchCustomer(ctx, customerID, 0, false)
+
+		var serviceErr *Error
+		if assert.ErrorAs(t, err, &serviceErr) {
+			assert.Equal(t, models.CodeForbidden, serviceErr.Code)
+		}
+	})
+}
+
+func TestWaitForPublishes(t *testing.T) {
+	pendingPublishes.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, WaitForPublishes(ctx))
+
+	pendingPublishes.Done()
+	assert.NoError(t, WaitForPublishes(context.Background()))
+}
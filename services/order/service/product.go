@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+
+	"order/models"
+	"order/repository"
+
+	"github.com/google/uuid"
+)
+
+// Products creates and reads products
+type Products struct {
+	products repository.ProductRepository
+}
+
+// NewProducts returns the products stored in products
+func NewProducts(products repository.ProductRepository) *Products {
+	return &Products{products: products}
+}
+
+func (s *Products) Create(ctx context.Context, productWrite models.ProductWrite) (models.ProductRead, error) {
+	if err := invalid(productWrite); err != nil {
+		return models.ProductRead{}, err
+	}
+	productRead, err := s.products.Create(ctx, productWrite)
+	if err != nil {
+		return models.ProductRead{}, storage(err, "Failed to create product")
+	}
+	return productRead, nil
+}
+
+func (s *Products) Get(ctx context.Context, id uuid.UUID) (models.ProductRead, error) {
+	productRead, err := s.products.Get(ctx, id)
+	if err == repository.ErrNotFound {
+		return models.ProductRead{}, fail(models.CodeNotFound, "Product not found")
+	} else if err != nil {
+		return models.ProductRead{}, storage(err, "Failed to retrieve product")
+	}
+	return productRead, nil
+}
+
+// Update replaces the product's name and price
+func (s *Products) Update(ctx context.Context, id uuid.UUID, productWrite models.ProductWrite) (models.ProductRead, error) {
+	if err := invalid(productWrite); err != nil {
+		return models.ProductRead{}, err
+	}
+	productRead, err := s.products.Update(ctx, id, productWrite)
+	if err == repository.ErrNotFound {
+		return models.ProductRead{}, fail(models.CodeNotFound, "Product not found")
+	} else if err != nil {
+		return models.ProductRead{}, storage(err, "Failed to update product")
+	}
+	return productRead, nil
+}
+
+// GetMany returns the products with the given IDs by ID. Missing products
+// are left out.
+func (s *Products) GetMany(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]models.ProductRead, error) {
+	productList, err := s.products.GetMany(ctx, ids)
+	if err != nil {
+		return nil, storage(err, "Failed to retrieve products")
+	}
+	products := make(map[uuid.UUID]models.ProductRead, len(productList))
+	for _, productRead := range productList {
+		products[productRead.ID] = productRead
+	}
+	return products, nil
+}
@@ -0,0 +1,71 @@
+// Package service holds the rules of orders, products and customers that
+// every API of the service applies, so that the JSON/HTTP, gRPC and GraphQL
+// APIs behave the same.
+// Failures are returned as an *Error for the caller to act on, or a
+// *StorageError when storage failed; each API reports them its own way.
+package service
+
+import (
+	"time"
+
+	"order/models"
+	"order/problem"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Error is a failure caused by the request, reported to the caller as is
+type Error struct {
+	Code   models.ErrorCode
+	Detail string
+	// Fields lists the invalid fields of validation_failed errors
+	Fields []models.FieldError
+	// RetryAfter is how long until the request may succeed, if it's known
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string { return e.Detail }
+
+// StorageError is a failed storage call. Only Detail is meant for the
+// caller; Err is logged.
+type StorageError struct {
+	Detail string
+	Err    error
+}
+
+func (e *StorageError) Error() string { return e.Detail + ": " + e.Err.Error() }
+
+func (e *StorageError) Unwrap() error { return e.Err }
+
+func fail(code models.ErrorCode, detail string) error {
+	return &Error{Code: code, Detail: detail}
+}
+
+func storage(err error, detail string) error {
+	return &StorageError{Detail: detail, Err: err}
+}
+
+var validate = NewValidator()
+
+// NewValidator returns a validator naming fields by their JSON key, so that
+// validation problems point at the fields of the payload the client sent
+func NewValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(problem.JSONFieldName)
+	return v
+}
+
+// Validate checks v against its validation tags, as the service does for
+// everything it creates
+func Validate(v interface{}) error {
+	return validate.Struct(v)
+}
+
+// invalid checks v, returning a validation_failed *Error listing its
+// invalid fields
+func invalid(v interface{}) error {
+	if err := validate.Struct(v); err != nil {
+		return &Error{Code: models.CodeValidationFailed, Detail: "The request has invalid fields", Fields: problem.Fields(err)}
+	}
+	return nil
+}
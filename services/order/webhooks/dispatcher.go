@@ -0,0 +1,329 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"order/events"
+	"order/models"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Headers set on every delivery
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const deliveryColumns = "id, webhook_id, event_type, payload, status, attempts, response_status, last_error, next_attempt_at, created_at, updated_at"
+
+func scanDelivery(row interface{ Scan(...interface{}) error }, d *models.WebhookDelivery) error {
+	var payload []byte
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventType, &payload, &d.Status, &d.Attempts, &d.ResponseStatus, &d.LastError, &d.NextAttemptAt, &d.CreatedAt, &d.UpdatedAt)
+	d.Payload = payload
+	return err
+}
+
+// Sign returns the signature of a delivery body sent at timestamp, as carried
+// in the X-Webhook-Signature header. Receivers recompute it with their copy
+// of the secret to verify the delivery.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// job is a delivery claimed for an attempt along with its target
+type job struct {
+	delivery models.WebhookDelivery
+	url      string
+	secret   string
+}
+
+// Dispatcher turns order status changes into webhook deliveries and sends
+// them, retrying failed attempts with exponential backoff.
+//
+// Events are read from the order status history rather than straight off
+// the broker, and marked once their deliveries are enqueued in the same
+// transaction, so none are lost if the broker drops the subscription and
+// replicas don't enqueue them twice. The broker only serves to wake the
+// dispatcher up.
+type Dispatcher struct {
+	db     *sql.DB
+	client *http.Client
+
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+func NewDispatcher(db *sql.DB, client *http.Client) *Dispatcher {
+	return &Dispatcher{
+		db:           db,
+		client:       client,
+		MaxAttempts:  8,
+		BaseBackoff:  10 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: 5 * time.Second,
+		BatchSize:    50,
+	}
+}
+
+// Run enqueues and sends deliveries whenever an order event is published and
+// on every poll interval, until ctx is cancelled. Once the broker is closed
+// it only polls.
+func (d *Dispatcher) Run(ctx context.Context, broker *events.Broker) {
+	all := func(models.OrderEvent) bool { return true }
+	live, cancel := broker.Subscribe(all)
+	defer func() { cancel() }()
+
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-live:
+			if !ok {
+				cancel()
+				if broker.Closed() {
+					// Shutting down; a nil channel is never ready, so only
+					// the ticker wakes the dispatcher from now on
+					live = nil
+				} else {
+					// Fell behind; PendingWebhooks picks up any events not
+					// enqueued yet
+					live, cancel = broker.Subscribe(all)
+				}
+			}
+		case <-ticker.C:
+		}
+
+		if err := d.Enqueue(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error enqueuing webhook deliveries", "error", err)
+		}
+		if err := d.DeliverDue(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error sending webhook deliveries", "error", err)
+		}
+	}
+}
+
+// Enqueue creates a pending delivery for every active webhook subscribed to
+// each order event not enqueued yet, up to BatchSize events.
+func (d *Dispatcher) Enqueue(ctx context.Context) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	pending, err := events.PendingWebhooks(ctx, tx, d.BatchSize)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	for _, event := range pending {
+		eventType := models.OrderEventType(event.Status)
+		payload, err := json.Marshal(models.WebhookPayload{
+			ID:        strconv.FormatInt(event.ID, 10),
+			Type:      eventType,
+			CreatedAt: event.CreatedAt,
+			Data:      event,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO webhook_deliveries (webhook_id, event_type, payload) SELECT id, $1, $2 FROM webhooks WHERE active AND event_types @> jsonb_build_array($1::text)",
+			eventType, payload)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE order_status_history SET webhooks_enqueued = TRUE WHERE id = $1", event.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeliverDue attempts every pending delivery whose next attempt is due
+func (d *Dispatcher) DeliverDue(ctx context.Context) error {
+	// Claim the batch by pushing its next attempt out, so that a concurrent
+	// poll skips it while it is in flight.
+	rows, err := d.db.QueryContext(ctx,
+		`UPDATE webhook_deliveries d SET next_attempt_at = NOW() + INTERVAL '5 minutes'
+		FROM webhooks w
+		WHERE d.webhook_id = w.id AND d.id IN (
+			SELECT id FROM webhook_deliveries WHERE status = $1 AND next_attempt_at <= NOW() ORDER BY next_attempt_at LIMIT $2 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.webhook_id, d.event_type, d.payload, d.status, d.attempts, d.response_status, d.last_error, d.next_attempt_at, d.created_at, d.updated_at, w.url, w.secret`,
+		models.DeliveryPending, d.BatchSize)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var jobs []job
+	for rows.Next() {
+		var j job
+		var payload []byte
+		dl := &j.delivery
+		if err := rows.Scan(&dl.ID, &dl.WebhookID, &dl.EventType, &payload, &dl.Status, &dl.Attempts, &dl.ResponseStatus, &dl.LastError, &dl.NextAttemptAt, &dl.CreatedAt, &dl.UpdatedAt, &j.url, &j.secret); err != nil {
+			return err
+		}
+		dl.Payload = payload
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j job) {
+			defer wg.Done()
+			if _, err := d.attempt(ctx, j); err != nil {
+				slog.ErrorContext(ctx, "Error recording webhook delivery", "delivery_id", j.delivery.ID, "webhook_id", j.delivery.WebhookID, "event_type", j.delivery.EventType, "error", err)
+			}
+		}(j)
+	}
+	wg.Wait()
+	return nil
+}
+
+// SendTest sends a test event to the webhook straight away and returns the
+// resulting delivery. It returns sql.ErrNoRows if the webhook doesn't exist.
+func (d *Dispatcher) SendTest(ctx context.Context, webhookID uuid.UUID) (*models.WebhookDelivery, error) {
+	var j job
+	if err := d.db.QueryRowContext(ctx, "SELECT url, secret FROM webhooks WHERE id = $1", webhookID).Scan(&j.url, &j.secret); err != nil {
+		return nil, err
+	}
+
+	id := uuid.New()
+	payload, err := json.Marshal(models.WebhookPayload{
+		ID:        id.String(),
+		Type:      models.WebhookTestEvent,
+		CreatedAt: time.Now().UTC(),
+		Data:      map[string]string{"webhook_id": webhookID.String()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = scanDelivery(d.db.QueryRowContext(ctx,
+		"INSERT INTO webhook_deliveries (id, webhook_id, event_type, payload) VALUES ($1, $2, $3, $4) RETURNING "+deliveryColumns,
+		id, webhookID, models.WebhookTestEvent, payload), &j.delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.attempt(ctx, j)
+}
+
+// attempt sends the delivery once and records the outcome, scheduling a
+// retry if it failed and attempts remain.
+func (d *Dispatcher) attempt(ctx context.Context, j job) (*models.WebhookDelivery, error) {
+	responseStatus, sendErr := d.send(ctx, j)
+
+	attempts := j.delivery.Attempts + 1
+	status := models.DeliverySucceeded
+	lastError := ""
+	nextAttemptAt := time.Now()
+	if sendErr != nil {
+		lastError = sendErr.Error()
+		status = models.DeliveryPending
+		nextAttemptAt = nextAttemptAt.Add(d.backoff(attempts))
+		if attempts >= d.MaxAttempts {
+			status = models.DeliveryFailed
+		}
+	}
+
+	var delivery models.WebhookDelivery
+	err := scanDelivery(d.db.QueryRowContext(ctx,
+		"UPDATE webhook_deliveries SET status = $2, attempts = $3, response_status = $4, last_error = $5, next_attempt_at = $6, updated_at = NOW() WHERE id = $1 RETURNING "+deliveryColumns,
+		j.delivery.ID, status, attempts, responseStatus, lastError, nextAttemptAt), &delivery)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (d *Dispatcher) send(ctx context.Context, j job) (*int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.url, bytes.NewReader(j.delivery.Payload))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, j.delivery.EventType)
+	req.Header.Set(DeliveryHeader, j.delivery.ID.String())
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(j.secret, timestamp, j.delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return &resp.StatusCode, nil
+}
+
+// backoff returns how long to wait before the attempt after the given one
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
+
+// ListDeliveries returns the delivery log of a webhook, most recent first
+func ListDeliveries(ctx context.Context, db *sql.DB, webhookID uuid.UUID) ([]models.WebhookDelivery, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC LIMIT 100", webhookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := scanDelivery(rows, &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
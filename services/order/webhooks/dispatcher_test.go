@@ -0,0 +1,213 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"order/events"
+	"order/models"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var deliveryRowColumns = []string{"id", "webhook_id", "event_type", "payload", "status", "attempts", "response_status", "last_error", "next_attempt_at", "created_at", "updated_at"}
+
+func TestSendTest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	secret := "0123456789abcdef"
+	received := make(chan *http.Request, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign(secret, r.Header.Get(TimestampHeader), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received <- r
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	dispatcher := NewDispatcher(db, receiver.Client())
+
+	t.Run("signed delivery succeeds", func(t *testing.T) {
+		webhookID := uuid.New()
+		now := time.Now()
+
+		mock.ExpectQuery("SELECT url, secret FROM webhooks WHERE id = \\$1").
+			WithArgs(webhookID).
+			WillReturnRows(sqlmock.NewRows([]string{"url", "secret"}).AddRow(receiver.URL, secret))
+		mock.ExpectQuery("INSERT INTO webhook_deliveries").
+			WithArgs(sqlmock.AnyArg(), webhookID, models.WebhookTestEvent, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(deliveryRowColumns).
+				AddRow(uuid.New(), webhookID, models.WebhookTestEvent, `{"type":"webhook.test"}`, models.DeliveryPending, 0, nil, "", now, now, now))
+		mock.ExpectQuery("UPDATE webhook_deliveries SET status").
+			WithArgs(sqlmock.AnyArg(), models.DeliverySucceeded, 1, http.StatusNoContent, "", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(deliveryRowColumns).
+				AddRow(uuid.New(), webhookID, models.WebhookTestEvent, `{"type":"webhook.test"}`, models.DeliverySucceeded, 1, http.StatusNoContent, "", now, now, now))
+
+		delivery, err := dispatcher.SendTest(context.Background(), webhookID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.DeliverySucceeded, delivery.Status)
+		assert.Equal(t, http.StatusNoContent, *delivery.ResponseStatus)
+
+		r := <-received
+		assert.Equal(t, models.WebhookTestEvent, r.Header.Get(EventHeader))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("webhook not found", func(t *testing.T) {
+		webhookID := uuid.New()
+
+		mock.ExpectQuery("SELECT url, secret FROM webhooks WHERE id = \\$1").
+			WithArgs(webhookID).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := dispatcher.SendTest(context.Background(), webhookID)
+		assert.Equal(t, sql.ErrNoRows, err)
+	})
+}
+
+func TestAttemptSchedulesRetry(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	dispatcher := NewDispatcher(db, receiver.Client())
+	dispatcher.MaxAttempts = 3
+	now := time.Now()
+
+	t.Run("retry pending", func(t *testing.T) {
+		j := job{delivery: models.WebhookDelivery{ID: uuid.New(), Attempts: 1, Payload: json.RawMessage(`{}`)}, url: receiver.URL, secret: "secret"}
+
+		mock.ExpectQuery("UPDATE webhook_deliveries SET status").
+			WithArgs(j.delivery.ID, models.DeliveryPending, 2, http.StatusServiceUnavailable, "receiver responded with 503 Service Unavailable", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(deliveryRowColumns).
+				AddRow(j.delivery.ID, uuid.New(), "order.completed", `{}`, models.DeliveryPending, 2, http.StatusServiceUnavailable, "receiver responded with 503 Service Unavailable", now, now, now))
+
+		delivery, err := dispatcher.attempt(context.Background(), j)
+		assert.NoError(t, err)
+		assert.Equal(t, models.DeliveryPending, delivery.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		j := job{delivery: models.WebhookDelivery{ID: uuid.New(), Attempts: 2, Payload: json.RawMessage(`{}`)}, url: receiver.URL, secret: "secret"}
+
+		mock.ExpectQuery("UPDATE webhook_deliveries SET status").
+			WithArgs(j.delivery.ID, models.DeliveryFailed, 3, http.StatusServiceUnavailable, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(deliveryRowColumns).
+				AddRow(j.delivery.ID, uuid.New(), "order.completed", `{}`, models.DeliveryFailed, 3, http.StatusServiceUnavailable, "", now, now, now))
+
+		delivery, err := dispatcher.attempt(context.Background(), j)
+		assert.NoError(t, err)
+		assert.Equal(t, models.DeliveryFailed, delivery.Status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestEnqueue(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dispatcher := NewDispatcher(db, http.DefaultClient)
+	orderID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM order_status_history WHERE NOT webhooks_enqueued ORDER BY id LIMIT \\$1 FOR UPDATE SKIP LOCKED").
+		WithArgs(dispatcher.BatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "customer_id", "status", "created_at"}).
+			AddRow(5, orderID, uuid.New(), models.Completed, time.Now()).
+			AddRow(6, orderID, uuid.New(), models.AwaitingShipment, time.Now()))
+	mock.ExpectExec("INSERT INTO webhook_deliveries").
+		WithArgs("order.completed", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE order_status_history SET webhooks_enqueued = TRUE WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO webhook_deliveries").
+		WithArgs("order.awaiting_shipment", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE order_status_history SET webhooks_enqueued = TRUE WHERE id = \\$1").
+		WithArgs(6).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, dispatcher.Enqueue(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBackoff(t *testing.T) {
+	dispatcher := NewDispatcher(nil, nil)
+	dispatcher.BaseBackoff = time.Second
+	dispatcher.MaxBackoff = 10 * time.Second
+
+	assert.Equal(t, time.Second, dispatcher.backoff(1))
+	assert.Equal(t, 2*time.Second, dispatcher.backoff(2))
+	assert.Equal(t, 8*time.Second, dispatcher.backoff(4))
+	assert.Equal(t, 10*time.Second, dispatcher.backoff(5))
+	assert.Equal(t, 10*time.Second, dispatcher.backoff(50))
+}
+
+// unavailableDB fails every connection, counting the attempts
+type unavailableDB struct {
+	attempts atomic.Int64
+}
+
+func (c *unavailableDB) Connect(context.Context) (driver.Conn, error) {
+	c.attempts.Add(1)
+	return nil, errors.New("database unavailable")
+}
+
+func (c *unavailableDB) Driver() driver.Driver { return nil }
+
+func TestRunAfterBrokerClosed(t *testing.T) {
+	connector := &unavailableDB{}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	dispatcher := NewDispatcher(db, http.DefaultClient)
+	dispatcher.PollInterval = time.Hour
+	broker := events.NewBroker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dispatcher.Run(ctx, broker)
+	}()
+
+	broker.Close()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	// At most one poll, opening a connection for Enqueue and one for
+	// DeliverDue, rather than resubscribing and polling in a loop
+	assert.LessOrEqual(t, connector.attempts.Load(), int64(2))
+}
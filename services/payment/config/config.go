@@ -0,0 +1,303 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the payment service configuration. Values are taken from, in
+// increasing order of precedence: the defaults, an optional YAML file,
+// environment variables and command line flags.
+type Config struct {
+	Port int `yaml:"port"`
+	// Workers is the number of payment requests processed concurrently
+	Workers int `yaml:"workers"`
+	// ShutdownTimeout bounds how long shutdown waits for in-flight payments
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	HTTP            HTTPConfig    `yaml:"http"`
+	Redis           RedisConfig   `yaml:"redis"`
+	Health          HealthConfig  `yaml:"health"`
+	Tracing         TracingConfig `yaml:"tracing"`
+	Log             LogConfig     `yaml:"log"`
+}
+
+type HTTPConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+}
+
+type RedisConfig struct {
+	Host         string        `yaml:"host"`
+	Port         int           `yaml:"port"`
+	Password     string        `yaml:"password"`
+	DB           int           `yaml:"db"`
+	TLS          bool          `yaml:"tls"`
+	DialTimeout  time.Duration `yaml:"dial_timeout"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// ConnectRetryTimeout is how long startup keeps retrying to connect
+	// while Redis isn't up yet, 0 to try once
+	ConnectRetryTimeout time.Duration `yaml:"connect_retry_timeout"`
+}
+
+type HealthConfig struct {
+	// CheckTimeout bounds each dependency check made by /readyz
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+}
+
+type TracingConfig struct {
+	// Exporter is where spans are sent: "none", "stdout" or "otlp"
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the URL of the OTLP/HTTP collector; plain http disables TLS
+	Endpoint string `yaml:"endpoint"`
+}
+
+type LogConfig struct {
+	// Level is the minimum level logged: "debug", "info", "warn" or "error"
+	Level string `yaml:"level"`
+	// Format is "json", or "text" for reading logs locally
+	Format string `yaml:"format"`
+}
+
+// Addr returns the host:port of the Redis server
+func (c RedisConfig) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+func Default() *Config {
+	return &Config{
+		Port:            8081,
+		Workers:         4,
+		ShutdownTimeout: 30 * time.Second,
+		HTTP: HTTPConfig{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+		},
+		Redis: RedisConfig{
+			Host:                "localhost",
+			Port:                6379,
+			DialTimeout:         5 * time.Second,
+			ReadTimeout:         3 * time.Second,
+			WriteTimeout:        3 * time.Second,
+			ConnectRetryTimeout: time.Minute,
+		},
+		Health: HealthConfig{
+			CheckTimeout: 2 * time.Second,
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+			Endpoint: "http://localhost:4318",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+	}
+}
+
+// setting binds a configuration field to its flag and environment variable
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value interface{}
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"port", "PAYMENT_SERVICE_PORT", "HTTP port", &c.Port},
+		{"workers", "PAYMENT_WORKERS", "number of payment requests processed concurrently", &c.Workers},
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long to wait for in-flight payments on shutdown", &c.ShutdownTimeout},
+		{"http-read-header-timeout", "HTTP_READ_HEADER_TIMEOUT", "time allowed to read request headers", &c.HTTP.ReadHeaderTimeout},
+		{"http-read-timeout", "HTTP_READ_TIMEOUT", "time allowed to read a request", &c.HTTP.ReadTimeout},
+		{"http-write-timeout", "HTTP_WRITE_TIMEOUT", "time allowed to write a response", &c.HTTP.WriteTimeout},
+		{"http-idle-timeout", "HTTP_IDLE_TIMEOUT", "how long idle keep-alive connections are kept", &c.HTTP.IdleTimeout},
+		{"redis-host", "REDIS_HOST", "Redis host", &c.Redis.Host},
+		{"redis-port", "REDIS_PORT", "Redis port", &c.Redis.Port},
+		{"redis-password", "REDIS_PASSWORD", "Redis password", &c.Redis.Password},
+		{"redis-db", "REDIS_DB", "Redis database number", &c.Redis.DB},
+		{"redis-tls", "REDIS_TLS", "connect to Redis over TLS", &c.Redis.TLS},
+		{"redis-dial-timeout", "REDIS_DIAL_TIMEOUT", "Redis dial timeout", &c.Redis.DialTimeout},
+		{"redis-read-timeout", "REDIS_READ_TIMEOUT", "Redis read timeout", &c.Redis.ReadTimeout},
+		{"redis-write-timeout", "REDIS_WRITE_TIMEOUT", "Redis write timeout", &c.Redis.WriteTimeout},
+		{"redis-connect-retry-timeout", "REDIS_CONNECT_RETRY_TIMEOUT", "how long to retry connecting to Redis on startup, 0 to try once", &c.Redis.ConnectRetryTimeout},
+		{"health-check-timeout", "HEALTH_CHECK_TIMEOUT", "timeout for each readiness check", &c.Health.CheckTimeout},
+		{"tracing-exporter", "TRACING_EXPORTER", "trace exporter: none, stdout or otlp", &c.Tracing.Exporter},
+		{"tracing-endpoint", "TRACING_ENDPOINT", "OTLP/HTTP collector URL", &c.Tracing.Endpoint},
+		{"log-level", "LOG_LEVEL", "minimum log level: debug, info, warn or error", &c.Log.Level},
+		{"log-format", "LOG_FORMAT", "log format: json or text", &c.Log.Format},
+	}
+}
+
+// ValidationError lists every problem found in the configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load builds the configuration from the command line arguments (without the
+// program name) and the environment. The YAML file is read from the -config
+// flag or the CONFIG_FILE environment variable, if either is set.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("payment", flag.ContinueOnError)
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "path to a YAML config file (env CONFIG_FILE)")
+	for _, s := range settings {
+		fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var problems []string
+	for _, s := range settings {
+		if raw := getenv(s.env); raw != "" {
+			if err := set(s.value, raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+			}
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				if err := set(s.value, f.Value.String()); err != nil {
+					problems = append(problems, fmt.Sprintf("-%s: %v", s.flag, err))
+				}
+			}
+		}
+	})
+
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %v", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("unable to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+func set(value interface{}, raw string) error {
+	switch v := value.(type) {
+	case *string:
+		*v = raw
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		*v = b
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", raw)
+		}
+		*v = d
+	default:
+		return fmt.Errorf("unsupported setting type %T", value)
+	}
+	return nil
+}
+
+func (c *Config) validate() []string {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(validPort(c.Port), "port must be between 1 and 65535, got %d", c.Port)
+	check(c.Workers > 0, "workers must be positive, got %d", c.Workers)
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	check(c.HTTP.ReadHeaderTimeout >= 0, "http.read_header_timeout must not be negative")
+	check(c.HTTP.ReadTimeout >= 0, "http.read_timeout must not be negative")
+	check(c.HTTP.WriteTimeout >= 0, "http.write_timeout must not be negative")
+	check(c.HTTP.IdleTimeout >= 0, "http.idle_timeout must not be negative")
+
+	check(c.Redis.Host != "", "redis.host is required")
+	check(validPort(c.Redis.Port), "redis.port must be between 1 and 65535, got %d", c.Redis.Port)
+	check(c.Redis.DB >= 0, "redis.db must not be negative")
+	check(c.Redis.DialTimeout >= 0, "redis.dial_timeout must not be negative")
+	check(c.Redis.ReadTimeout >= 0, "redis.read_timeout must not be negative")
+	check(c.Redis.WriteTimeout >= 0, "redis.write_timeout must not be negative")
+	check(c.Redis.ConnectRetryTimeout >= 0, "redis.connect_retry_timeout must not be negative")
+
+	check(c.Health.CheckTimeout > 0, "health.check_timeout must be positive")
+
+	check(contains(traceExporters, c.Tracing.Exporter), "tracing.exporter must be one of %s, got %q", strings.Join(traceExporters, ", "), c.Tracing.Exporter)
+	if c.Tracing.Exporter == "otlp" {
+		check(validURL(c.Tracing.Endpoint), "tracing.endpoint must be an http or https URL, got %q", c.Tracing.Endpoint)
+	}
+
+	check(contains(logLevels, c.Log.Level), "log.level must be one of %s, got %q", strings.Join(logLevels, ", "), c.Log.Level)
+	check(contains(logFormats, c.Log.Format), "log.format must be one of %s, got %q", strings.Join(logFormats, ", "), c.Log.Format)
+
+	return problems
+}
+
+var (
+	traceExporters = []string{"none", "stdout", "otlp"}
+	logLevels      = []string{"debug", "info", "warn", "error"}
+	logFormats     = []string{"json", "text"}
+)
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+func validURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func env(values map[string]string) func(string) string {
+	return func(key string) string { return values[key] }
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := Load(nil, env(nil))
+		assert.NoError(t, err)
+		assert.Equal(t, 8081, cfg.Port)
+		assert.Equal(t, "localhost:6379", cfg.Redis.Addr())
+		assert.Equal(t, time.Minute, cfg.Redis.ConnectRetryTimeout)
+	})
+
+	t.Run("flags override environment which overrides the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "payment.yaml")
+		err := os.WriteFile(path, []byte("redis:\n  host: file-redis\n  password: secret\n  db: 3\n"), 0o600)
+		assert.NoError(t, err)
+
+		cfg, err := Load([]string{"-config", path, "-port", "9091"}, env(map[string]string{
+			"REDIS_HOST":           "env-redis",
+			"PAYMENT_SERVICE_PORT": "9090",
+		}))
+		assert.NoError(t, err)
+		assert.Equal(t, 9091, cfg.Port)
+		assert.Equal(t, "env-redis", cfg.Redis.Host)
+		assert.Equal(t, "secret", cfg.Redis.Password)
+		assert.Equal(t, 3, cfg.Redis.DB)
+	})
+
+	t.Run("lists every problem", func(t *testing.T) {
+		_, err := Load(nil, env(map[string]string{
+			"PAYMENT_SERVICE_PORT": "70000",
+			"REDIS_PORT":           "six",
+			"REDIS_READ_TIMEOUT":   "soon",
+		}))
+
+		var validationErr *ValidationError
+		assert.ErrorAs(t, err, &validationErr)
+		assert.Equal(t, []string{
+			`REDIS_PORT: "six" is not an integer`,
+			`REDIS_READ_TIMEOUT: "soon" is not a duration`,
+			"port must be between 1 and 65535, got 70000",
+		}, validationErr.Problems)
+	})
+}
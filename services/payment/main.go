@@ -2,18 +2,32 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"payment/config"
+	"payment/health"
+	"payment/logging"
+	"payment/metrics"
+	"payment/models"
+	"payment/openapi"
+	"payment/retry"
+	"payment/tracing"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-var ctx = context.Background()
-
 const (
 	PAYMENT_SUCCESS   = "success"
 	PAYMENT_FAILURE   = "failure"
@@ -21,68 +35,342 @@ const (
 )
 
 func main() {
-	redisPort := os.Getenv("REDIS_PORT")
-	paymentServicePort := os.Getenv("PAYMENT_SERVICE_PORT")
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	slog.SetDefault(logging.New(cfg.Log, os.Stdout).With("service", "payment-service"))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, "payment-service")
+	if err != nil {
+		fatal("Unable to set up tracing", err)
+	}
+
+	spec, err := openapi.Load()
+	if err != nil {
+		fatal("Unable to load the OpenAPI specification", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	rdb, err := connectToRedis("redis:" + redisPort)
+	rdb, err := connectToRedis(ctx, cfg.Redis)
 	if err != nil {
-		log.Fatalf("Unable to connect to Redis: %v\n", err)
+		fatal("Unable to connect to Redis", err)
 	}
 
-	done := make(chan bool)
-	go processPaymentRequests(rdb, done)
+	subscriber := rdb.Subscribe(context.Background(), "payment_requests")
 
-	fmt.Println("Payment Processing Service is running on port " + paymentServicePort)
-	http.ListenAndServe(":"+paymentServicePort, nil)
+	workersDone := make(chan error, 1)
+	go func() {
+		workersDone <- processPaymentRequests(ctx, subscriber, rdb, cfg.Workers)
+	}()
+
+	checker := health.NewChecker(cfg.Health.CheckTimeout)
+	checker.Add("redis", health.Redis(rdb))
+	checker.Add("payment_requests_subscriber", health.Subscription(subscriber))
+
+	server := &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.Port),
+		Handler:           setupRoutes(spec, checker),
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Payment Processing Service is running", "port", cfg.Port, "workers", cfg.Workers)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErr <- err
+		}
+	}()
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down, waiting for in-flight payments", "timeout", cfg.ShutdownTimeout.String())
+	case err := <-serverErr:
+		slog.Error("HTTP server failed", "error", err)
+		exitCode = 1
+	case err := <-workersDone:
+		slog.Error("Payment workers stopped unexpectedly", "error", err)
+		workersDone <- nil
+		exitCode = 1
+	}
+	// Stops the workers from taking new messages if they are still running
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down HTTP server", "error", err)
+		exitCode = 1
+	}
+
+	select {
+	case err := <-workersDone:
+		if err != nil {
+			slog.Error("Error stopping payment workers", "error", err)
+			exitCode = 1
+		}
+	case <-shutdownCtx.Done():
+		slog.Error("Timed out waiting for in-flight payments")
+		exitCode = 1
+	}
+
+	if err := subscriber.Close(); err != nil {
+		slog.Error("Error closing payment requests subscription", "error", err)
+	}
+	if err := rdb.Close(); err != nil {
+		slog.Error("Error closing Redis client", "error", err)
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+
+	slog.Info("Payment Processing Service stopped")
+	cancel()
+	os.Exit(exitCode)
 }
 
-func connectToRedis(addr string) (*redis.Client, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr: addr,
-	})
+// fatal logs the error that stops the service from starting and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
 
-	_, err := rdb.Ping(ctx).Result()
+// connectToRedis opens the client and waits for Redis to answer, for up to
+// cfg.ConnectRetryTimeout, so the service can start before Redis is up.
+func connectToRedis(ctx context.Context, cfg config.RedisConfig) (*redis.Client, error) {
+	opts := &redis.Options{
+		Addr:         cfg.Addr(),
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		DialTimeout:  cfg.DialTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: cfg.Host}
+	}
+	rdb := redis.NewClient(opts)
+
+	err := retry.Do(ctx, "redis", cfg.ConnectRetryTimeout, retry.Backoff{}, func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	})
 	if err != nil {
+		rdb.Close()
 		return nil, err
 	}
 
 	return rdb, nil
 }
 
-func notifyOrderService(orderID uuid.UUID, status string, rdb *redis.Client) {
+func notifyOrderService(ctx context.Context, orderID, customerID uuid.UUID, status string, rdb *redis.Client) {
+	ctx, span := tracing.StartPublish(ctx, "payment_results")
+	span.SetAttributes(attribute.String("order_id", orderID.String()), attribute.String("payment.status", status))
+
+	messageID := uuid.NewString()
 	notification := map[string]interface{}{
-		"order_id": orderID.String(),
-		"status":   status,
+		"message_id":  messageID,
+		"request_id":  logging.RequestID(ctx),
+		"order_id":    orderID.String(),
+		"customer_id": customerID.String(),
+		"status":      status,
+		"sent_at":     time.Now(),
+		// The order service continues this trace when applying the result
+		"trace_context": tracing.Inject(ctx),
 	}
 	notificationJSON, _ := json.Marshal(notification)
-	rdb.Publish(ctx, "payment_results", notificationJSON)
-	fmt.Printf("Payment result notification sent for order %s with status %s\n", orderID, status)
+	err := rdb.Publish(ctx, "payment_results", notificationJSON).Err()
+	metrics.Publish("payment_results", err)
+	tracing.End(span, err)
+
+	attrs := append(logging.Order(orderID, customerID), logging.MessageIDKey, messageID, "payment_status", status)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to publish payment result", append(attrs, "error", err)...)
+		return
+	}
+	slog.InfoContext(ctx, "Payment result published", attrs...)
 }
 
-func processPaymentRequests(rdb *redis.Client, done chan bool) {
-	subscriber := rdb.Subscribe(ctx, "payment_requests")
-	channel := subscriber.Channel()
-	for msg := range channel {
-		var paymentRequest map[string]interface{}
-		err := json.Unmarshal([]byte(msg.Payload), &paymentRequest)
-		if err != nil {
-			log.Printf("Error unmarshalling payment request: %v\n", err)
-			continue
+// routes maps every path the service serves to its handler. All but
+// Swagger UI are documented in spec, which is served at /openapi.json.
+func routes(spec *openapi.Spec, checker *health.Checker) map[string]http.Handler {
+	return map[string]http.Handler{
+		"/metrics":      metrics.Handler(),
+		"/healthz":      http.HandlerFunc(healthzHandler),
+		"/readyz":       readyzHandler(checker),
+		"/openapi.json": spec.Handler(),
+		"/docs/":        http.StripPrefix("/docs/", openapi.DocsHandler()),
+	}
+}
+
+// setupRoutes serves the routes, checking requests against spec first
+func setupRoutes(spec *openapi.Spec, checker *health.Checker) http.Handler {
+	mux := http.NewServeMux()
+	for path, handler := range routes(spec, checker) {
+		mux.Handle(path, metrics.Instrument(path, handler))
+	}
+	return logging.Middleware(spec.Middleware(mux))
+}
+
+// healthzHandler reports that the process is up. It checks no dependencies,
+// so a Redis outage doesn't get the service restarted.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.HealthReport{Status: models.HealthOK})
+}
+
+// readyzHandler reports whether Redis and the payment requests subscription
+// are usable, with the result of each check.
+func readyzHandler(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Run(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != models.HealthOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
 		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
 
-		status := PAYMENT_SUCCESS
-		if paymentRequest["amount"].(float64) > PAYMENT_THRESHOLD {
-			status = PAYMENT_FAILURE
+// processPaymentRequests consumes payment requests from the subscriber with
+// the given number of concurrent workers until ctx is cancelled. It then
+// unsubscribes and processes every message received until Redis confirms,
+// since pub/sub won't redeliver them. The caller closes the subscriber.
+func processPaymentRequests(ctx context.Context, subscriber *redis.PubSub, rdb *redis.Client, workers int) error {
+	// Wait for the subscription to be confirmed so a failure to subscribe
+	// surfaces here rather than as silently missing messages.
+	if _, err := subscriber.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
 		}
+		return fmt.Errorf("unable to subscribe to payment requests: %v", err)
+	}
+	channel := subscriber.ChannelWithSubscriptions(ctx, 100)
 
-		orderID, err := uuid.Parse(paymentRequest["order_id"].(string))
+	jobs := make(chan *redis.Message)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for msg := range jobs {
+				processPaymentRequest(worker, msg, rdb)
+			}
+		}(i)
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	done := ctx.Done()
+	for {
+		select {
+		case <-done:
+			// Redis confirms the unsubscription after sending every
+			// message published before it, which are still buffered.
+			done = nil
+			if err := subscriber.Unsubscribe(context.Background()); err != nil {
+				return fmt.Errorf("unable to unsubscribe from payment requests: %v", err)
+			}
+		case msg, ok := <-channel:
+			if !ok {
+				return errors.New("payment requests subscription closed")
+			}
+			switch msg := msg.(type) {
+			case *redis.Message:
+				jobs <- msg
+			case *redis.Subscription:
+				if done == nil && msg.Kind == "unsubscribe" && msg.Count == 0 {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// processPaymentRequest handles a single payment request. A panic is
+// recovered so that one malformed message can't take the worker down.
+func processPaymentRequest(worker int, msg *redis.Message, rdb *redis.Client) {
+	var sentAt time.Time
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic: %v", r)
+			slog.Error("Error processing payment request", "worker", worker, "payload", msg.Payload, "error", err)
+		}
+		metrics.Consume(msg.Channel, sentAt, err)
+	}()
+
+	sentAt, err = decidePayment(msg, rdb)
+}
+
+// decidePayment approves or declines the payment request and notifies the
+// order service. It returns when the request was sent, if known.
+func decidePayment(msg *redis.Message, rdb *redis.Client) (sentAt time.Time, err error) {
+	var paymentRequest map[string]interface{}
+	err = json.Unmarshal([]byte(msg.Payload), &paymentRequest)
+	if err != nil {
+		slog.Error("Error unmarshalling payment request", "channel", msg.Channel, "payload", msg.Payload, "error", err)
+		return time.Time{}, err
+	}
+	sentAt, _ = time.Parse(time.RFC3339Nano, fmt.Sprint(paymentRequest["sent_at"]))
+	customerID, _ := uuid.Parse(fmt.Sprint(paymentRequest["customer_id"]))
+
+	// The request ID is that of the request which created the order, so
+	// its whole journey can be found by it.
+	requestID, _ := paymentRequest["request_id"].(string)
+	ctx, span := tracing.StartConsume(logging.WithRequestID(context.Background(), requestID), msg.Channel, traceContext(paymentRequest["trace_context"]))
+	span.SetAttributes(attribute.String("order_id", fmt.Sprint(paymentRequest["order_id"])))
+	attrs := []interface{}{logging.MessageIDKey, paymentRequest["message_id"], logging.OrderIDKey, paymentRequest["order_id"], logging.CustomerIDKey, paymentRequest["customer_id"]}
+	defer func() {
+		tracing.End(span, err)
 		if err != nil {
-			log.Printf("Error parsing order ID: %v\n", err)
-			continue
+			slog.ErrorContext(ctx, "Error processing payment request", append(attrs, "error", err)...)
 		}
+	}()
 
-		notifyOrderService(orderID, status, rdb)
+	orderID, err := uuid.Parse(fmt.Sprint(paymentRequest["order_id"]))
+	if err != nil {
+		return sentAt, fmt.Errorf("unable to parse order ID: %v", err)
+	}
+
+	amount, ok := paymentRequest["amount"].(float64)
+	if !ok {
+		return sentAt, fmt.Errorf("invalid amount in payment request for order %s", orderID)
+	}
+
+	status := PAYMENT_SUCCESS
+	if amount > PAYMENT_THRESHOLD {
+		status = PAYMENT_FAILURE
+		metrics.Payments.WithLabelValues("declined", "amount_over_threshold").Inc()
+	} else {
+		metrics.Payments.WithLabelValues("approved", "within_threshold").Inc()
 	}
 
-	done <- true
+	span.SetAttributes(attribute.String("payment.status", status))
+	slog.InfoContext(ctx, "Payment decided", append(attrs, "payment_status", status, "amount", amount)...)
+	notifyOrderService(ctx, orderID, customerID, status, rdb)
+	return sentAt, nil
+}
+
+// traceContext converts the trace_context field of a decoded message back
+// into a propagation carrier.
+func traceContext(field interface{}) map[string]string {
+	values, _ := field.(map[string]interface{})
+	carrier := make(map[string]string, len(values))
+	for key, value := range values {
+		if s, ok := value.(string); ok {
+			carrier[key] = s
+		}
+	}
+	return carrier
 }
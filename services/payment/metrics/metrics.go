@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "payment_service"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// Payments counts payment decisions by outcome, "approved" or
+	// "declined", and the reason for it.
+	Payments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payments_total",
+		Help:      "Payment decisions, by outcome and reason.",
+	}, []string{"outcome", "reason"})
+
+	// MessagesPublished counts Redis messages published by channel and
+	// result, "ok" or "error".
+	MessagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_published_total",
+		Help:      "Redis messages published, by channel and result.",
+	}, []string{"channel", "result"})
+
+	// MessagesConsumed counts Redis messages received by channel and result,
+	// "ok" or "error".
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Redis messages consumed, by channel and result.",
+	}, []string{"channel", "result"})
+
+	// MessageLag observes the time between a message being sent and received
+	MessageLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "message_lag_seconds",
+		Help:      "Time between a Redis message being published and consumed, by channel.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 30, 60},
+	}, []string{"channel"})
+)
+
+// Publish records the outcome of publishing to channel
+func Publish(channel string, err error) {
+	MessagesPublished.WithLabelValues(channel, result(err)).Inc()
+}
+
+// Consume records the outcome of consuming a message from channel, and its
+// lag if the time it was sent is known.
+func Consume(channel string, sentAt time.Time, err error) {
+	MessagesConsumed.WithLabelValues(channel, result(err)).Inc()
+	if !sentAt.IsZero() {
+		MessageLag.WithLabelValues(channel).Observe(time.Since(sentAt).Seconds())
+	}
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Instrument records the count and latency of requests to the route
+func Instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+
+		status := strconv.Itoa(recorder.status)
+		httpRequests.WithLabelValues(route, r.Method, status).Inc()
+		httpDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder captures the response status
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,29 @@
+package models
+
+// ProblemContentType is the media type of Problem bodies
+const ProblemContentType = "application/problem+json"
+
+// CodeValidationFailed is the code of requests that don't match the API
+// specification, the same as the order service's
+const CodeValidationFailed = "validation_failed"
+
+// Problem is an RFC 7807 problem details body, shaped like the order
+// service's: besides the standard members it carries the error code, the
+// failed fields of a validation error and the request ID.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Code      string       `json:"code"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError is a request field that failed validation, and the rule it
+// failed
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
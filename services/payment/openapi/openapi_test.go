@@ -0,0 +1,81 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"payment/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSpec has a parameter to validate, which the payment service's own
+// routes don't
+const testSpec = `{
+  "openapi": "3.0.3",
+  "info": {"title": "Test", "version": "1.0.0"},
+  "paths": {
+    "/payments": {
+      "get": {
+        "parameters": [{"name": "limit", "in": "query", "required": true, "schema": {"type": "integer", "minimum": 1}}],
+        "responses": {"200": {"description": "OK"}}
+      }
+    }
+  }
+}`
+
+func TestLoad(t *testing.T) {
+	spec, err := Load()
+	assert.NoError(t, err)
+	assert.NotNil(t, spec.Doc.Paths.Find("/readyz"))
+
+	_, err = load([]byte(`{"openapi": "3.0.3", "paths": {}}`))
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	spec, err := load([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := spec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		return rr
+	}
+
+	t.Run("valid request", func(t *testing.T) {
+		assert.Equal(t, http.StatusTeapot, serve("/payments?limit=10").Code)
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for target, rule := range map[string]string{
+			"/payments":         "required",
+			"/payments?limit=0": "minimum",
+		} {
+			rr := serve(target)
+			assert.Equal(t, http.StatusBadRequest, rr.Code, target)
+			assert.Equal(t, models.ProblemContentType, rr.Header().Get("Content-Type"), target)
+
+			var result models.Problem
+			if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, models.CodeValidationFailed, result.Code, target)
+			if assert.Len(t, result.Errors, 1, target) {
+				assert.Equal(t, "limit", result.Errors[0].Field, target)
+				assert.Equal(t, rule, result.Errors[0].Rule, target)
+			}
+		}
+	})
+
+	t.Run("routes missing from the spec are left to the mux", func(t *testing.T) {
+		assert.Equal(t, http.StatusTeapot, serve("/docs/").Code)
+	})
+}
@@ -0,0 +1,119 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"payment/logging"
+	"payment/models"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+)
+
+// Middleware answers requests whose parameters or body don't match the
+// specification with a 400 validation_failed problem listing every invalid
+// field, as the order service does. Routes missing from the specification
+// are left to the mux.
+func (s *Spec) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := s.router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		err = openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:         true,
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			},
+		})
+		if err != nil {
+			w.Header().Set("Content-Type", models.ProblemContentType)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.Problem{
+				Type:      "urn:payment-service:problem:" + models.CodeValidationFailed,
+				Title:     http.StatusText(http.StatusBadRequest),
+				Status:    http.StatusBadRequest,
+				Detail:    "The request does not match the API specification",
+				Code:      models.CodeValidationFailed,
+				Errors:    Fields(err),
+				RequestID: logging.RequestID(r.Context()),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Fields lists the fields reported by an error of ValidateRequest. Body
+// fields are named by their JSON path and parameters by their name.
+func Fields(err error) []models.FieldError {
+	var fields []models.FieldError
+	for _, err := range flatten(err) {
+		var requestErr *openapi3filter.RequestError
+		if !errors.As(err, &requestErr) {
+			fields = append(fields, models.FieldError{Field: "request", Rule: "invalid", Message: err.Error()})
+			continue
+		}
+
+		field := "body"
+		if requestErr.Parameter != nil {
+			field = requestErr.Parameter.Name
+		}
+		schemaErrs := schemaErrors(requestErr.Err)
+		if len(schemaErrs) == 0 {
+			rule := "invalid"
+			if errors.Is(requestErr.Err, openapi3filter.ErrInvalidRequired) {
+				rule = "required"
+			}
+			message := requestErr.Reason
+			if requestErr.Err != nil {
+				message = requestErr.Err.Error()
+			}
+			fields = append(fields, models.FieldError{Field: field, Rule: rule, Message: message})
+			continue
+		}
+		for _, schemaErr := range schemaErrs {
+			path := field
+			if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+				path = strings.Join(pointer, ".")
+				if requestErr.Parameter != nil {
+					path = field + "." + path
+				}
+			}
+			fields = append(fields, models.FieldError{Field: path, Rule: schemaErr.SchemaField, Message: schemaErr.Reason})
+		}
+	}
+	return fields
+}
+
+// flatten lists the errors joined in a MultiError, recursively
+func flatten(err error) []error {
+	// Not errors.As, which would also unwrap a RequestError
+	multi, ok := err.(openapi3.MultiError)
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, err := range multi {
+		errs = append(errs, flatten(err)...)
+	}
+	return errs
+}
+
+func schemaErrors(err error) []*openapi3.SchemaError {
+	var schemaErrs []*openapi3.SchemaError
+	for _, err := range flatten(err) {
+		var schemaErr *openapi3.SchemaError
+		if errors.As(err, &schemaErr) {
+			schemaErrs = append(schemaErrs, schemaErr)
+		}
+	}
+	return schemaErrs
+}
@@ -1,25 +1,102 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"payment/config"
+	"payment/health"
+	"payment/metrics"
+	"payment/models"
+	"payment/openapi"
+	"payment/tracing"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var ctx = context.Background()
+
 func TestConnectToRedis(t *testing.T) {
-	// Start a mini Redis server
-	mr, err := miniredis.Run()
-	assert.NoError(t, err)
-	defer mr.Close()
+	t.Run("connects", func(t *testing.T) {
+		// Start a mini Redis server
+		mr, err := miniredis.Run()
+		assert.NoError(t, err)
+		defer mr.Close()
 
-	// Connect to the mini Redis server
-	rdb, err := connectToRedis(mr.Addr())
-	assert.NoError(t, err)
-	assert.NotNil(t, rdb)
+		// Connect to the mini Redis server
+		cfg := config.Default().Redis
+		cfg.Host = mr.Host()
+		cfg.Port, _ = strconv.Atoi(mr.Port())
+		rdb, err := connectToRedis(ctx, cfg)
+		assert.NoError(t, err)
+		assert.NotNil(t, rdb)
+	})
+
+	t.Run("retries until Redis is up", func(t *testing.T) {
+		mr := miniredis.NewMiniRedis()
+		port := freePort(t)
+		go func() {
+			time.Sleep(300 * time.Millisecond)
+			if err := mr.StartAddr(net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err != nil {
+				t.Errorf("an error '%s' was not expected when starting miniredis", err)
+			}
+		}()
+		defer mr.Close()
+
+		cfg := config.Default().Redis
+		cfg.Host, cfg.Port = "127.0.0.1", port
+		rdb, err := connectToRedis(ctx, cfg)
+		assert.NoError(t, err)
+		if rdb != nil {
+			rdb.Close()
+		}
+	})
+
+	t.Run("gives up when cancelled", func(t *testing.T) {
+		cfg := config.Default().Redis
+		cfg.Host, cfg.Port = "127.0.0.1", freePort(t)
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		rdb, err := connectToRedis(ctx, cfg)
+		assert.Error(t, err)
+		assert.Nil(t, rdb)
+	})
+
+	t.Run("no retry timeout tries once", func(t *testing.T) {
+		cfg := config.Default().Redis
+		cfg.Host, cfg.Port = "127.0.0.1", freePort(t)
+		cfg.ConnectRetryTimeout = 0
+		start := time.Now()
+		_, err := connectToRedis(ctx, cfg)
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
+// freePort returns a local port nothing listens on
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when finding a free port", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
 }
 
 func TestNotifyOrderService(t *testing.T) {
@@ -44,7 +121,7 @@ func TestNotifyOrderService(t *testing.T) {
 	// Send a notification
 	orderID := uuid.New()
 	status := PAYMENT_SUCCESS
-	notifyOrderService(orderID, status, rdb)
+	notifyOrderService(ctx, orderID, uuid.New(), status, rdb)
 
 	// Read the message from the channel
 	msg, err := subscriber.ReceiveMessage(ctx)
@@ -68,11 +145,15 @@ func TestProcessPaymentRequests(t *testing.T) {
 		Addr: mr.Addr(),
 	})
 
-	// Use a channel to signal when the processing is done
-	done := make(chan bool)
-
-	// Start processing payment requests
-	go processPaymentRequests(rdb, done)
+	// Start processing payment requests until the test is done
+	workerCtx, stop := context.WithCancel(ctx)
+	requests := rdb.Subscribe(ctx, "payment_requests")
+	defer requests.Close()
+	workersDone := make(chan error, 1)
+	go func() {
+		workersDone <- processPaymentRequests(workerCtx, requests, rdb, 2)
+	}()
+	waitForSubscribers(t, rdb, "payment_requests")
 
 	// Subscribe to the payment results channel
 	subscriber := rdb.Subscribe(ctx, "payment_results")
@@ -101,4 +182,348 @@ func TestProcessPaymentRequests(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, orderID.String(), notification["order_id"].(string))
 	assert.Equal(t, PAYMENT_SUCCESS, notification["status"].(string))
+
+	stop()
+	assert.NoError(t, <-workersDone)
+}
+
+func TestProcessPaymentRequestsFinishesInFlightOnShutdown(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	workerCtx, stop := context.WithCancel(ctx)
+	requests := rdb.Subscribe(ctx, "payment_requests")
+	defer requests.Close()
+	workersDone := make(chan error, 1)
+	go func() {
+		workersDone <- processPaymentRequests(workerCtx, requests, rdb, 1)
+	}()
+	waitForSubscribers(t, rdb, "payment_requests")
+
+	subscriber := rdb.Subscribe(ctx, "payment_results")
+	defer subscriber.Close()
+	_, err = subscriber.Receive(ctx)
+	assert.NoError(t, err)
+
+	declined := testutil.ToFloat64(metrics.Payments.WithLabelValues("declined", "amount_over_threshold"))
+	invalid := testutil.ToFloat64(metrics.MessagesConsumed.WithLabelValues("payment_requests", "error"))
+
+	// A malformed request must not take down the only worker
+	rdb.Publish(ctx, "payment_requests", `{"order_id": "`+uuid.New().String()+`", "amount": "lots"}`)
+
+	orderIDs := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, orderID := range orderIDs {
+		payload, err := json.Marshal(map[string]interface{}{
+			"order_id": orderID.String(),
+			"amount":   2000,
+		})
+		assert.NoError(t, err)
+		rdb.Publish(ctx, "payment_requests", payload)
+	}
+
+	// Every request received before shutdown is still answered
+	for _, orderID := range orderIDs {
+		msg, err := subscriber.ReceiveMessage(ctx)
+		assert.NoError(t, err)
+
+		var notification map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(msg.Payload), &notification))
+		assert.Equal(t, orderID.String(), notification["order_id"])
+		assert.Equal(t, PAYMENT_FAILURE, notification["status"])
+	}
+	assert.Equal(t, declined+3, testutil.ToFloat64(metrics.Payments.WithLabelValues("declined", "amount_over_threshold")))
+	assert.Equal(t, invalid+1, testutil.ToFloat64(metrics.MessagesConsumed.WithLabelValues("payment_requests", "error")))
+
+	stop()
+	select {
+	case err := <-workersDone:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers did not stop after cancellation")
+	}
+}
+
+func TestProcessPaymentRequestsDrainsBufferedOnShutdown(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	// Results are published over a single connection, held by a blocking
+	// pop until the service has been told to stop, so the requests stay
+	// buffered meanwhile
+	results := redis.NewClient(&redis.Options{
+		Addr:        mr.Addr(),
+		PoolSize:    1,
+		PoolTimeout: 10 * time.Second,
+	})
+	defer results.Close()
+	assert.NoError(t, results.Ping(ctx).Err())
+	go results.BLPop(ctx, 0, "release")
+	assert.Eventually(t, func() bool { return results.PoolStats().IdleConns == 0 }, time.Second, 10*time.Millisecond)
+
+	workerCtx, stop := context.WithCancel(ctx)
+	requests := rdb.Subscribe(ctx, "payment_requests")
+	defer requests.Close()
+	workersDone := make(chan error, 1)
+	go func() {
+		workersDone <- processPaymentRequests(workerCtx, requests, results, 1)
+	}()
+	waitForSubscribers(t, rdb, "payment_requests")
+
+	subscriber := rdb.Subscribe(ctx, "payment_results")
+	defer subscriber.Close()
+	_, err = subscriber.Receive(ctx)
+	assert.NoError(t, err)
+
+	const published = 20
+	for i := 0; i < published; i++ {
+		payload, err := json.Marshal(map[string]interface{}{
+			"order_id": uuid.New().String(),
+			"amount":   10,
+		})
+		assert.NoError(t, err)
+		rdb.Publish(ctx, "payment_requests", payload)
+	}
+	stop()
+	rdb.LPush(ctx, "release", "1")
+
+	select {
+	case err := <-workersDone:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers did not stop after cancellation")
+	}
+
+	// Every request published before shutdown is answered
+	for i := 0; i < published; i++ {
+		receiveCtx, cancel := context.WithTimeout(ctx, time.Second)
+		_, err := subscriber.ReceiveMessage(receiveCtx)
+		cancel()
+		if !assert.NoError(t, err, "result %d of %d", i+1, published) {
+			break
+		}
+	}
+}
+
+// waitForSubscribers blocks until the channel has a subscriber, so that
+// messages published by the test aren't lost.
+func waitForSubscribers(t *testing.T, rdb *redis.Client, channel string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		counts, err := rdb.PubSubNumSub(ctx, channel).Result()
+		assert.NoError(t, err)
+		if counts[channel] > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no subscribers on %s", channel)
+}
+
+func TestReadyzHandler(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	subscriber := rdb.Subscribe(ctx, "payment_requests")
+	_, err = subscriber.Receive(ctx)
+	assert.NoError(t, err)
+
+	checker := health.NewChecker(time.Second)
+	checker.Add("redis", health.Redis(rdb))
+	checker.Add("payment_requests_subscriber", health.Subscription(subscriber))
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := setupRoutes(spec, checker)
+
+	t.Run("liveness", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"status": "ok"}`, rr.Body.String())
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var report models.HealthReport
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&report))
+		assert.Equal(t, models.HealthOK, report.Status)
+		assert.Equal(t, models.HealthOK, report.Checks["redis"].Status)
+		assert.Equal(t, models.HealthOK, report.Checks["payment_requests_subscriber"].Status)
+	})
+
+	t.Run("subscription closed", func(t *testing.T) {
+		assert.NoError(t, subscriber.Close())
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+		var report models.HealthReport
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&report))
+		assert.Equal(t, models.HealthUnavailable, report.Status)
+		assert.Equal(t, models.HealthOK, report.Checks["redis"].Status)
+		assert.Equal(t, models.HealthUnavailable, report.Checks["payment_requests_subscriber"].Status)
+	})
+}
+
+func TestPaymentRequestTraceIsContinued(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	subscriber := rdb.Subscribe(ctx, "payment_results")
+	defer subscriber.Close()
+	_, err = subscriber.Receive(ctx)
+	assert.NoError(t, err)
+
+	// The order service's publish span
+	parentCtx, parent := otel.Tracer("test").Start(ctx, "payment_requests publish")
+	parent.End()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"order_id":      uuid.New().String(),
+		"amount":        100,
+		"trace_context": tracing.Inject(parentCtx),
+	})
+	assert.NoError(t, err)
+	processPaymentRequest(0, &redis.Message{Channel: "payment_requests", Payload: string(payload)}, rdb)
+
+	msg, err := subscriber.ReceiveMessage(ctx)
+	assert.NoError(t, err)
+
+	var notification struct {
+		TraceContext map[string]string `json:"trace_context"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(msg.Payload), &notification))
+
+	// The result carries the same trace on to the order service
+	resultCtx := tracing.Extract(ctx, notification.TraceContext)
+	assert.Equal(t, parent.SpanContext().TraceID(), trace.SpanContextFromContext(resultCtx).TraceID())
+
+	// Spans are recorded as they end: the parent, then the result publish
+	// nested in the request processing.
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 3) {
+		publish, process := spans[1], spans[2]
+		assert.Equal(t, "payment_results publish", publish.Name())
+		assert.Equal(t, "payment_requests process", process.Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), process.Parent().SpanID())
+		assert.Equal(t, process.SpanContext().SpanID(), publish.Parent().SpanID())
+	}
+}
+
+func TestPaymentResultCarriesCorrelationIDs(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+
+	subscriber := rdb.Subscribe(ctx, "payment_results")
+	defer subscriber.Close()
+	_, err = subscriber.Receive(ctx)
+	assert.NoError(t, err)
+
+	orderID := uuid.New()
+	customerID := uuid.New()
+	payload, err := json.Marshal(map[string]interface{}{
+		"message_id":  uuid.NewString(),
+		"request_id":  "checkout-42",
+		"order_id":    orderID.String(),
+		"customer_id": customerID.String(),
+		"amount":      100,
+	})
+	assert.NoError(t, err)
+	processPaymentRequest(0, &redis.Message{Channel: "payment_requests", Payload: string(payload)}, rdb)
+
+	msg, err := subscriber.ReceiveMessage(ctx)
+	assert.NoError(t, err)
+
+	var notification map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(msg.Payload), &notification))
+	assert.Equal(t, "checkout-42", notification["request_id"])
+	assert.Equal(t, orderID.String(), notification["order_id"])
+	assert.Equal(t, customerID.String(), notification["customer_id"])
+	_, err = uuid.Parse(fmt.Sprint(notification["message_id"]))
+	assert.NoError(t, err)
+}
+
+// TestRoutesMatchSpec fails when a route is added or removed without
+// updating openapi/openapi.json, or the other way round.
+func TestRoutesMatchSpec(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var specified []string
+	for path, item := range spec.Doc.Paths.Map() {
+		assert.NotNil(t, item.Get, path)
+		specified = append(specified, path)
+	}
+
+	// Swagger UI is how the spec is browsed rather than part of the API
+	undocumented := map[string]bool{"/docs/": true}
+
+	var routed []string
+	for path := range routes(spec, nil) {
+		if !undocumented[path] {
+			routed = append(routed, path)
+		}
+	}
+
+	assert.ElementsMatch(t, specified, routed)
+}
+
+func TestDocs(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := setupRoutes(spec, nil)
+
+	for path, contentType := range map[string]string{
+		"/openapi.json":              "application/json",
+		"/docs/":                     "text/html",
+		"/docs/swagger-ui-bundle.js": "javascript",
+		"/docs/swagger-ui.css":       "text/css",
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+		assert.True(t, strings.Contains(rr.Header().Get("Content-Type"), contentType), path)
+	}
 }
@@ -0,0 +1,85 @@
+// Package retry retries connecting to dependencies that aren't up yet or
+// have gone away, waiting longer after each failed attempt.
+//
+// It is a deliberate copy of the order service's retry package, since the
+// two services are separate modules. Keep the two in step when changing
+// either one.
+package retry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Backoff is an exponential backoff with jitter. The zero value waits
+// 250ms, doubling up to 5s.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+
+	attempts int
+}
+
+// Next returns how long to wait before the next attempt
+func (b *Backoff) Next() time.Duration {
+	initial, max := b.Initial, b.Max
+	if initial <= 0 {
+		initial = 250 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 5 * time.Second
+	}
+
+	delay := initial
+	for i := 0; i < b.attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	b.attempts++
+	// Up to a fifth less, so instances that lost a dependency together
+	// don't all retry together
+	return delay - time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// Reset starts the backoff over, after an attempt succeeded
+func (b *Backoff) Reset() {
+	b.attempts = 0
+}
+
+// Do calls attempt until it succeeds, waiting per backoff between attempts
+// and logging each failure. It gives up with the last error once timeout
+// has passed or ctx is done; a timeout of 0 makes a single attempt.
+func Do(ctx context.Context, name string, timeout time.Duration, backoff Backoff, attempt func(ctx context.Context) error) error {
+	deadline := time.Now().Add(timeout)
+	for attempts := 1; ; attempts++ {
+		err := attempt(ctx)
+		if err == nil {
+			if attempts > 1 {
+				slog.InfoContext(ctx, "Connected after retrying", "dependency", name, "attempts", attempts)
+			}
+			return nil
+		}
+
+		delay := backoff.Next()
+		if time.Now().Add(delay).After(deadline) {
+			if attempts > 1 {
+				return fmt.Errorf("%w (gave up after %d attempts)", err, attempts)
+			}
+			return err
+		}
+		slog.WarnContext(ctx, "Unable to connect, retrying", "dependency", name, "attempt", attempts, "retry_in", delay.String(), "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (gave up: %v)", err, ctx.Err())
+		}
+	}
+}